@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	cli "github.com/jawher/mow.cli"
+
+	"github.com/Financial-Times/go-logger/v2"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
+	pkghttp "github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/http"
+)
+
+const indexVersionLayout = "20060102150405"
+
+// indexCommands adds the subcommands managing the versioned indices behind the alias the application reads and writes.
+// A reindex creates a new index, fills it and swaps the alias once it is complete.
+func indexCommands(app *cli.Cli, accessConfig func() es.AccessConfig, alias *string, typeless *bool, log *logger.UPPLogger) {
+	newClient := func() es.Client {
+		client, err := es.NewClient(accessConfig(), pkghttp.NewHTTPClient(), log)
+		if err != nil {
+			log.WithError(err).Fatal("Could not create Elasticsearch client")
+		}
+		return client
+	}
+
+	app.Command("index", "Manage the versioned indices behind the alias", func(cmd *cli.Cmd) {
+		cmd.Command("create", "Create an index from the embedded reference schema", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--version] [--point-alias]"
+			version := cmd.String(cli.StringOpt{
+				Name:  "version",
+				Value: "",
+				Desc:  "Version suffix of the index name, the current UTC time when empty",
+			})
+			pointAlias := cmd.Bool(cli.BoolOpt{
+				Name:  "point-alias",
+				Value: false,
+				Desc:  "Whether the alias is pointed at the new index right away",
+			})
+
+			cmd.Action = func() {
+				if *version == "" {
+					*version = time.Now().UTC().Format(indexVersionLayout)
+				}
+				index := es.VersionedIndexName(*alias, *version)
+				client := newClient()
+				if err := es.CreateIndex(client, index, *typeless); err != nil {
+					log.WithError(err).Fatalf("Could not create index %s", index)
+				}
+				log.Infof("Created index %s", index)
+
+				if *pointAlias {
+					swapAlias(client, *alias, index, log)
+				}
+			}
+		})
+
+		cmd.Command("alias", "Point the alias at an index, removing it from the indices it pointed at before", func(cmd *cli.Cmd) {
+			cmd.Spec = "INDEX"
+			index := cmd.StringArg("INDEX", "", "Name of the index the alias is pointed at")
+
+			cmd.Action = func() {
+				swapAlias(newClient(), *alias, *index, log)
+			}
+		})
+
+		cmd.Command("list", "List the indices the alias points at", func(cmd *cli.Cmd) {
+			cmd.Action = func() {
+				indices, err := es.AliasedIndices(newClient(), *alias)
+				if err != nil {
+					log.WithError(err).Fatalf("Could not read alias %s", *alias)
+				}
+				log.Infof("Alias %s points at %v", *alias, indices)
+			}
+		})
+	})
+}
+
+func swapAlias(client es.Client, alias string, index string, log *logger.UPPLogger) {
+	previous, err := es.SwapAlias(client, alias, index)
+	if err != nil {
+		log.WithError(err).Fatalf("Could not point alias %s at index %s", alias, index)
+	}
+	log.Infof("Alias %s points at index %s, it pointed at %v before", alias, index, previous)
+}
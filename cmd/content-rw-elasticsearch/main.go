@@ -3,6 +3,7 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"os"
 	"time"
@@ -14,13 +15,19 @@ import (
 	"github.com/Financial-Times/upp-go-sdk/pkg/api"
 	"github.com/Financial-Times/upp-go-sdk/pkg/internalcontent"
 
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/admin"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/concept"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/deadletter"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/health"
 	pkghttp "github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/http"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/mapper"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/message"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/reenrich"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/retry"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/source"
 )
 
 func main() {
@@ -72,12 +79,66 @@ func main() {
 		Desc:   "The name of the elaticsearch index",
 		EnvVar: "ELASTICSEARCH_SAPI_INDEX",
 	})
+	esTypeless := app.Bool(cli.BoolOpt{
+		Name:   "elasticsearch-typeless",
+		Value:  false,
+		Desc:   "Whether the cluster is Elasticsearch 7+ or OpenSearch, where the collection is stored in a field instead of the mapping type",
+		EnvVar: "ELASTICSEARCH_TYPELESS",
+	})
+	esBulkEnabled := app.Bool(cli.BoolOpt{
+		Name:   "elasticsearch-bulk-enabled",
+		Value:  false,
+		Desc:   "Whether writes and deletes are batched through the Elasticsearch _bulk API, ignored with typeless indices",
+		EnvVar: "ELASTICSEARCH_BULK_ENABLED",
+	})
+	esBulkWorkers := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-bulk-workers",
+		Value:  2,
+		Desc:   "Number of concurrent bulk requests sent to Elasticsearch",
+		EnvVar: "ELASTICSEARCH_BULK_WORKERS",
+	})
+	esBulkActions := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-bulk-actions",
+		Value:  500,
+		Desc:   "Number of buffered operations that triggers a bulk request",
+		EnvVar: "ELASTICSEARCH_BULK_ACTIONS",
+	})
+	esBulkSize := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-bulk-size",
+		Value:  5 << 20,
+		Desc:   "Size in bytes of the buffered operations that triggers a bulk request",
+		EnvVar: "ELASTICSEARCH_BULK_SIZE",
+	})
+	esBulkFlushInterval := app.String(cli.StringOpt{
+		Name:   "elasticsearch-bulk-flush-interval",
+		Value:  "1s",
+		Desc:   "Maximum time an operation is buffered before the bulk request is sent",
+		EnvVar: "ELASTICSEARCH_BULK_FLUSH_INTERVAL",
+	})
+	esTimeout := app.String(cli.StringOpt{
+		Name:   "elasticsearch-timeout",
+		Value:  "30s",
+		Desc:   "How long a write or delete waits for Elasticsearch, retries and bulk commits included",
+		EnvVar: "ELASTICSEARCH_TIMEOUT",
+	})
+	messageSource := app.String(cli.StringOpt{
+		Name:   "message-source",
+		Value:  source.KindKafkaProxy,
+		Desc:   "Where the messages are read from: kafka-proxy, kafka (consumer group on the Kafka brokers) or file (JSON lines)",
+		EnvVar: "MESSAGE_SOURCE",
+	})
 	kafkaProxyAddress := app.String(cli.StringOpt{
 		Name:   "kafka-proxy-address",
 		Value:  "http://localhost:8080",
 		Desc:   "Addresses used by the queue consumer to connect to the queue",
 		EnvVar: "KAFKA_PROXY_ADDR",
 	})
+	kafkaBrokers := app.Strings(cli.StringsOpt{
+		Name:   "kafka-brokers",
+		Value:  []string{"localhost:9092"},
+		Desc:   "Addresses of the Kafka brokers read by the kafka message source",
+		EnvVar: "KAFKA_BROKERS",
+	})
 	kafkaConsumerGroup := app.String(cli.StringOpt{
 		Name:   "kafka-consumer-group",
 		Value:  "default-consumer-group",
@@ -102,12 +163,60 @@ func main() {
 		Desc:   "Whether the consumer uses concurrent processing for the messages",
 		EnvVar: "KAFKA_CONCURRENT_PROCESSING",
 	})
+	messageWorkers := app.Int(cli.IntOpt{
+		Name:   "message-workers",
+		Value:  message.DefaultWorkers,
+		Desc:   "Number of messages processed in parallel, the messages of the same content are processed in order",
+		EnvVar: "MESSAGE_WORKERS",
+	})
+	messageFile := app.String(cli.StringOpt{
+		Name:   "message-file",
+		Value:  source.Stdin,
+		Desc:   "JSON lines file read by the file message source, - for stdin",
+		EnvVar: "MESSAGE_FILE",
+	})
 	publicConcordancesEndpoint := app.String(cli.StringOpt{
 		Name:   "public-concordances-endpoint",
 		Value:  "http://public-concordances-api:8080",
 		Desc:   "Endpoint to concord ids with",
 		EnvVar: "PUBLIC_CONCORDANCES_ENDPOINT",
 	})
+	concordanceChunkSize := app.Int(cli.IntOpt{
+		Name:   "concordance-chunk-size",
+		Value:  concept.DefaultChunkSize,
+		Desc:   "Maximum number of concepts looked up by a single Concordance API request",
+		EnvVar: "CONCORDANCE_CHUNK_SIZE",
+	})
+	concordanceMaxConcurrentRequests := app.Int(cli.IntOpt{
+		Name:   "concordance-max-concurrent-requests",
+		Value:  concept.DefaultMaxConcurrentRequests,
+		Desc:   "Maximum number of concurrent Concordance API requests looking up the concepts of a piece of content",
+		EnvVar: "CONCORDANCE_MAX_CONCURRENT_REQUESTS",
+	})
+	concordanceTimeout := app.String(cli.StringOpt{
+		Name:   "concordance-timeout",
+		Value:  "10s",
+		Desc:   "How long the mapping of a piece of content waits for the Concordance API, retries included",
+		EnvVar: "CONCORDANCE_TIMEOUT",
+	})
+	concordanceCacheSize := app.Int(cli.IntOpt{
+		Name:   "concordance-cache-size",
+		Value:  10000,
+		Desc:   "Maximum number of concepts whose concordances are cached, 0 disables the cache",
+		EnvVar: "CONCORDANCE_CACHE_SIZE",
+	})
+	concordanceCacheTTL := app.String(cli.StringOpt{
+		Name:   "concordance-cache-ttl",
+		Value:  "1h",
+		Desc:   "How long the concordances of a concept are cached",
+		EnvVar: "CONCORDANCE_CACHE_TTL",
+	})
+	concordanceCacheNegativeTTL := app.String(cli.StringOpt{
+		Name:   "concordance-cache-negative-ttl",
+		Value:  "5m",
+		Desc:   "How long a concept without concordances is cached",
+		EnvVar: "CONCORDANCE_CACHE_NEGATIVE_TTL",
+	})
 	baseAPIUrl := app.String(cli.StringOpt{
 		Name:   "base-api-url",
 		Value:  "https://api.ft.com/",
@@ -121,6 +230,100 @@ func main() {
 		Desc:   "URL of the API uses to retrieve lists data from",
 		EnvVar: "INTERNAL_CONTENT_API_URL",
 	})
+	internalContentTimeout := app.String(cli.StringOpt{
+		Name:   "internal-content-timeout",
+		Value:  "5s",
+		Desc:   "How long the mapping of a piece of content waits for internal-content-api",
+		EnvVar: "INTERNAL_CONTENT_TIMEOUT",
+	})
+	imageCacheSize := app.Int(cli.IntOpt{
+		Name:   "image-cache-size",
+		Value:  mapper.DefaultImageCacheSize,
+		Desc:   "Maximum number of image sets whose image looked up from internal-content-api is cached, 0 disables the cache",
+		EnvVar: "IMAGE_CACHE_SIZE",
+	})
+	imageCacheTTL := app.String(cli.StringOpt{
+		Name:   "image-cache-ttl",
+		Value:  "24h",
+		Desc:   "How long the image of an image set is cached",
+		EnvVar: "IMAGE_CACHE_TTL",
+	})
+	retryMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "retry-max-attempts",
+		Value:  3,
+		Desc:   "Maximum number of attempts of Elasticsearch writes and Concordance API lookups failing with transient errors",
+		EnvVar: "RETRY_MAX_ATTEMPTS",
+	})
+	retryInitialBackoff := app.String(cli.StringOpt{
+		Name:   "retry-initial-backoff",
+		Value:  "200ms",
+		Desc:   "Wait before the first retry, doubled on every following retry",
+		EnvVar: "RETRY_INITIAL_BACKOFF",
+	})
+	retryMaxBackoff := app.String(cli.StringOpt{
+		Name:   "retry-max-backoff",
+		Value:  "5s",
+		Desc:   "Maximum wait between two retries",
+		EnvVar: "RETRY_MAX_BACKOFF",
+	})
+
+	drainTimeout := app.String(cli.StringOpt{
+		Name:   "drain-timeout",
+		Value:  "20s",
+		Desc:   "How long the messages in flight are waited for on shutdown before they are cancelled and dead-lettered",
+		EnvVar: "DRAIN_TIMEOUT",
+	})
+
+	deadLetterFile := app.String(cli.StringOpt{
+		Name:   "dead-letter-file",
+		Value:  "",
+		Desc:   "File where messages that failed to be processed are kept until they are replayed, on a persistent volume. Without it they are lost on restart",
+		EnvVar: "DEAD_LETTER_FILE",
+	})
+	deadLetterMemoryEntries := app.Int(cli.IntOpt{
+		Name:   "dead-letter-memory-entries",
+		Value:  1000,
+		Desc:   "Number of dead-lettered messages kept in memory when DEAD_LETTER_FILE is not set, the oldest are evicted beyond it",
+		EnvVar: "DEAD_LETTER_MEMORY_ENTRIES",
+	})
+	var reenrichLookupFailuresSet bool
+	reenrichLookupFailures := app.Bool(cli.BoolOpt{
+		Name:      "reenrich-lookup-failures",
+		Value:     false,
+		Desc:      "Whether content indexed without all of its concordances is indexed again once the Concordance API recovers, by default only when LOOKUP_FAILURE_FILE is set",
+		EnvVar:    "REENRICH_LOOKUP_FAILURES",
+		SetByUser: &reenrichLookupFailuresSet,
+	})
+	lookupFailureFile := app.String(cli.StringOpt{
+		Name:   "lookup-failure-file",
+		Value:  "",
+		Desc:   "File where the messages of content indexed without all of its concordances are kept until they are re-enriched, on a persistent volume. Without it they are lost on restart",
+		EnvVar: "LOOKUP_FAILURE_FILE",
+	})
+	lookupFailureQueueSize := app.Int(cli.IntOpt{
+		Name:   "lookup-failure-queue-size",
+		Value:  10000,
+		Desc:   "Maximum number of documents whose messages are kept for re-enrichment, the others are left to the sweeper",
+		EnvVar: "LOOKUP_FAILURE_QUEUE_SIZE",
+	})
+	reenrichInterval := app.String(cli.StringOpt{
+		Name:   "reenrich-interval",
+		Value:  "1m",
+		Desc:   "How often the queued messages are re-enriched while the Concordance API is healthy",
+		EnvVar: "REENRICH_INTERVAL",
+	})
+	lookupFailureSweepInterval := app.String(cli.StringOpt{
+		Name:   "lookup-failure-sweep-interval",
+		Value:  "15m",
+		Desc:   "How often Elasticsearch is searched for documents flagged with lookupFailure to re-enrich, 0 disables the sweeper",
+		EnvVar: "LOOKUP_FAILURE_SWEEP_INTERVAL",
+	})
+	lookupFailureSweepSize := app.Int(cli.IntOpt{
+		Name:   "lookup-failure-sweep-size",
+		Value:  100,
+		Desc:   "Maximum number of flagged documents a sweep re-enriches",
+		EnvVar: "LOOKUP_FAILURE_SWEEP_SIZE",
+	})
 
 	apiBasicAuthUsername := app.String(cli.StringOpt{
 		Name:   "api-basic-auth-user",
@@ -147,55 +350,223 @@ func main() {
 	log := logger.NewUPPLogger(*appSystemCode, *logLevel)
 	log.Info("[Startup] Application is starting")
 
-	app.Action = func() {
-		accessConfig := es.AccessConfig{
+	newAccessConfig := func() es.AccessConfig {
+		return es.AccessConfig{
 			AccessKey: *accessKey,
 			SecretKey: *secretKey,
 			Endpoint:  *esEndpoint,
 		}
+	}
+	indexCommands(app, newAccessConfig, indexName, esTypeless, log)
 
-		httpClient := pkghttp.NewHTTPClient()
-
+	// newServices creates the services shared by the message flow and the reindex command, closeServices commits pending bulk operations
+	newServices := func(httpClient *http.Client) (svc services, closeServices func()) {
 		appConfig, err := config.ParseConfig("app.yml")
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		esService := es.NewService(*indexName)
+		initialBackoff, err := time.ParseDuration(*retryInitialBackoff)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid retry initial backoff")
+		}
+		maxBackoff, err := time.ParseDuration(*retryMaxBackoff)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid retry max backoff")
+		}
+		retrier := retry.NewRetrier(retry.Policy{
+			MaxAttempts:    *retryMaxAttempts,
+			InitialBackoff: initialBackoff,
+			MaxBackoff:     maxBackoff,
+			Multiplier:     2,
+			Jitter:         0.5,
+		}, retry.Observers{retry.NewLogObserver(log), metrics.RetryObserver{}})
+
+		svc.elasticsearchTimeout, err = time.ParseDuration(*esTimeout)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid Elasticsearch timeout")
+		}
+
+		closeServices = func() {}
+		svc.esService = es.NewService(*indexName, retrier)
+		bulkEnabled := *esBulkEnabled
+		if *esTypeless {
+			if bulkEnabled {
+				// the client can't send the newline delimited body of a _bulk request with the content type Elasticsearch 7+ expects
+				log.Warn("Elasticsearch bulk writes are not supported with typeless indices, documents are written one at a time")
+				bulkEnabled = false
+			}
+			svc.esService = es.NewTypelessService(*indexName, retrier)
+		}
+		if bulkEnabled {
+			flushInterval, err := time.ParseDuration(*esBulkFlushInterval)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid Elasticsearch bulk flush interval")
+			}
+			bulkService := es.NewBulkService(*indexName, es.BulkConfig{
+				Workers:       *esBulkWorkers,
+				BulkActions:   *esBulkActions,
+				BulkSize:      *esBulkSize,
+				FlushInterval: flushInterval,
+			}, retrier)
+			closeServices = func() {
+				if err := bulkService.Close(); err != nil {
+					log.WithError(err).Error("Failed to commit pending bulk operations")
+				}
+			}
+			svc.esService = bulkService
+		}
 
-		concordanceAPIService := concept.NewConcordanceAPIService(*publicConcordancesEndpoint, httpClient)
+		svc.concordanceAPI = concept.NewConcordanceAPIService(*publicConcordancesEndpoint, httpClient, retrier)
+		svc.concordanceAPI.ChunkSize = *concordanceChunkSize
+		svc.concordanceAPI.MaxConcurrentRequests = *concordanceMaxConcurrentRequests
+		var conceptReader concept.Reader = svc.concordanceAPI
+		if *concordanceCacheSize > 0 {
+			cacheTTL, err := time.ParseDuration(*concordanceCacheTTL)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid concordance cache TTL")
+			}
+			cacheNegativeTTL, err := time.ParseDuration(*concordanceCacheNegativeTTL)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid concordance cache negative TTL")
+			}
+			svc.conceptCache = concept.NewCachedReader(svc.concordanceAPI, concept.CacheConfig{
+				Size:        *concordanceCacheSize,
+				TTL:         cacheTTL,
+				NegativeTTL: cacheNegativeTTL,
+			})
+			conceptReader = svc.conceptCache
+		}
 
 		// initialize apiClient
 		internalAPIConfig := api.NewConfig(*internalContentAPIURL, *apiBasicAuthUsername, *apiBasicAuthPassword)
 		internalContentAPIClient := api.NewClient(*internalAPIConfig, httpClient)
-		internalContentClient := internalcontent.NewContentClient(internalContentAPIClient, internalcontent.URLInternalContent)
+		svc.internalContent = internalcontent.NewContentClient(internalContentAPIClient, internalcontent.URLInternalContent)
+		svc.contentFetcher = admin.NewInternalContentFetcher(internalContentAPIClient, internalcontent.URLInternalContent)
 
-		mapperHandler := mapper.NewMapperHandler(
-			concordanceAPIService,
+		svc.mapper = mapper.NewMapperHandler(
+			conceptReader,
 			*baseAPIUrl,
 			appConfig,
 			log,
-			internalContentClient,
+			svc.internalContent,
 		)
+		imageTTL, err := time.ParseDuration(*imageCacheTTL)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid image cache TTL")
+		}
+		svc.mapper.Images = mapper.NewImageCache(*imageCacheSize, imageTTL)
+		svc.mapper.Timeouts.Concordances, err = time.ParseDuration(*concordanceTimeout)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid concordance timeout")
+		}
+		svc.mapper.Timeouts.InternalContent, err = time.ParseDuration(*internalContentTimeout)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid internal-content-api timeout")
+		}
+		return svc, closeServices
+	}
+	reindexCommand(app, newAccessConfig, newServices, log)
+
+	app.Action = func() {
+		accessConfig := newAccessConfig()
+
+		httpClient := pkghttp.NewHTTPClient()
+
+		if err := source.ValidateKind(*messageSource); err != nil {
+			log.WithError(err).Fatal("Invalid message source")
+		}
+
+		drainWait, err := time.ParseDuration(*drainTimeout)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid drain timeout")
+		}
+
+		svc, closeServices := newServices(httpClient)
+		esService := svc.esService
+
+		var deadLetterStore deadletter.Store = deadletter.NewMemoryStore(*deadLetterMemoryEntries)
+		if *deadLetterFile == "" {
+			log.Errorf("DEAD_LETTER_FILE is not set, the last %d dead-lettered messages are only kept in memory and are lost on restart", *deadLetterMemoryEntries)
+		} else {
+			deadLetterStore, err = deadletter.NewFileStore(*deadLetterFile)
+			if err != nil {
+				log.WithError(err).Fatal("Could not create dead-letter store")
+			}
+		}
+
+		var messages source.Source
+		switch *messageSource {
+		case source.KindKafka:
+			messages = source.NewKafkaSource(source.KafkaConfig{
+				Brokers:  *kafkaBrokers,
+				Group:    *kafkaConsumerGroup,
+				Topic:    *kafkaTopic,
+				ClientID: *appSystemCode,
+			}, log)
+		case source.KindFile:
+			messages = source.NewFileSource(*messageFile, log)
+		default:
+			messages = source.NewKafkaProxySource(queueConfig, httpClient)
+		}
+
+		var reenricher *reenrich.Reenricher
+		var reenrichment reenrich.Queue
+		// keeping the messages in memory is only worth it when asked for, they are lost on restart
+		if *reenrichLookupFailures || (!reenrichLookupFailuresSet && *lookupFailureFile != "") {
+			fetchEvent := func(uuid string) (consumer.Message, error) {
+				event, err := admin.FetchEvent(svc.contentFetcher, uuid)
+				if err != nil {
+					return consumer.Message{}, err
+				}
+				body, err := json.Marshal(event)
+				return consumer.Message{Headers: map[string]string{}, Body: string(body)}, err
+			}
+			reenricher = newReenricher(*lookupFailureFile, *lookupFailureQueueSize, *reenrichInterval, *lookupFailureSweepInterval, *lookupFailureSweepSize, esService, fetchEvent, svc.concordanceAPI, log)
+			reenrichment = reenricher
+		}
 
 		handler := message.NewMessageHandler(
 			esService,
-			mapperHandler,
+			svc.mapper,
 			httpClient,
-			queueConfig,
+			messages,
 			es.NewClient,
+			deadLetterStore,
+			reenrichment,
 			log,
 		)
+		handler.ElasticsearchTimeout = svc.elasticsearchTimeout
+		handler.Workers = *messageWorkers
+		if *esBulkEnabled && !*esTypeless && *messageWorkers <= 1 && !*kafkaConcurrentProcessing {
+			// every message waits for the commit of its own operation, so a single worker never fills a batch
+			log.Warn("Elasticsearch bulk writes are enabled with a single message worker, every bulk request holds one operation")
+		}
 
 		handler.Start(*baseAPIUrl, accessConfig)
+		if reenricher != nil {
+			reenricher.Start(handler)
+		}
 
-		healthService := health.NewHealthService(&queueConfig, esService, httpClient, concordanceAPIService, *appSystemCode, log)
+		healthService := health.NewHealthService(messages, esService, httpClient, svc.concordanceAPI, *appSystemCode, log)
 		//
 		serveMux := http.NewServeMux()
 		serveMux = healthService.AttachHTTPEndpoints(serveMux, *appName, config.AppDescription)
-		pkghttp.StartServer(log, serveMux, *port)
-
-		handler.Stop()
+		serveMux = deadletter.NewHandler(deadLetterStore, handler, log).AttachHTTPEndpoints(serveMux)
+		serveMux = admin.NewHandler(handler, svc.contentFetcher, log).AttachHTTPEndpoints(serveMux)
+		serveMux = metrics.AttachHTTPEndpoints(serveMux)
+		if svc.conceptCache != nil {
+			serveMux = concept.NewCacheHandler(svc.conceptCache, log).AttachHTTPEndpoints(serveMux)
+		}
+		// the health endpoints keep being served while draining, reporting the service as not good to go
+		pkghttp.StartServer(log, serveMux, *port, func() {
+			healthService.SetDraining()
+			if reenricher != nil {
+				reenricher.Stop()
+			}
+			handler.Drain(drainWait)
+			closeServices()
+		})
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -204,3 +575,48 @@ func main() {
 	}
 	log.Info("[Shutdown] Shutdown complete")
 }
+
+func newReenricher(file string, maxQueued int, retryInterval string, sweepInterval string, sweepSize int, finder reenrich.Finder, fetch reenrich.Fetcher, concordanceAPI reenrich.HealthChecker, log *logger.UPPLogger) *reenrich.Reenricher {
+	retryEvery, err := time.ParseDuration(retryInterval)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid re-enrichment interval")
+	}
+	if retryEvery <= 0 {
+		log.Fatal("The re-enrichment interval must be positive")
+	}
+	sweepEvery, err := time.ParseDuration(sweepInterval)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid lookup failure sweep interval")
+	}
+	var store reenrich.Store = reenrich.NewMemoryStore()
+	if file == "" {
+		log.Error("LOOKUP_FAILURE_FILE is not set, the messages to re-enrich are only kept in memory and are lost on restart")
+	} else {
+		store, err = reenrich.NewFileStore(file)
+		if err != nil {
+			log.WithError(err).Fatal("Could not create lookup failure store")
+		}
+	}
+	reenricher, err := reenrich.NewReenricher(store, finder, fetch, concordanceAPI, reenrich.Config{
+		RetryInterval: retryEvery,
+		SweepInterval: sweepEvery,
+		SweepSize:     sweepSize,
+		MaxQueued:     maxQueued,
+	}, log)
+	if err != nil {
+		log.WithError(err).Fatal("Could not read lookup failure store")
+	}
+	return reenricher
+}
+
+// services are the dependencies of the message handler
+type services struct {
+	esService       es.Service
+	concordanceAPI  *concept.ConcordanceAPIService
+	conceptCache    *concept.CachedReader
+	internalContent *internalcontent.ContentClient
+	contentFetcher  *admin.InternalContentFetcher
+	mapper          *mapper.Handler
+	// elasticsearchTimeout bounds the writes and deletes of the message handlers
+	elasticsearchTimeout time.Duration
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	cli "github.com/jawher/mow.cli"
+
+	"github.com/Financial-Times/go-logger/v2"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
+	pkghttp "github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/http"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/message"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/reindex"
+)
+
+// reindexCommand adds the subcommand rebuilding the index from exported combined post publication events,
+// e.g. for disaster recovery. The events go through the same mapping as the messages of the queue.
+func reindexCommand(app *cli.Cli, accessConfig func() es.AccessConfig, newServices func(httpClient *http.Client) (services, func()), log *logger.UPPLogger) {
+	app.Command("reindex", "Index the events of a directory, a plain or gzip JSON lines file or stdin", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--workers] [--checkpoint-file] [--progress-interval] INPUT"
+		input := cmd.StringArg("INPUT", "", "Directory, file or - for stdin holding one combined post publication event per line")
+		workers := cmd.Int(cli.IntOpt{
+			Name:  "workers",
+			Value: 4,
+			Desc:  "Number of events indexed concurrently",
+		})
+		checkpointFile := cmd.String(cli.StringOpt{
+			Name:  "checkpoint-file",
+			Value: "",
+			Desc:  "File where the progress is kept, an interrupted reindex resumes from it when run again",
+		})
+		progressInterval := cmd.String(cli.StringOpt{
+			Name:  "progress-interval",
+			Value: "10s",
+			Desc:  "Interval of the progress logs and checkpoint saves",
+		})
+
+		cmd.Action = func() {
+			interval, err := time.ParseDuration(*progressInterval)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid progress interval")
+			}
+
+			httpClient := pkghttp.NewHTTPClient()
+			client, err := es.NewClient(accessConfig(), httpClient, log)
+			if err != nil {
+				log.WithError(err).Fatal("Could not create Elasticsearch client")
+			}
+			svc, closeServices := newServices(httpClient)
+			svc.esService.SetClient(client)
+			// failures are reported in the summary instead of being dead-lettered
+			handler := message.NewMessageHandler(svc.esService, svc.mapper, httpClient, nil, es.NewClient, nil, nil, log)
+			handler.ElasticsearchTimeout = svc.elasticsearchTimeout
+
+			// an interrupted reindex saves its checkpoint, so that it can be resumed
+			ctx, cancel := context.WithCancel(context.Background())
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-signals
+				log.Info("Interrupting reindex")
+				cancel()
+			}()
+
+			summary, err := reindex.NewReindexer(handler, reindex.Config{
+				Workers:          *workers,
+				CheckpointFile:   *checkpointFile,
+				ProgressInterval: interval,
+			}, log).Run(ctx, *input)
+			cancel()
+			closeServices()
+			if err != nil {
+				log.WithError(err).Fatal("Reindex stopped")
+			}
+
+			for _, failure := range summary.Failures {
+				log.WithUUID(failure.UUID).Errorf("Failed line %d of %s: %s", failure.Line, failure.Input, failure.Error)
+			}
+			log.Infof("Reindex done in %s: %d events read, %d resumed, %d indexed, %d deleted, %d ignored, %d failed",
+				summary.Duration.Round(time.Second), summary.Read, summary.Resumed, summary.Indexed, summary.Deleted, summary.Ignored, summary.Failed)
+			if summary.Failed > 0 {
+				cli.Exit(1)
+			}
+		}
+	})
+}
@@ -9,17 +9,19 @@ require (
 	github.com/Financial-Times/service-status-go v0.0.0-20160323111542-3f5199736a3d
 	github.com/Financial-Times/transactionid-utils-go v0.2.0
 	github.com/Financial-Times/upp-go-sdk v0.0.7
+	github.com/Shopify/sarama v1.27.2
 	github.com/fortytw2/leaktest v1.3.0 // indirect
 	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/mux v1.6.2
 	github.com/hashicorp/go-version v1.0.0 // indirect
 	github.com/jawher/mow.cli v1.0.4
 	github.com/pborman/uuid v0.0.0-20170612153648-e790cca94e6c
+	github.com/prometheus/client_golang v1.7.1
 	github.com/rakyll/statik v0.1.7
 	github.com/smartystreets/go-aws-auth v0.0.0-20170504205021-8ef1316913ee
 	github.com/smartystreets/gunit v1.1.3 // indirect
 	github.com/spf13/viper v1.6.2
-	github.com/stretchr/testify v1.4.0
-	golang.org/x/sys v0.0.0-20191120155948-bd437916bb0e // indirect
+	github.com/stretchr/testify v1.6.1
+	golang.org/x/net v0.0.0-20200904194848-62affa334b73
 	gopkg.in/olivere/elastic.v2 v2.0.61
 )
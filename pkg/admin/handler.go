@@ -0,0 +1,220 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/upp-go-sdk/pkg/api"
+	"github.com/pborman/uuid"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/message"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/schema"
+)
+
+const (
+	pathIndex   = "/index/"
+	pathPreview = "/__preview"
+
+	transactionIDHeader = "X-Request-Id"
+	originHeader        = "Origin-System-Id"
+	contentTypeHeader   = "Content-Type"
+)
+
+// Indexer maps and writes a single piece of content outside of the message flow.
+// Preview only maps the content, nothing is written.
+type Indexer interface {
+	Index(ctx context.Context, event schema.EnrichedContent, headers map[string]string) (message.IndexResult, error)
+	Preview(ctx context.Context, event schema.EnrichedContent, headers map[string]string) (message.IndexResult, error)
+}
+
+// ContentFetcher reads the raw content JSON from internal-content-api.
+type ContentFetcher interface {
+	GetRawContent(uuid string, expand bool) ([]byte, error)
+}
+
+// InternalContentFetcher reads content with an upp-go-sdk client. The SDK content model only holds the main image,
+// so the raw response is returned to keep the body, title, identifiers and annotations.
+type InternalContentFetcher struct {
+	client api.Client
+	path   string
+}
+
+func NewInternalContentFetcher(client api.Client, path string) *InternalContentFetcher {
+	return &InternalContentFetcher{client: client, path: path}
+}
+
+func (f *InternalContentFetcher) GetRawContent(uuid string, expand bool) ([]byte, error) {
+	path := f.path + uuid
+	if expand {
+		path += "?expandImages=true"
+	}
+	resp, err := f.client.SendRequest(&api.Request{Method: http.MethodGet, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal-content-api returned status %d for %s", resp.StatusCode, uuid)
+	}
+	return []byte(resp.Body), nil
+}
+
+// Handler exposes endpoints for support engineers to inspect and fix the index without republishing content upstream.
+type Handler struct {
+	indexer Indexer
+	fetcher ContentFetcher
+	log     *logger.UPPLogger
+}
+
+func NewHandler(indexer Indexer, fetcher ContentFetcher, log *logger.UPPLogger) *Handler {
+	return &Handler{indexer: indexer, fetcher: fetcher, log: log}
+}
+
+func (h *Handler) AttachHTTPEndpoints(serveMux *http.ServeMux) *http.ServeMux {
+	serveMux.HandleFunc(pathIndex, h.index)
+	serveMux.HandleFunc(pathPreview, h.preview)
+	return serveMux
+}
+
+// index serves POST /index/{uuid}. The request body is the combined post publication event to index,
+// when it is empty the content is read from internal-content-api.
+func (h *Handler) index(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	contentUUID := strings.Trim(strings.TrimPrefix(req.URL.Path, pathIndex), "/")
+	if uuid.Parse(contentUUID) == nil {
+		h.writeJSON(writer, http.StatusBadRequest, map[string]string{"message": "invalid content uuid " + contentUUID})
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		h.writeJSON(writer, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	var event schema.EnrichedContent
+	if len(strings.TrimSpace(string(body))) == 0 {
+		event, err = FetchEvent(h.fetcher, contentUUID)
+		if err != nil {
+			h.writeJSON(writer, http.StatusBadGateway, map[string]string{"message": err.Error()})
+			return
+		}
+	} else if err = json.Unmarshal(body, &event); err != nil {
+		h.writeJSON(writer, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	if event.UUID == "" {
+		event.UUID = contentUUID
+	}
+	if event.UUID != contentUUID {
+		h.writeJSON(writer, http.StatusBadRequest, map[string]string{"message": "content uuid does not match the uuid in the path"})
+		return
+	}
+
+	result, err := h.indexer.Index(req.Context(), event, requestHeaders(req))
+	switch {
+	case err == nil:
+		h.writeJSON(writer, http.StatusOK, result)
+	case errors.Is(err, message.ErrNotIndexable):
+		h.writeJSON(writer, http.StatusUnprocessableEntity, map[string]string{"message": err.Error()})
+	case err == es.ErrStaleVersion:
+		h.writeJSON(writer, http.StatusConflict, map[string]string{"message": "a newer version of the content is already indexed"})
+	default:
+		h.writeJSON(writer, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+}
+
+// preview serves POST /__preview. The request body is a combined post publication event,
+// the response holds the resolved content type, collection and model without touching Elasticsearch.
+func (h *Handler) preview(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var event schema.EnrichedContent
+	if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+		h.writeJSON(writer, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	result, err := h.indexer.Preview(req.Context(), event, requestHeaders(req))
+	switch {
+	case err == nil:
+		h.writeJSON(writer, http.StatusOK, result)
+	case errors.Is(err, message.ErrNotIndexable):
+		h.writeJSON(writer, http.StatusUnprocessableEntity, map[string]string{"message": err.Error()})
+	default:
+		h.writeJSON(writer, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+}
+
+// FetchEvent reads the content and its annotations from internal-content-api and builds the event it would have been published with.
+// The main image is expanded, so the mapper reads the image from its members instead of looking the image set up again.
+func FetchEvent(fetcher ContentFetcher, contentUUID string) (schema.EnrichedContent, error) {
+	raw, err := fetcher.GetRawContent(contentUUID, true)
+	if err != nil {
+		return schema.EnrichedContent{}, err
+	}
+	return toEnrichedContent(contentUUID, raw)
+}
+
+// toEnrichedContent decodes an internal-content-api response. Its annotations are the concepts themselves,
+// in the published event every concept is wrapped in a thing.
+func toEnrichedContent(contentUUID string, raw []byte) (schema.EnrichedContent, error) {
+	var fetched struct {
+		schema.Content
+		Annotations []schema.Thing `json:"annotations"`
+	}
+	if err := json.Unmarshal(raw, &fetched); err != nil {
+		return schema.EnrichedContent{}, err
+	}
+
+	content := fetched.Content
+	if content.UUID == "" {
+		content.UUID = contentUUID
+	}
+	annotations := make(schema.Annotations, 0, len(fetched.Annotations))
+	for _, thing := range fetched.Annotations {
+		annotations = append(annotations, schema.Annotation{Thing: thing})
+	}
+	return schema.EnrichedContent{
+		UUID:          contentUUID,
+		Content:       content,
+		Metadata:      annotations,
+		LastModified:  content.LastModified,
+		MarkedDeleted: "false",
+	}, nil
+}
+
+func requestHeaders(req *http.Request) map[string]string {
+	headers := make(map[string]string)
+	for _, name := range []string{transactionIDHeader, originHeader, contentTypeHeader} {
+		if value := req.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+func (h *Handler) writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	response, err := json.Marshal(body)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if _, err = writer.Write(response); err != nil {
+		h.log.WithError(err).Error(err.Error())
+	}
+}
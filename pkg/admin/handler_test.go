@@ -0,0 +1,316 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/upp-go-sdk/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v2"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/message"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/schema"
+	tst "github.com/Financial-Times/content-rw-elasticsearch/v2/test"
+)
+
+const contentUUID = "aae9611e-f66c-4fe4-a6c6-2e2bdea69060"
+
+type indexerMock struct {
+	mock.Mock
+}
+
+func (m *indexerMock) Index(ctx context.Context, event schema.EnrichedContent, headers map[string]string) (message.IndexResult, error) {
+	args := m.Called(event, headers)
+	return args.Get(0).(message.IndexResult), args.Error(1)
+}
+
+func (m *indexerMock) Preview(ctx context.Context, event schema.EnrichedContent, headers map[string]string) (message.IndexResult, error) {
+	args := m.Called(event, headers)
+	return args.Get(0).(message.IndexResult), args.Error(1)
+}
+
+type fetcherMock struct {
+	mock.Mock
+}
+
+func (m *fetcherMock) GetRawContent(uuid string, expand bool) ([]byte, error) {
+	args := m.Called(uuid, expand)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+type apiClientMock struct {
+	mock.Mock
+}
+
+func (m *apiClientMock) SendRequest(req *api.Request) (*api.Response, error) {
+	args := m.Called(req.Method, req.Path)
+	return args.Get(0).(*api.Response), args.Error(1)
+}
+
+func newTestServer(indexer Indexer, fetcher ContentFetcher) *httptest.Server {
+	log := logger.NewUPPLogger("test", "PANIC")
+	return httptest.NewServer(NewHandler(indexer, fetcher, log).AttachHTTPEndpoints(http.NewServeMux()))
+}
+
+func postIndex(t *testing.T, server *httptest.Server, uuid string, body string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, server.URL+pathIndex+uuid, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(transactionIDHeader, "tid_test")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestIndexEventFromBody(t *testing.T) {
+	collection := "FTCom"
+	indexer := new(indexerMock)
+	indexer.On("Index", mock.MatchedBy(func(event schema.EnrichedContent) bool {
+		return event.UUID == contentUUID && event.Content.Title == "Title"
+	}), map[string]string{transactionIDHeader: "tid_test"}).
+		Return(message.IndexResult{ContentType: "article", Collection: collection, Model: &schema.IndexModel{}, Result: &elastic.IndexResult{Id: contentUUID, Created: true}}, nil)
+	fetcher := new(fetcherMock)
+	server := newTestServer(indexer, fetcher)
+	defer server.Close()
+
+	resp := postIndex(t, server, contentUUID, fmt.Sprintf(`{"uuid":"%s","content":{"uuid":"%s","title":"Title"}}`, contentUUID, contentUUID))
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result message.IndexResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, collection, result.Collection)
+	assert.True(t, result.Result.Created)
+	indexer.AssertExpectations(t)
+	fetcher.AssertNotCalled(t, "GetRawContent", mock.Anything, mock.Anything)
+}
+
+func TestIndexFetchesExpandedContentWithoutBody(t *testing.T) {
+	indexer := new(indexerMock)
+	indexer.On("Index", mock.MatchedBy(func(event schema.EnrichedContent) bool {
+		return event.UUID == contentUUID && event.Content.UUID == contentUUID && event.MarkedDeleted == "false"
+	}), mock.Anything).Return(message.IndexResult{}, nil)
+	fetcher := new(fetcherMock)
+	fetcher.On("GetRawContent", contentUUID, true).Return([]byte("{}"), nil)
+	server := newTestServer(indexer, fetcher)
+	defer server.Close()
+
+	resp := postIndex(t, server, contentUUID, "")
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	indexer.AssertExpectations(t)
+	fetcher.AssertExpectations(t)
+}
+
+func TestIndexKeepsInternalContent(t *testing.T) {
+	indexer := new(indexerMock)
+	indexer.On("Index", mock.MatchedBy(func(event schema.EnrichedContent) bool {
+		return event.UUID == contentUUID &&
+			event.Content.UUID == contentUUID &&
+			event.Content.Title == "In praise of activist investors" &&
+			strings.Contains(event.Content.BodyXML, "Activist investors are far from perfect") &&
+			event.Content.Type == "http://www.ft.com/ontology/content/Article" &&
+			event.LastModified == "2018-04-04T10:30:01.497Z"
+	}), mock.Anything).Return(message.IndexResult{}, nil)
+	fetcher := new(fetcherMock)
+	fetcher.On("GetRawContent", contentUUID, true).Return(tst.ReadTestResource("exampleInternalContent.json"), nil)
+	server := newTestServer(indexer, fetcher)
+	defer server.Close()
+
+	resp := postIndex(t, server, contentUUID, "")
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	indexer.AssertExpectations(t)
+	event := indexer.Calls[0].Arguments.Get(0).(schema.EnrichedContent)
+	require.Len(t, event.Content.Identifiers, 1)
+	assert.Equal(t, []string{"5546cbc4-d4f7-47f9-3f3e-941fb0799c4f"}, event.Content.MainImage.ImageUUIDs)
+	require.Len(t, event.Metadata, 2)
+	assert.Equal(t, "http://api.ft.com/things/9b3d0b4c-f317-3acc-abdc-d023832f5a40", event.Metadata[0].Thing.ID)
+	assert.Equal(t, "Lucian Arye Bebchuk", event.Metadata[0].Thing.PrefLabel)
+	assert.Equal(t, "http://www.ft.com/ontology/annotation/mentions", event.Metadata[0].Thing.Predicate)
+	assert.Equal(t, "http://www.ft.com/ontology/annotation/about", event.Metadata[1].Thing.Predicate)
+	assert.Contains(t, event.Metadata[1].Thing.Types, "http://www.ft.com/ontology/Topic")
+}
+
+func TestIndexFailures(t *testing.T) {
+	tests := []struct {
+		name           string
+		uuid           string
+		body           string
+		indexErr       error
+		fetchErr       error
+		expectedStatus int
+	}{
+		{
+			name:           "invalid uuid",
+			uuid:           "not-a-uuid",
+			body:           "{}",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "malformed body",
+			uuid:           contentUUID,
+			body:           "malformed json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "uuid mismatch",
+			uuid:           contentUUID,
+			body:           `{"uuid":"b17756fe-0f62-4cf1-9deb-ca7a2ff80172"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "internal-content-api failure",
+			uuid:           contentUUID,
+			fetchErr:       errors.New("status 503"),
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "not indexable",
+			uuid:           contentUUID,
+			body:           "{}",
+			indexErr:       fmt.Errorf("%w: no content", message.ErrNotIndexable),
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "stale version",
+			uuid:           contentUUID,
+			body:           "{}",
+			indexErr:       es.ErrStaleVersion,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "write failure",
+			uuid:           contentUUID,
+			body:           "{}",
+			indexErr:       elastic.ErrTimeout,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			indexer := new(indexerMock)
+			indexer.On("Index", mock.Anything, mock.Anything).Return(message.IndexResult{}, test.indexErr)
+			fetcher := new(fetcherMock)
+			fetcher.On("GetRawContent", mock.Anything, mock.Anything).Return([]byte("{}"), test.fetchErr)
+			server := newTestServer(indexer, fetcher)
+			defer server.Close()
+
+			resp := postIndex(t, server, test.uuid, test.body)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestIndexMethodNotAllowed(t *testing.T) {
+	server := newTestServer(new(indexerMock), new(fetcherMock))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + pathIndex + contentUUID)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestPreview(t *testing.T) {
+	indexer := new(indexerMock)
+	indexer.On("Preview", mock.MatchedBy(func(event schema.EnrichedContent) bool {
+		return event.UUID == contentUUID
+	}), map[string]string{originHeader: "http://cmdb.ft.com/systems/cct", contentTypeHeader: "application/vnd.ft-upp-article+json"}).
+		Return(message.IndexResult{ContentType: "article", Collection: "FTCom", Model: &schema.IndexModel{}}, nil)
+	server := newTestServer(indexer, new(fetcherMock))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+pathPreview, strings.NewReader(fmt.Sprintf(`{"uuid":"%s"}`, contentUUID)))
+	require.NoError(t, err)
+	req.Header.Set(originHeader, "http://cmdb.ft.com/systems/cct")
+	req.Header.Set(contentTypeHeader, "application/vnd.ft-upp-article+json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, "article", result["contentType"])
+	assert.Equal(t, "FTCom", result["collection"])
+	assert.Contains(t, result, "model")
+	assert.NotContains(t, result, "result")
+	indexer.AssertExpectations(t)
+	indexer.AssertNotCalled(t, "Index", mock.Anything, mock.Anything)
+}
+
+func TestPreviewFailures(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		previewErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "malformed body",
+			body:           "malformed json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "not indexable",
+			body:           "{}",
+			previewErr:     fmt.Errorf("%w: no content", message.ErrNotIndexable),
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			indexer := new(indexerMock)
+			indexer.On("Preview", mock.Anything, mock.Anything).Return(message.IndexResult{}, test.previewErr)
+			server := newTestServer(indexer, new(fetcherMock))
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+pathPreview, "application/json", strings.NewReader(test.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestInternalContentFetcher(t *testing.T) {
+	client := new(apiClientMock)
+	client.On("SendRequest", http.MethodGet, "/internalcontent/"+contentUUID).Return(&api.Response{StatusCode: http.StatusOK, Body: `{"title":"Title"}`}, nil)
+	client.On("SendRequest", http.MethodGet, "/internalcontent/"+contentUUID+"?expandImages=true").Return(&api.Response{StatusCode: http.StatusNotFound}, nil)
+	fetcher := NewInternalContentFetcher(client, "/internalcontent/")
+
+	raw, err := fetcher.GetRawContent(contentUUID, false)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title":"Title"}`, string(raw))
+
+	_, err = fetcher.GetRawContent(contentUUID, true)
+	assert.Error(t, err)
+	client.AssertExpectations(t)
+}
+
+func TestToEnrichedContentKeepsMainImageMembers(t *testing.T) {
+	raw := []byte(`{"mainImage":{"members":[{"apiUrl":"https://api.ft.com/content/5546cbc4-d4f7-47f9-3f3e-941fb0799c4f"}]}}`)
+
+	event, err := toEnrichedContent(contentUUID, raw)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5546cbc4-d4f7-47f9-3f3e-941fb0799c4f"}, event.Content.MainImage.ImageUUIDs)
+}
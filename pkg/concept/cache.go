@@ -0,0 +1,180 @@
+package concept
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+)
+
+type CacheConfig struct {
+	// Size is the maximum number of cached concepts, the least recently used ones are evicted first
+	Size int
+	TTL  time.Duration
+	// NegativeTTL applies to concepts the reader has no concordance for
+	NegativeTTL time.Duration
+}
+
+// CacheStats are the counters of a CachedReader since it was created.
+type CacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+}
+
+// CachedReader caches the concordances of the wrapped reader per concept ID, including the IDs it has none for,
+// as the same brands, sections and authors annotate thousands of pieces of content.
+type CachedReader struct {
+	reader Reader
+	config CacheConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    uint64
+	misses  uint64
+}
+
+type cacheEntry struct {
+	id      string
+	model   Model
+	found   bool
+	expires time.Time
+}
+
+func NewCachedReader(reader Reader, config CacheConfig) *CachedReader {
+	return &CachedReader{
+		reader:  reader,
+		config:  config,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// GetConcepts only asks the wrapped reader for the concepts that are not cached. Failed lookups are not cached,
+// a *PartialError is returned with the concepts that could be looked up.
+func (c *CachedReader) GetConcepts(ctx context.Context, tid string, ids []string) (map[string]Model, error) {
+	concepts := make(map[string]Model)
+	var missing []string
+
+	c.mu.Lock()
+	now := c.now()
+	for _, id := range ids {
+		entry, cached := c.get(id, now)
+		metrics.ConcordanceCacheLookup(cached)
+		if !cached {
+			c.misses++
+			missing = append(missing, id)
+			continue
+		}
+		c.hits++
+		if entry.found {
+			concepts[id] = entry.model
+		}
+	}
+	c.mu.Unlock()
+
+	if len(missing) == 0 {
+		return concepts, nil
+	}
+	fetched, err := c.reader.GetConcepts(ctx, tid, missing)
+	var partial *PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, err
+	}
+	// nothing is known about the concepts of the failed chunks, they are neither cached nor returned
+	failed := make(map[string]bool)
+	if partial != nil {
+		for _, id := range partial.FailedIDs {
+			failed[id] = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now = c.now()
+	for _, id := range missing {
+		if failed[id] {
+			continue
+		}
+		model, found := fetched[id]
+		c.put(id, model, found, now)
+		if found {
+			concepts[id] = model
+		}
+	}
+	return concepts, err
+}
+
+// get returns the entry of the concept unless it expired, it must be called with the lock held.
+func (c *CachedReader) get(id string, now time.Time) (*cacheEntry, bool) {
+	element, found := c.entries[id]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+// put caches the concept and evicts the least recently used ones beyond the size, it must be called with the lock held.
+func (c *CachedReader) put(id string, model Model, found bool, now time.Time) {
+	ttl := c.config.TTL
+	if !found {
+		ttl = c.config.NegativeTTL
+	}
+	if ttl <= 0 || c.config.Size <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{id: id, model: model, found: found, expires: now.Add(ttl)}
+	if element, cached := c.entries[id]; cached {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.config.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *CachedReader) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).id)
+}
+
+// Purge forgets a concept, e.g. when its concordances are known to have changed. It tells whether it was cached.
+func (c *CachedReader) Purge(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, found := c.entries[id]
+	if found {
+		c.remove(element)
+	}
+	return found
+}
+
+// PurgeAll empties the cache.
+func (c *CachedReader) PurgeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *CachedReader) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Size: c.lru.Len(), Capacity: c.config.Size}
+}
@@ -0,0 +1,72 @@
+package concept
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+const pathConcordanceCache = "/__concordance-cache"
+
+// CacheHandler exposes the stats of the concordance cache over HTTP and lets concepts be purged from it.
+type CacheHandler struct {
+	cache *CachedReader
+	log   *logger.UPPLogger
+}
+
+func NewCacheHandler(cache *CachedReader, log *logger.UPPLogger) *CacheHandler {
+	return &CacheHandler{cache: cache, log: log}
+}
+
+func (h *CacheHandler) AttachHTTPEndpoints(serveMux *http.ServeMux) *http.ServeMux {
+	serveMux.HandleFunc(pathConcordanceCache, h.cacheRoot)
+	serveMux.HandleFunc(pathConcordanceCache+"/", h.purge)
+	return serveMux
+}
+
+// cacheRoot serves GET /__concordance-cache with the stats and DELETE /__concordance-cache emptying the cache
+func (h *CacheHandler) cacheRoot(writer http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		h.writeJSON(writer, http.StatusOK, h.cache.Stats())
+	case http.MethodDelete:
+		h.cache.PurgeAll()
+		h.log.Info("Concordance cache purged")
+		h.writeJSON(writer, http.StatusOK, h.cache.Stats())
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// purge serves DELETE /__concordance-cache/{uuid} forgetting the concept http://api.ft.com/things/{uuid}
+func (h *CacheHandler) purge(writer http.ResponseWriter, req *http.Request) {
+	uuid := strings.Trim(strings.TrimPrefix(req.URL.Path, pathConcordanceCache), "/")
+	if uuid == "" || strings.Contains(uuid, "/") {
+		http.NotFound(writer, req)
+		return
+	}
+	if req.Method != http.MethodDelete {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := ThingURIPrefix + uuid
+	purged := h.cache.Purge(id)
+	h.log.Infof("Concept %s purged from the concordance cache: %t", id, purged)
+	h.writeJSON(writer, http.StatusOK, map[string]interface{}{"id": id, "purged": purged})
+}
+
+func (h *CacheHandler) writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	response, err := json.Marshal(body)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if _, err = writer.Write(response); err != nil {
+		h.log.WithError(err).Error(err.Error())
+	}
+}
@@ -0,0 +1,164 @@
+package concept
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	brandID   = ThingURIPrefix + "dbb0bdae-1f0c-11e4-b0cb-b2227cce2b54"
+	authorID  = ThingURIPrefix + "9a5e3b4a-55da-498c-816f-9c534e1392bd"
+	unknownID = ThingURIPrefix + "00000000-0000-0000-0000-000000000000"
+)
+
+type readerMock struct {
+	mock.Mock
+}
+
+func (m *readerMock) GetConcepts(ctx context.Context, tid string, ids []string) (map[string]Model, error) {
+	args := m.Called(tid, ids)
+	return args.Get(0).(map[string]Model), args.Error(1)
+}
+
+func newTestCache(reader Reader, size int) (*CachedReader, *time.Time) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewCachedReader(reader, CacheConfig{Size: size, TTL: time.Hour, NegativeTTL: time.Minute})
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func TestCachedReaderOnlyFetchesMissingConcepts(t *testing.T) {
+	reader := new(readerMock)
+	reader.On("GetConcepts", "tid_1", []string{brandID, unknownID}).Return(map[string]Model{brandID: {TmeIDs: []string{"Brand-TME"}}}, nil).Once()
+	reader.On("GetConcepts", "tid_2", []string{authorID}).Return(map[string]Model{authorID: {TmeIDs: []string{"Author-TME"}}}, nil).Once()
+	cache, _ := newTestCache(reader, 10)
+
+	concepts, err := cache.GetConcepts(context.Background(), "tid_1", []string{brandID, unknownID})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Model{brandID: {TmeIDs: []string{"Brand-TME"}}}, concepts)
+
+	concepts, err = cache.GetConcepts(context.Background(), "tid_2", []string{brandID, unknownID, authorID})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Model{brandID: {TmeIDs: []string{"Brand-TME"}}, authorID: {TmeIDs: []string{"Author-TME"}}}, concepts)
+
+	reader.AssertExpectations(t)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 3, Size: 3, Capacity: 10}, cache.Stats())
+}
+
+func TestCachedReaderExpiresConcepts(t *testing.T) {
+	reader := new(readerMock)
+	reader.On("GetConcepts", "tid", []string{brandID, unknownID}).Return(map[string]Model{brandID: {}}, nil).Once()
+	reader.On("GetConcepts", "tid", []string{unknownID}).Return(map[string]Model{}, nil).Once()
+	reader.On("GetConcepts", "tid", []string{brandID, unknownID}).Return(map[string]Model{brandID: {}}, nil).Once()
+	cache, now := newTestCache(reader, 10)
+
+	_, err := cache.GetConcepts(context.Background(), "tid", []string{brandID, unknownID})
+	require.NoError(t, err)
+	// concepts without concordances expire first
+	*now = now.Add(2 * time.Minute)
+	_, err = cache.GetConcepts(context.Background(), "tid", []string{brandID, unknownID})
+	require.NoError(t, err)
+	*now = now.Add(time.Hour)
+	_, err = cache.GetConcepts(context.Background(), "tid", []string{brandID, unknownID})
+	require.NoError(t, err)
+
+	reader.AssertExpectations(t)
+}
+
+func TestCachedReaderEvictsLeastRecentlyUsed(t *testing.T) {
+	reader := new(readerMock)
+	reader.On("GetConcepts", "tid", mock.AnythingOfType("[]string")).Return(map[string]Model{}, nil)
+	cache, _ := newTestCache(reader, 2)
+
+	for _, id := range []string{brandID, authorID, brandID, unknownID} {
+		_, err := cache.GetConcepts(context.Background(), "tid", []string{id})
+		require.NoError(t, err)
+	}
+
+	assert.True(t, cache.Purge(brandID))
+	assert.True(t, cache.Purge(unknownID))
+	assert.False(t, cache.Purge(authorID))
+}
+
+func TestCachedReaderDoesNotCacheFailures(t *testing.T) {
+	reader := new(readerMock)
+	reader.On("GetConcepts", "tid", []string{brandID}).Return(map[string]Model(nil), errors.New("calling Concordance API returned HTTP status 503")).Once()
+	reader.On("GetConcepts", "tid", []string{brandID}).Return(map[string]Model{brandID: {}}, nil).Once()
+	cache, _ := newTestCache(reader, 10)
+
+	_, err := cache.GetConcepts(context.Background(), "tid", []string{brandID})
+	assert.Error(t, err)
+	concepts, err := cache.GetConcepts(context.Background(), "tid", []string{brandID})
+	require.NoError(t, err)
+	assert.Contains(t, concepts, brandID)
+
+	reader.AssertExpectations(t)
+}
+
+func TestCacheHandler(t *testing.T) {
+	reader := new(readerMock)
+	reader.On("GetConcepts", "tid", []string{brandID, authorID}).Return(map[string]Model{brandID: {}, authorID: {}}, nil).Once()
+	cache, _ := newTestCache(reader, 10)
+	_, err := cache.GetConcepts(context.Background(), "tid", []string{brandID, authorID})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(NewCacheHandler(cache, logger.NewUPPLogger("test", "PANIC")).AttachHTTPEndpoints(http.NewServeMux()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/__concordance-cache")
+	require.NoError(t, err)
+	var stats CacheStats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	resp.Body.Close()
+	assert.Equal(t, CacheStats{Misses: 2, Size: 2, Capacity: 10}, stats)
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/__concordance-cache/dbb0bdae-1f0c-11e4-b0cb-b2227cce2b54", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var purged map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&purged))
+	resp.Body.Close()
+	assert.Equal(t, map[string]interface{}{"id": brandID, "purged": true}, purged)
+	assert.Equal(t, 1, cache.Stats().Size)
+
+	req, err = http.NewRequest(http.MethodDelete, server.URL+"/__concordance-cache", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, cache.Stats().Size)
+
+	resp, err = http.Post(server.URL+"/__concordance-cache/dbb0bdae-1f0c-11e4-b0cb-b2227cce2b54", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestCachedReaderOnlyCachesLookedUpConceptsOfPartialFailures(t *testing.T) {
+	partial := &PartialError{FailedIDs: []string{authorID}, Chunks: 2, FailedChunks: 1, Err: errors.New("calling Concordance API returned HTTP status 503")}
+	reader := new(readerMock)
+	reader.On("GetConcepts", "tid", []string{brandID, unknownID, authorID}).Return(map[string]Model{brandID: {}}, partial).Once()
+	reader.On("GetConcepts", "tid", []string{authorID}).Return(map[string]Model{authorID: {}}, nil).Once()
+	cache, _ := newTestCache(reader, 10)
+
+	concepts, err := cache.GetConcepts(context.Background(), "tid", []string{brandID, unknownID, authorID})
+	assert.Equal(t, partial, err)
+	assert.Equal(t, map[string]Model{brandID: {}}, concepts)
+
+	concepts, err = cache.GetConcepts(context.Background(), "tid", []string{brandID, unknownID, authorID})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Model{brandID: {}, authorID: {}}, concepts)
+	reader.AssertExpectations(t)
+}
@@ -1,10 +1,17 @@
 package concept
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/retry"
 )
 
 const (
@@ -13,6 +20,11 @@ const (
 	concordancesQueryParam = "conceptId"
 	tmeAuthority           = "http://api.ft.com/system/FT-TME"
 	uppAuthority           = "http://api.ft.com/system/UPP"
+	getConceptsOperation   = "Concordance API lookup"
+
+	// DefaultChunkSize keeps the query string of a request around 2KB, proxies reject far longer URLs
+	DefaultChunkSize             = 25
+	DefaultMaxConcurrentRequests = 4
 )
 
 type Concept struct {
@@ -36,10 +48,12 @@ type ConcordancesResponse struct {
 
 type Model struct {
 	TmeIDs []string
+	// Identifiers are the values of the other authorities the concept is concorded with, by authority
+	Identifiers map[string][]string
 }
 
 type Reader interface {
-	GetConcepts(tid string, ids []string) (map[string]Model, error)
+	GetConcepts(ctx context.Context, tid string, ids []string) (map[string]Model, error)
 }
 
 type Client interface {
@@ -49,16 +63,121 @@ type Client interface {
 type ConcordanceAPIService struct {
 	ConcordanceAPIBaseURL string
 	Client                Client
+	// ChunkSize is the maximum number of IDs looked up by a single request
+	ChunkSize int
+	// MaxConcurrentRequests bounds the requests sent at once for the chunks of a lookup
+	MaxConcurrentRequests int
+	retrier               *retry.Retrier
+}
+
+// PartialError is returned together with the concepts of the chunks that were looked up when the other chunks failed.
+type PartialError struct {
+	// FailedIDs are the IDs of the failed chunks, nothing is known about their concordances
+	FailedIDs    []string
+	Chunks       int
+	FailedChunks int
+	// Err is the failure of the first failed chunk
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d Concordance API requests failed, concordances of %d concepts are missing: %v", e.FailedChunks, e.Chunks, len(e.FailedIDs), e.Err)
 }
 
-func NewConcordanceAPIService(concordanceAPIBaseURL string, c Client) *ConcordanceAPIService {
-	return &ConcordanceAPIService{ConcordanceAPIBaseURL: concordanceAPIBaseURL, Client: c}
+func (e *PartialError) Unwrap() error {
+	return e.Err
 }
 
-func (c *ConcordanceAPIService) GetConcepts(tid string, ids []string) (map[string]Model, error) {
-	req, err := http.NewRequest(http.MethodGet, c.ConcordanceAPIBaseURL+concordancesEndpoint, nil)
+// statusError is returned when the Concordance API answers with a non-200 HTTP status
+type statusError struct {
+	statusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("calling Concordance API returned HTTP status %v", e.statusCode)
+}
+
+func NewConcordanceAPIService(concordanceAPIBaseURL string, c Client, retrier *retry.Retrier) *ConcordanceAPIService {
+	return &ConcordanceAPIService{
+		ConcordanceAPIBaseURL: concordanceAPIBaseURL,
+		Client:                c,
+		ChunkSize:             DefaultChunkSize,
+		MaxConcurrentRequests: DefaultMaxConcurrentRequests,
+		retrier:               retrier,
+	}
+}
+
+// GetConcepts looks the IDs up in chunks of ChunkSize, sent concurrently. When only some of the chunks fail,
+// the concepts of the others are returned with a *PartialError. When all of them fail, the first failure is returned.
+// The requests are cancelled when ctx is done.
+func (c *ConcordanceAPIService) GetConcepts(ctx context.Context, tid string, ids []string) (map[string]Model, error) {
+	chunks := chunk(ids, c.ChunkSize)
+	responses := make([]ConcordancesResponse, len(chunks))
+	errs := make([]error, len(chunks))
+
+	maxConcurrentRequests := c.MaxConcurrentRequests
+	if maxConcurrentRequests < 1 {
+		maxConcurrentRequests = 1
+	}
+	semaphore := make(chan struct{}, maxConcurrentRequests)
+	var wg sync.WaitGroup
+	for i := range chunks {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			errs[i] = c.retrier.Do(ctx, getConceptsOperation, func() (err error) {
+				responses[i], err = c.getConcordances(ctx, tid, chunks[i])
+				return err
+			}, isRetryable)
+		}(i)
+	}
+	wg.Wait()
+
+	var merged ConcordancesResponse
+	partial := &PartialError{Chunks: len(chunks)}
+	for i, err := range errs {
+		if err != nil {
+			partial.FailedChunks++
+			partial.FailedIDs = append(partial.FailedIDs, chunks[i]...)
+			if partial.Err == nil {
+				partial.Err = err
+			}
+			continue
+		}
+		merged.Concordances = append(merged.Concordances, responses[i].Concordances...)
+	}
+	if partial.FailedChunks == len(chunks) && partial.Err != nil {
+		return nil, partial.Err
+	}
+	concepts := TransformToConceptModel(merged)
+	if partial.FailedChunks > 0 {
+		return concepts, partial
+	}
+	return concepts, nil
+}
+
+// chunk splits the IDs in chunks of at most size IDs, there is a single chunk when size isn't positive.
+func chunk(ids []string, size int) [][]string {
+	if size <= 0 || len(ids) <= size {
+		return [][]string{ids}
+	}
+	chunks := make([][]string, 0, (len(ids)+size-1)/size)
+	for size < len(ids) {
+		chunks = append(chunks, ids[:size:size])
+		ids = ids[size:]
+	}
+	return append(chunks, ids)
+}
+
+func (c *ConcordanceAPIService) getConcordances(ctx context.Context, tid string, ids []string) (ConcordancesResponse, error) {
+	var concordancesResp ConcordancesResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.ConcordanceAPIBaseURL+concordancesEndpoint, nil)
 	if err != nil {
-		return nil, err
+		return concordancesResp, err
 	}
 
 	queryParams := req.URL.Query()
@@ -71,31 +190,39 @@ func (c *ConcordanceAPIService) GetConcepts(tid string, ids []string) (map[strin
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("X-Request-Id", tid)
 
+	start := time.Now()
 	resp, err := c.Client.Do(req)
+	metrics.ObserveConcordance(start)
 	if err != nil {
-		return nil, err
+		return concordancesResp, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("calling Concordance API returned HTTP status %v", resp.StatusCode)
+		return concordancesResp, &statusError{statusCode: resp.StatusCode}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return concordancesResp, err
 	}
 
-	var concordancesResp ConcordancesResponse
-	if err = json.Unmarshal(body, &concordancesResp); err != nil {
-		return nil, err
-	}
+	err = json.Unmarshal(body, &concordancesResp)
+	return concordancesResp, err
+}
 
-	return TransformToConceptModel(concordancesResp), nil
+// isRetryable tells whether a failed lookup may succeed when attempted again
+func isRetryable(err error) bool {
+	if statusErr, ok := err.(*statusError); ok {
+		return statusErr.statusCode == http.StatusTooManyRequests || statusErr.statusCode >= http.StatusInternalServerError
+	}
+	_, isNetErr := err.(net.Error)
+	return isNetErr
 }
 
 func TransformToConceptModel(concordancesResp ConcordancesResponse) map[string]Model {
 	conceptMap := make(map[string]Model)
+	var aliases []Concordance
 	for _, c := range concordancesResp.Concordances {
 		_, found := conceptMap[c.Concept.ID]
 		if !found {
@@ -107,11 +234,23 @@ func TransformToConceptModel(concordancesResp ConcordancesResponse) map[string]M
 			concept.TmeIDs = append(concept.TmeIDs, c.Identifier.IdentifierValue)
 			conceptMap[c.Concept.ID] = concept
 		}
-		if c.Identifier.Authority == uppAuthority {
-			_, found := conceptMap[ThingURIPrefix+c.Identifier.IdentifierValue]
-			if !found {
-				conceptMap[ThingURIPrefix+c.Identifier.IdentifierValue] = conceptMap[c.Concept.ID]
+		if c.Identifier.Authority != tmeAuthority && c.Identifier.Authority != uppAuthority {
+			concept := conceptMap[c.Concept.ID]
+			if concept.Identifiers == nil {
+				concept.Identifiers = make(map[string][]string)
 			}
+			concept.Identifiers[c.Identifier.Authority] = append(concept.Identifiers[c.Identifier.Authority], c.Identifier.IdentifierValue)
+			conceptMap[c.Concept.ID] = concept
+		}
+		if c.Identifier.Authority == uppAuthority {
+			aliases = append(aliases, c)
+		}
+	}
+	// the UPP identifiers alias the concepts once all their concordances are read, whatever their order
+	for _, c := range aliases {
+		_, found := conceptMap[ThingURIPrefix+c.Identifier.IdentifierValue]
+		if !found {
+			conceptMap[ThingURIPrefix+c.Identifier.IdentifierValue] = conceptMap[c.Concept.ID]
 		}
 	}
 
@@ -1,13 +1,16 @@
 package concept
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/retry"
 	"github.com/gorilla/mux"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
@@ -104,9 +107,9 @@ func TestConcordanceApiService_GetConceptsSuccessfully(t *testing.T) {
 	mockServer.On("RequestConcordances", "tid_test", "application/json", []string{sampleID}).Return(http.StatusOK, body)
 	server := mockServer.startMockServer(t)
 
-	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient)
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
 
-	concepts, err := concordanceAPIService.GetConcepts("tid_test", []string{sampleID})
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
 
 	expect.NoError(err)
 	expect.Equal(expected, concepts)
@@ -122,9 +125,9 @@ func TestConcordanceApiService_GetConceptsServiceUnavailable(t *testing.T) {
 	mockServer.On("RequestConcordances", "tid_test", "application/json", []string{sampleID}).Return(http.StatusServiceUnavailable, []byte{})
 	server := mockServer.startMockServer(t)
 
-	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient)
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
 
-	concepts, err := concordanceAPIService.GetConcepts("tid_test", []string{sampleID})
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
 
 	expect.Error(err)
 	expect.Equal("calling Concordance API returned HTTP status 503", err.Error())
@@ -132,14 +135,54 @@ func TestConcordanceApiService_GetConceptsServiceUnavailable(t *testing.T) {
 	mock.AssertExpectationsForObjects(t, mockServer)
 }
 
+func TestConcordanceApiService_GetConceptsRetriesServiceUnavailable(t *testing.T) {
+	expect := assert.New(t)
+
+	sampleID := ThingURIPrefix + uuid.NewRandom().String()
+	concordances := ConcordancesResponse{Concordances: []Concordance{{Concept: Concept{ID: sampleID}, Identifier: Identifier{Authority: tmeAuthority, IdentifierValue: "tme-id"}}}}
+	body, err := json.Marshal(concordances)
+	expect.NoError(err)
+
+	mockServer := new(mockConcordanceApiServer)
+	mockServer.On("RequestConcordances", "tid_test", "application/json", []string{sampleID}).Return(http.StatusServiceUnavailable, []byte{}).Once()
+	mockServer.On("RequestConcordances", "tid_test", "application/json", []string{sampleID}).Return(http.StatusOK, body).Once()
+	server := mockServer.startMockServer(t)
+
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, retry.NewRetrier(retry.Policy{MaxAttempts: 3}, nil))
+
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
+
+	expect.NoError(err)
+	expect.Equal([]string{"tme-id"}, concepts[sampleID].TmeIDs)
+	mock.AssertExpectationsForObjects(t, mockServer)
+}
+
+func TestConcordanceApiService_GetConceptsDoesNotRetryBadRequest(t *testing.T) {
+	expect := assert.New(t)
+
+	sampleID := ThingURIPrefix + uuid.NewRandom().String()
+
+	mockServer := new(mockConcordanceApiServer)
+	mockServer.On("RequestConcordances", "tid_test", "application/json", []string{sampleID}).Return(http.StatusBadRequest, []byte{}).Once()
+	server := mockServer.startMockServer(t)
+
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, retry.NewRetrier(retry.Policy{MaxAttempts: 3}, nil))
+
+	_, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
+
+	expect.Error(err)
+	expect.Equal("calling Concordance API returned HTTP status 400", err.Error())
+	mock.AssertExpectationsForObjects(t, mockServer)
+}
+
 func TestConcordanceApiService_GetConceptsErrorOnNewRequest(t *testing.T) {
 	expect := assert.New(t)
 
 	sampleID := ThingURIPrefix + uuid.NewRandom().String()
 
-	concordanceAPIService := NewConcordanceAPIService(":/", http.DefaultClient)
+	concordanceAPIService := NewConcordanceAPIService(":/", http.DefaultClient, nil)
 
-	concepts, err := concordanceAPIService.GetConcepts("tid_test", []string{sampleID})
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
 
 	expect.Error(err)
 	expect.Nil(concepts)
@@ -152,9 +195,9 @@ func TestConcordanceApiService_GetConceptsErrorOnRequestDo(t *testing.T) {
 
 	sampleID := ThingURIPrefix + uuid.NewRandom().String()
 
-	concordanceAPIService := NewConcordanceAPIService("http://test-url", mockClient)
+	concordanceAPIService := NewConcordanceAPIService("http://test-url", mockClient, nil)
 
-	concepts, err := concordanceAPIService.GetConcepts("tid_test", []string{sampleID})
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
 
 	expect.Error(err)
 	expect.Equal("http client err", err.Error())
@@ -173,9 +216,9 @@ func TestConcordanceApiService_GetConceptsErrorOnResponseBodyRead(t *testing.T)
 
 	sampleID := ThingURIPrefix + uuid.NewRandom().String()
 
-	concordanceAPIService := NewConcordanceAPIService("http://test-url", mockClient)
+	concordanceAPIService := NewConcordanceAPIService("http://test-url", mockClient, nil)
 
-	concepts, err := concordanceAPIService.GetConcepts("tid_test", []string{sampleID})
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
 
 	expect.Error(err)
 	expect.Equal("read err", err.Error())
@@ -193,9 +236,9 @@ func TestConcordanceApiService_GetConceptsErrorOnInvalidJSONResponse(t *testing.
 	mockServer.On("RequestConcordances", "tid_test", "application/json", []string{sampleID}).Return(http.StatusOK, []byte("{invalid JSON}"))
 	server := mockServer.startMockServer(t)
 
-	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient)
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
 
-	concepts, err := concordanceAPIService.GetConcepts("tid_test", []string{sampleID})
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", []string{sampleID})
 
 	expect.Error(err)
 	expect.Equal("invalid character 'i' looking for beginning of object key string", err.Error())
@@ -209,7 +252,7 @@ func TestConcordanceApiService_CheckHealthSuccessfully(t *testing.T) {
 	mockServer.On("GTG").Return(http.StatusOK)
 	server := mockServer.startMockServer(t)
 
-	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient)
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
 
 	check, err := concordanceAPIService.HealthCheck()
 	expect.NoError(err)
@@ -223,7 +266,7 @@ func TestConcordanceApiService_CheckHealthUnhealthy(t *testing.T) {
 	mockServer.On("GTG").Return(http.StatusServiceUnavailable)
 	server := mockServer.startMockServer(t)
 
-	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient)
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
 
 	check, err := concordanceAPIService.HealthCheck()
 	expect.Error(err)
@@ -235,7 +278,7 @@ func TestConcordanceApiService_CheckHealthUnhealthy(t *testing.T) {
 func TestConcordanceApiService_CheckHealthErrorOnNewRequest(t *testing.T) {
 	expect := assert.New(t)
 
-	concordanceAPIService := NewConcordanceAPIService(":/", http.DefaultClient)
+	concordanceAPIService := NewConcordanceAPIService(":/", http.DefaultClient, nil)
 
 	check, err := concordanceAPIService.HealthCheck()
 	expect.Error(err)
@@ -247,10 +290,155 @@ func TestConcordanceApiService_CheckHealthErrorOnRequestDo(t *testing.T) {
 	mockClient := new(mockHttpClient)
 	mockClient.On("Do", mock.AnythingOfType("*http.Request")).Return(&http.Response{}, errors.New("http client err"))
 
-	concordanceAPIService := NewConcordanceAPIService("http://test-url", mockClient)
+	concordanceAPIService := NewConcordanceAPIService("http://test-url", mockClient, nil)
 
 	check, err := concordanceAPIService.HealthCheck()
 	expect.Error(err)
 	expect.Empty(check)
 	expect.Equal("http client err", err.Error())
 }
+
+func tmeConcordances(t *testing.T, ids ...string) []byte {
+	var concordances ConcordancesResponse
+	for _, id := range ids {
+		concordances.Concordances = append(concordances.Concordances, Concordance{Concept: Concept{ID: id}, Identifier: Identifier{Authority: tmeAuthority, IdentifierValue: "TME-" + id}})
+	}
+	body, err := json.Marshal(concordances)
+	assert.NoError(t, err)
+	return body
+}
+
+func TestConcordanceApiService_GetConceptsInChunks(t *testing.T) {
+	expect := assert.New(t)
+
+	ids := []string{ThingURIPrefix + "1", ThingURIPrefix + "2", ThingURIPrefix + "3", ThingURIPrefix + "4", ThingURIPrefix + "5"}
+
+	mockServer := new(mockConcordanceApiServer)
+	mockServer.On("RequestConcordances", "tid_test", "application/json", ids[:2]).Return(http.StatusOK, tmeConcordances(t, ids[:2]...)).Once()
+	mockServer.On("RequestConcordances", "tid_test", "application/json", ids[2:4]).Return(http.StatusOK, tmeConcordances(t, ids[2:4]...)).Once()
+	mockServer.On("RequestConcordances", "tid_test", "application/json", ids[4:]).Return(http.StatusOK, tmeConcordances(t, ids[4:]...)).Once()
+	server := mockServer.startMockServer(t)
+
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
+	concordanceAPIService.ChunkSize = 2
+	concordanceAPIService.MaxConcurrentRequests = 2
+
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", ids)
+
+	expect.NoError(err)
+	expect.Len(concepts, 5)
+	for _, id := range ids {
+		expect.Equal([]string{"TME-" + id}, concepts[id].TmeIDs)
+	}
+	mock.AssertExpectationsForObjects(t, mockServer)
+}
+
+func TestConcordanceApiService_GetConceptsPartialFailure(t *testing.T) {
+	expect := assert.New(t)
+
+	ids := []string{ThingURIPrefix + "1", ThingURIPrefix + "2", ThingURIPrefix + "3"}
+
+	mockServer := new(mockConcordanceApiServer)
+	mockServer.On("RequestConcordances", "tid_test", "application/json", ids[:2]).Return(http.StatusOK, tmeConcordances(t, ids[:2]...))
+	mockServer.On("RequestConcordances", "tid_test", "application/json", ids[2:]).Return(http.StatusBadRequest, []byte{})
+	server := mockServer.startMockServer(t)
+
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
+	concordanceAPIService.ChunkSize = 2
+
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", ids)
+
+	var partial *PartialError
+	expect.True(errors.As(err, &partial))
+	expect.Equal([]string{ids[2]}, partial.FailedIDs)
+	expect.Equal(2, partial.Chunks)
+	expect.Equal(1, partial.FailedChunks)
+	expect.Equal("1 of 2 Concordance API requests failed, concordances of 1 concepts are missing: calling Concordance API returned HTTP status 400", err.Error())
+	expect.Len(concepts, 2)
+	expect.Contains(concepts, ids[0])
+	expect.Contains(concepts, ids[1])
+}
+
+func TestConcordanceApiService_GetConceptsTotalFailure(t *testing.T) {
+	expect := assert.New(t)
+
+	ids := []string{ThingURIPrefix + "1", ThingURIPrefix + "2", ThingURIPrefix + "3"}
+
+	mockServer := new(mockConcordanceApiServer)
+	mockServer.On("RequestConcordances", "tid_test", "application/json", mock.Anything).Return(http.StatusBadRequest, []byte{})
+	server := mockServer.startMockServer(t)
+
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, nil)
+	concordanceAPIService.ChunkSize = 2
+
+	concepts, err := concordanceAPIService.GetConcepts(context.Background(), "tid_test", ids)
+
+	var partial *PartialError
+	expect.False(errors.As(err, &partial))
+	expect.Equal("calling Concordance API returned HTTP status 400", err.Error())
+	expect.Nil(concepts)
+}
+
+func TestConcordanceApiService_GetConceptsIsCancelledWithContext(t *testing.T) {
+	expect := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	concordanceAPIService := NewConcordanceAPIService(server.URL, http.DefaultClient, retry.NewRetrier(retry.Policy{MaxAttempts: 3}, nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	concepts, err := concordanceAPIService.GetConcepts(ctx, "tid_test", []string{ThingURIPrefix + uuid.NewRandom().String()})
+
+	expect.True(errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+	expect.Nil(concepts)
+}
+
+func TestTransformToConceptModelKeepsOtherAuthorities(t *testing.T) {
+	conceptID := ThingURIPrefix + "1"
+	tickerAuthority := "http://api.ft.com/system/TICKER"
+	concordances := ConcordancesResponse{Concordances: []Concordance{
+		{Concept: Concept{ID: conceptID}, Identifier: Identifier{Authority: tmeAuthority, IdentifierValue: "TME-1"}},
+		{Concept: Concept{ID: conceptID}, Identifier: Identifier{Authority: tickerAuthority, IdentifierValue: "PSON:LSE"}},
+		{Concept: Concept{ID: conceptID}, Identifier: Identifier{Authority: tickerAuthority, IdentifierValue: "PSO:NYQ"}},
+		{Concept: Concept{ID: conceptID}, Identifier: Identifier{Authority: uppAuthority, IdentifierValue: "1"}},
+	}}
+
+	concepts := TransformToConceptModel(concordances)
+
+	assert.Equal(t, Model{
+		TmeIDs:      []string{"TME-1"},
+		Identifiers: map[string][]string{tickerAuthority: {"PSON:LSE", "PSO:NYQ"}},
+	}, concepts[conceptID])
+}
+
+func TestTransformToConceptModelAliasesAfterAllConcordances(t *testing.T) {
+	conceptID := ThingURIPrefix + "1"
+	tickerAuthority := "http://api.ft.com/system/TICKER"
+	// the UPP identifier of the concept comes before its ticker and TME identifiers
+	concordances := ConcordancesResponse{Concordances: []Concordance{
+		{Concept: Concept{ID: conceptID}, Identifier: Identifier{Authority: uppAuthority, IdentifierValue: "2"}},
+		{Concept: Concept{ID: conceptID}, Identifier: Identifier{Authority: tickerAuthority, IdentifierValue: "PSON:LSE"}},
+		{Concept: Concept{ID: conceptID}, Identifier: Identifier{Authority: tmeAuthority, IdentifierValue: "TME-1"}},
+	}}
+
+	concepts := TransformToConceptModel(concordances)
+
+	expected := Model{
+		TmeIDs:      []string{"TME-1"},
+		Identifiers: map[string][]string{tickerAuthority: {"PSON:LSE"}},
+	}
+	assert.Equal(t, expected, concepts[conceptID])
+	assert.Equal(t, expected, concepts[ThingURIPrefix+"2"])
+}
+
+func TestChunk(t *testing.T) {
+	ids := []string{"1", "2", "3", "4", "5"}
+
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}, chunk(ids, 2))
+	assert.Equal(t, [][]string{ids}, chunk(ids, 5))
+	assert.Equal(t, [][]string{ids}, chunk(ids, 0))
+}
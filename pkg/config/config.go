@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"strings"
 
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/html"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/schema"
 	// This blank import is required in order to read the embedded config files
 	_ "github.com/Financial-Times/content-rw-elasticsearch/v2/statik"
@@ -24,16 +25,43 @@ const (
 	AudioType   = "audio"
 
 	PACOrigin = "http://cmdb.ft.com/systems/pac"
+
+	// ImagePlaceholder is replaced with the UUID of the image in the image service URL
+	ImagePlaceholder = "[image_uuid]"
+
+	// DefaultTextPipelines are used for the content types without text pipelines of their own
+	DefaultTextPipelines = "default"
 )
 
 type ESContentTypeMetadataMap map[string]schema.ContentType
 type Map map[string]string
 type ContentMetadataMap map[string]ContentMetadata
 
+// TextPipelines transform the text fields of a content type.
+type TextPipelines struct {
+	Headline *html.Pipeline
+	Byline   *html.Pipeline
+	Body     *html.Pipeline
+}
+
+type TextPipelinesMap map[string]TextPipelines
+
 type ContentMetadata struct {
-	Origin      string
-	Authority   string
-	ContentType string
+	Origin       string
+	Authority    string
+	ContentType  string
+	ProviderName string
+}
+
+// FieldRules tell which annotations and concordances populate the fields that are not read from a single concept type.
+// The predicates are resolved from their names when the config is parsed.
+type FieldRules struct {
+	// EditorialPredicates are the predicates of the annotations added by editors, the others are added automatically
+	EditorialPredicates []string
+	// ArticleBrandPredicates are the predicates of the brand annotations indexed as article brands
+	ArticleBrandPredicates []string
+	// TickerAuthorities are the concordance authorities whose identifiers are the ticker codes of companies
+	TickerAuthorities []string
 }
 
 func (c Map) Get(key string) string {
@@ -48,11 +76,21 @@ func (c ContentMetadataMap) Get(key string) ContentMetadata {
 	return c[strings.ToLower(key)]
 }
 
+func (c TextPipelinesMap) Get(key string) TextPipelines {
+	if pipelines, found := c[strings.ToLower(key)]; found {
+		return pipelines
+	}
+	return c[DefaultTextPipelines]
+}
+
 type AppConfig struct {
 	Predicates               Map
 	ConceptTypes             Map
 	ContentMetadataMap       ContentMetadataMap
 	ESContentTypeMetadataMap ESContentTypeMetadataMap
+	ImageServiceURL          string
+	TextPipelines            TextPipelinesMap
+	FieldRules               FieldRules
 }
 
 func ParseConfig(configFileName string) (AppConfig, error) {
@@ -60,15 +98,18 @@ func ParseConfig(configFileName string) (AppConfig, error) {
 	if err != nil {
 		return AppConfig{}, err
 	}
+	return parseConfig(contents)
+}
 
+func parseConfig(contents []byte) (AppConfig, error) {
 	v := viper.New()
 	v.SetConfigType("yaml")
-	if err = v.ReadConfig(bytes.NewBuffer(contents)); err != nil {
+	if err := v.ReadConfig(bytes.NewBuffer(contents)); err != nil {
 		return AppConfig{}, err
 	}
 
 	var contentMetadataMap ContentMetadataMap
-	err = v.UnmarshalKey("contentMetadata", &contentMetadataMap)
+	err := v.UnmarshalKey("contentMetadata", &contentMetadataMap)
 	if err != nil {
 		return AppConfig{}, fmt.Errorf("unable to unmarshal %w", err)
 	}
@@ -81,14 +122,113 @@ func ParseConfig(configFileName string) (AppConfig, error) {
 		return AppConfig{}, fmt.Errorf("unable to unmarshal %w", err)
 	}
 
+	imageServiceURL := v.GetString("imageServiceURL")
+	if !strings.Contains(imageServiceURL, ImagePlaceholder) {
+		return AppConfig{}, fmt.Errorf("imageServiceURL %q does not contain the %s placeholder", imageServiceURL, ImagePlaceholder)
+	}
+
+	textPipelines, err := parseTextPipelines(v)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	fieldRules, err := parseFieldRules(v, predicates)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
 	return AppConfig{
 		Predicates:               predicates,
 		ConceptTypes:             concepts,
 		ContentMetadataMap:       contentMetadataMap,
 		ESContentTypeMetadataMap: contentTypeMetadataMap,
+		ImageServiceURL:          imageServiceURL,
+		TextPipelines:            textPipelines,
+		FieldRules:               fieldRules,
 	}, nil
 }
 
+// parseFieldRules resolves the predicate names of the field rules, so that a misspelt predicate fails at startup.
+func parseFieldRules(v *viper.Viper, predicates Map) (FieldRules, error) {
+	var rules FieldRules
+	err := v.UnmarshalKey("fieldRules", &rules)
+	if err != nil {
+		return FieldRules{}, fmt.Errorf("unable to unmarshal %w", err)
+	}
+	resolve := func(rule string, names []string) ([]string, error) {
+		uris := make([]string, 0, len(names))
+		for _, name := range names {
+			uri := predicates.Get(name)
+			if uri == "" {
+				return nil, fmt.Errorf("the %s field rule uses the undeclared predicate %q", rule, name)
+			}
+			uris = append(uris, uri)
+		}
+		return uris, nil
+	}
+	if rules.EditorialPredicates, err = resolve("editorialPredicates", rules.EditorialPredicates); err != nil {
+		return FieldRules{}, err
+	}
+	if rules.ArticleBrandPredicates, err = resolve("articleBrandPredicates", rules.ArticleBrandPredicates); err != nil {
+		return FieldRules{}, err
+	}
+	return rules, nil
+}
+
+// parseTextPipelines builds the transformer pipelines and assigns them to the text fields of the content types,
+// so that an unknown transformer, an invalid regex or a missing pipeline fails at startup.
+func parseTextPipelines(v *viper.Viper) (TextPipelinesMap, error) {
+	var steps map[string][]html.Step
+	err := v.UnmarshalKey("transformerPipelines", &steps)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %w", err)
+	}
+	pipelines := make(map[string]*html.Pipeline, len(steps))
+	for name, pipelineSteps := range steps {
+		pipeline, err := html.NewPipeline(pipelineSteps)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transformer pipeline %s: %w", name, err)
+		}
+		// the keys are lower-cased by viper, so the pipelines are looked up case-insensitively
+		pipelines[strings.ToLower(name)] = pipeline
+	}
+
+	var names map[string]struct {
+		Headline string
+		Byline   string
+		Body     string
+	}
+	err = v.UnmarshalKey("textPipelines", &names)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %w", err)
+	}
+	if _, found := names[DefaultTextPipelines]; !found {
+		return nil, fmt.Errorf("textPipelines does not declare the %s text pipelines", DefaultTextPipelines)
+	}
+	textPipelines := make(TextPipelinesMap, len(names))
+	for contentType, fields := range names {
+		lookup := func(field string, name string) (*html.Pipeline, error) {
+			pipeline, found := pipelines[strings.ToLower(name)]
+			if !found {
+				return nil, fmt.Errorf("the %s of %s uses the undeclared transformer pipeline %q", field, contentType, name)
+			}
+			return pipeline, nil
+		}
+		var p TextPipelines
+		if p.Headline, err = lookup("headline", fields.Headline); err != nil {
+			return nil, err
+		}
+		if p.Byline, err = lookup("byline", fields.Byline); err != nil {
+			return nil, err
+		}
+		if p.Body, err = lookup("body", fields.Body); err != nil {
+			return nil, err
+		}
+		textPipelines[strings.ToLower(contentType)] = p
+	}
+	return textPipelines, nil
+}
+
 func ReadEmbeddedResource(fileName string) ([]byte, error) {
 	statikFS, err := fs.New()
 	if err != nil {
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const imageServiceURLConfig = `
+imageServiceURL: "https://images.example.com/[image_uuid]"
+`
+
+func TestParseConfigTextPipelines(t *testing.T) {
+	appConfig, err := parseConfig([]byte(imageServiceURLConfig + `
+transformerPipelines:
+  text:
+    - transformer: tags
+    - transformer: trim
+  videoBody:
+    - remove: '(?s)<ft-embed(\s|>).*?</ft-embed>'
+    - transformer: extract
+      drop: ["script"]
+textPipelines:
+  default:
+    headline: text
+    byline: text
+    body: text
+  video:
+    headline: text
+    byline: text
+    body: videoBody
+`))
+	require.NoError(t, err)
+
+	body := `<p>Before<ft-embed>embedded</ft-embed></p><script>x</script><p>after</p>`
+	assert.Equal(t, "Beforeembeddedxafter", appConfig.TextPipelines.Get(ArticleType).Body.Transform(body))
+	assert.Equal(t, "Before\n\nafter", appConfig.TextPipelines.Get("Video").Body.Transform(body))
+	assert.Equal(t, "headline", appConfig.TextPipelines.Get(VideoType).Headline.Transform(" <b>headline</b> "))
+}
+
+func TestExtractedBodyPipeline(t *testing.T) {
+	contents, err := ReadEmbeddedResource("app.yml")
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "    body: body\n")
+	appConfig, err := parseConfig([]byte(strings.Replace(string(contents), "    body: body\n", "    body: extractedBody\n", 1)))
+	require.NoError(t, err)
+
+	body := `<body><p>First paragraph.</p><pull-quote><pull-quote-text>Quoted</pull-quote-text></pull-quote><p>Second [caption id="1"]paragraph</p></body>`
+	assert.Equal(t, "First paragraph.\n\nSecond paragraph", appConfig.TextPipelines.Get(ArticleType).Body.Transform(body))
+}
+
+func TestParseConfigRejectsInvalidTextPipelines(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name: "unknown transformer",
+			config: `
+transformerPipelines:
+  text:
+    - transformer: uppercase
+textPipelines:
+  default: {headline: text, byline: text, body: text}
+`,
+			err: `invalid transformer pipeline text: step 1: unknown transformer "uppercase"`,
+		},
+		{
+			name: "invalid regex",
+			config: `
+transformerPipelines:
+  text:
+    - remove: '<embed('
+textPipelines:
+  default: {headline: text, byline: text, body: text}
+`,
+			err: "invalid transformer pipeline text: step 1: invalid remove regex",
+		},
+		{
+			name: "drop without extract",
+			config: `
+transformerPipelines:
+  text:
+    - transformer: tags
+      drop: ["script"]
+textPipelines:
+  default: {headline: text, byline: text, body: text}
+`,
+			err: "invalid transformer pipeline text: step 1: drop is only supported by the extract transformer",
+		},
+		{
+			name: "undeclared pipeline",
+			config: `
+transformerPipelines:
+  text:
+    - transformer: tags
+textPipelines:
+  default: {headline: text, byline: text, body: body}
+`,
+			err: `the body of default uses the undeclared transformer pipeline "body"`,
+		},
+		{
+			name: "missing default",
+			config: `
+transformerPipelines:
+  text:
+    - transformer: tags
+textPipelines:
+  article: {headline: text, byline: text, body: text}
+`,
+			err: "textPipelines does not declare the default text pipelines",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(imageServiceURLConfig + test.config))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+const textPipelinesConfig = `
+transformerPipelines:
+  text:
+    - transformer: tags
+textPipelines:
+  default: {headline: text, byline: text, body: text}
+`
+
+func TestParseConfigFieldRules(t *testing.T) {
+	appConfig, err := parseConfig([]byte(imageServiceURLConfig + textPipelinesConfig + `
+predicates:
+  about: "http://www.ft.com/ontology/annotation/about"
+  isClassifiedBy: "http://www.ft.com/ontology/classification/isClassifiedBy"
+fieldRules:
+  editorialPredicates: [about, isClassifiedBy]
+  articleBrandPredicates: [IsClassifiedBy]
+  tickerAuthorities: ["http://api.ft.com/system/TICKER"]
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, FieldRules{
+		EditorialPredicates:    []string{"http://www.ft.com/ontology/annotation/about", "http://www.ft.com/ontology/classification/isClassifiedBy"},
+		ArticleBrandPredicates: []string{"http://www.ft.com/ontology/classification/isClassifiedBy"},
+		TickerAuthorities:      []string{"http://api.ft.com/system/TICKER"},
+	}, appConfig.FieldRules)
+}
+
+func TestParseConfigRejectsUndeclaredFieldRulePredicates(t *testing.T) {
+	_, err := parseConfig([]byte(imageServiceURLConfig + textPipelinesConfig + `
+predicates:
+  about: "http://www.ft.com/ontology/annotation/about"
+fieldRules:
+  articleBrandPredicates: [isClassifiedBy]
+`))
+	require.Error(t, err)
+	assert.Equal(t, `the articleBrandPredicates field rule uses the undeclared predicate "isClassifiedBy"`, err.Error())
+}
+
+func TestParseEmbeddedConfig(t *testing.T) {
+	appConfig, err := ParseConfig("app.yml")
+	require.NoError(t, err)
+
+	for _, contentType := range []string{ArticleType, VideoType, BlogType, AudioType} {
+		pipelines := appConfig.TextPipelines.Get(contentType)
+		assert.NotNil(t, pipelines.Headline, contentType)
+		assert.NotNil(t, pipelines.Byline, contentType)
+		assert.NotNil(t, pipelines.Body, contentType)
+		assert.NotEmpty(t, appConfig.ESContentTypeMetadataMap.Get(contentType).MediaType, contentType)
+	}
+	assert.NotEmpty(t, appConfig.FieldRules.EditorialPredicates)
+	assert.NotEmpty(t, appConfig.FieldRules.ArticleBrandPredicates)
+}
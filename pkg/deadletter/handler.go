@@ -0,0 +1,154 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+)
+
+const (
+	pathDeadLetters = "/__dead-letters"
+	pathReplay      = "replay"
+)
+
+// Replayer processes a dead-lettered message once more.
+type Replayer interface {
+	Replay(ctx context.Context, msg consumer.Message) error
+}
+
+type ReplayResult struct {
+	ID       string `json:"id"`
+	Replayed bool   `json:"replayed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Handler exposes the dead-lettered messages over HTTP so they can be inspected and replayed.
+type Handler struct {
+	store    Store
+	replayer Replayer
+	log      *logger.UPPLogger
+}
+
+func NewHandler(store Store, replayer Replayer, log *logger.UPPLogger) *Handler {
+	return &Handler{store: store, replayer: replayer, log: log}
+}
+
+func (h *Handler) AttachHTTPEndpoints(serveMux *http.ServeMux) *http.ServeMux {
+	serveMux.HandleFunc(pathDeadLetters, h.list)
+	serveMux.HandleFunc(pathDeadLetters+"/", h.route)
+	return serveMux
+}
+
+// route serves GET /__dead-letters/{id}, POST /__dead-letters/{id}/replay and POST /__dead-letters/replay
+func (h *Handler) route(writer http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, pathDeadLetters), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == pathReplay && req.Method == http.MethodPost:
+		h.replayAll(req.Context(), writer)
+	case len(parts) == 1 && parts[0] != "" && req.Method == http.MethodGet:
+		h.get(writer, parts[0])
+	case len(parts) == 2 && parts[1] == pathReplay && req.Method == http.MethodPost:
+		h.replayOne(req.Context(), writer, parts[0])
+	default:
+		http.NotFound(writer, req)
+	}
+}
+
+func (h *Handler) list(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := h.store.List()
+	if err != nil {
+		h.writeJSON(writer, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+	h.writeJSON(writer, http.StatusOK, entries)
+}
+
+func (h *Handler) get(writer http.ResponseWriter, id string) {
+	entry, err := h.store.Get(id)
+	if err == ErrNotFound {
+		h.writeJSON(writer, http.StatusNotFound, map[string]string{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		h.writeJSON(writer, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+	h.writeJSON(writer, http.StatusOK, entry)
+}
+
+func (h *Handler) replayOne(ctx context.Context, writer http.ResponseWriter, id string) {
+	entry, err := h.store.Get(id)
+	if err == ErrNotFound {
+		h.writeJSON(writer, http.StatusNotFound, map[string]string{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		h.writeJSON(writer, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+
+	result := h.replay(ctx, entry)
+	status := http.StatusOK
+	if !result.Replayed {
+		status = http.StatusInternalServerError
+	} else if err = h.store.Remove(entry.ID); err != nil {
+		h.log.WithTransactionID(entry.TransactionID).WithError(err).
+			Errorf("Dead-lettered message %s was replayed but could not be removed", entry.ID)
+	}
+	h.writeJSON(writer, status, result)
+}
+
+func (h *Handler) replayAll(ctx context.Context, writer http.ResponseWriter) {
+	entries, err := h.store.List()
+	if err != nil {
+		h.writeJSON(writer, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+
+	results := make([]ReplayResult, 0, len(entries))
+	var replayed []string
+	for _, entry := range entries {
+		result := h.replay(ctx, entry)
+		if result.Replayed {
+			replayed = append(replayed, entry.ID)
+		}
+		results = append(results, result)
+	}
+	// the replayed messages are removed at once, the file store is rewritten on every removal
+	if err = h.store.RemoveAll(replayed); err != nil {
+		h.log.WithError(err).Errorf("%d dead-lettered messages were replayed but could not be removed", len(replayed))
+	}
+	h.writeJSON(writer, http.StatusOK, results)
+}
+
+// replay processes the message of the entry again, it is up to the caller to remove the entries that were replayed.
+func (h *Handler) replay(ctx context.Context, entry Entry) ReplayResult {
+	log := h.log.WithTransactionID(entry.TransactionID)
+	if err := h.replayer.Replay(ctx, entry.Message()); err != nil {
+		log.WithError(err).Warnf("Replay of dead-lettered message %s failed", entry.ID)
+		return ReplayResult{ID: entry.ID, Error: err.Error()}
+	}
+	log.Infof("Dead-lettered message %s replayed", entry.ID)
+	return ReplayResult{ID: entry.ID, Replayed: true}
+}
+
+func (h *Handler) writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	response, err := json.Marshal(body)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if _, err = writer.Write(response); err != nil {
+		h.log.WithError(err).Error(err.Error())
+	}
+}
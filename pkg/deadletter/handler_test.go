@@ -0,0 +1,107 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type replayerMock struct {
+	mock.Mock
+}
+
+func (m *replayerMock) Replay(ctx context.Context, msg consumer.Message) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+func newTestServer(t *testing.T, replayer Replayer) (*FileStore, *httptest.Server, func()) {
+	store, cleanup := newTestFileStore(t)
+	log := logger.NewUPPLogger("test", "PANIC")
+	serveMux := NewHandler(store, replayer, log).AttachHTTPEndpoints(http.NewServeMux())
+	server := httptest.NewServer(serveMux)
+	return store, server, func() {
+		server.Close()
+		cleanup()
+	}
+}
+
+func TestHandlerListsDeadLetters(t *testing.T) {
+	store, server, cleanup := newTestServer(t, new(replayerMock))
+	defer cleanup()
+	require.NoError(t, store.Add(testEntry("1")))
+
+	resp, err := http.Get(server.URL + "/__dead-letters")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var entries []Entry
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []Entry{testEntry("1")}, entries)
+}
+
+func TestHandlerGetUnknownDeadLetter(t *testing.T) {
+	_, server, cleanup := newTestServer(t, new(replayerMock))
+	defer cleanup()
+
+	resp, err := http.Get(server.URL + "/__dead-letters/unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandlerReplayRemovesReplayedMessage(t *testing.T) {
+	replayer := new(replayerMock)
+	replayer.On("Replay", testEntry("1").Message()).Return(nil)
+	store, server, cleanup := newTestServer(t, replayer)
+	defer cleanup()
+	require.NoError(t, store.Add(testEntry("1")))
+
+	resp, err := http.Post(server.URL+"/__dead-letters/1/replay", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var result ReplayResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ReplayResult{ID: "1", Replayed: true}, result)
+
+	entries, err := store.List()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	replayer.AssertExpectations(t)
+}
+
+func TestHandlerReplayAllKeepsFailedMessages(t *testing.T) {
+	replayer := new(replayerMock)
+	replayer.On("Replay", testEntry("1").Message()).Return(errors.New("still failing"))
+	replayer.On("Replay", testEntry("2").Message()).Return(nil)
+	store, server, cleanup := newTestServer(t, replayer)
+	defer cleanup()
+	require.NoError(t, store.Add(testEntry("1")))
+	require.NoError(t, store.Add(testEntry("2")))
+
+	resp, err := http.Post(server.URL+"/__dead-letters/replay", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var results []ReplayResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+	assert.Equal(t, []ReplayResult{{ID: "1", Error: "still failing"}, {ID: "2", Replayed: true}}, results)
+
+	entries, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{testEntry("1")}, entries)
+	replayer.AssertExpectations(t)
+}
@@ -0,0 +1,252 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+)
+
+var ErrNotFound = errors.New("dead-lettered message not found")
+
+// Entry is a message that could not be processed, together with the reason it failed.
+type Entry struct {
+	ID            string            `json:"id"`
+	TransactionID string            `json:"transactionId"`
+	Stage         string            `json:"stage"`
+	Error         string            `json:"error"`
+	Time          time.Time         `json:"time"`
+	Headers       map[string]string `json:"headers"`
+	Body          string            `json:"body"`
+}
+
+// Message rebuilds the original queue message.
+func (e Entry) Message() consumer.Message {
+	return consumer.Message{Headers: e.Headers, Body: e.Body}
+}
+
+// Store keeps dead-lettered messages until they are replayed.
+type Store interface {
+	Add(entry Entry) error
+	List() ([]Entry, error)
+	Get(id string) (Entry, error)
+	Remove(id string) error
+	// RemoveAll removes the entries with the given IDs at once, the IDs that are not found are skipped.
+	RemoveAll(ids []string) error
+}
+
+// FileStore keeps dead-lettered messages in a file, one JSON entry per line.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{path: path}, nil
+}
+
+func (s *FileStore) Add(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FileStore) List() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *FileStore) Get(id string) (Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+func (s *FileStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, err := s.remove(map[string]bool{id: true})
+	if err == nil && removed == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *FileStore) RemoveAll(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removing := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removing[id] = true
+	}
+	_, err := s.remove(removing)
+	return err
+}
+
+// remove rewrites the file without the entries of the given IDs and returns how many were removed.
+func (s *FileStore) remove(ids map[string]bool) (int, error) {
+	entries, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	var lines []byte
+	for _, entry := range entries {
+		if ids[entry.ID] {
+			removed++
+			continue
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		lines = append(lines, append(line, '\n')...)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated store behind
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, lines, 0644); err != nil {
+		return 0, err
+	}
+	return removed, os.Rename(tmp, s.path)
+}
+
+func (s *FileStore) read() ([]Entry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	// message bodies can be far bigger than the default token size of the scanner
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// MemoryStore keeps dead-lettered messages until the service stops, it is used when no file is configured.
+// Beyond maxEntries the oldest entries are evicted.
+type MemoryStore struct {
+	mu         sync.Mutex
+	entries    []Entry
+	maxEntries int
+}
+
+// NewMemoryStore keeps up to maxEntries entries, 0 keeps them all.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{entries: []Entry{}, maxEntries: maxEntries}
+}
+
+func (s *MemoryStore) Add(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	if s.maxEntries > 0 && len(s.entries) > s.maxEntries {
+		evicted := len(s.entries) - s.maxEntries
+		s.entries = append(s.entries[:0], s.entries[evicted:]...)
+		metrics.DeadLettersEvicted(evicted)
+	}
+	return nil
+}
+
+func (s *MemoryStore) List() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Entry{}, s.entries...), nil
+}
+
+func (s *MemoryStore) Get(id string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+func (s *MemoryStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, entry := range s.entries {
+		if entry.ID == id {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) RemoveAll(ids []string) error {
+	removing := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removing[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.entries[:0]
+	for _, entry := range s.entries {
+		if !removing[entry.ID] {
+			kept = append(kept, entry)
+		}
+	}
+	s.entries = kept
+	return nil
+}
@@ -0,0 +1,125 @@
+package deadletter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileStore(t *testing.T) (*FileStore, func()) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	store, err := NewFileStore(filepath.Join(dir, "nested", "dead-letters.jsonl"))
+	require.NoError(t, err)
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func testEntry(id string) Entry {
+	return Entry{
+		ID:            id,
+		TransactionID: "tid_" + id,
+		Stage:         "write",
+		Error:         "elastic: timeout",
+		Time:          time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+		Headers:       map[string]string{"X-Request-Id": "tid_" + id},
+		Body:          `{"uuid":"` + id + `"}`,
+	}
+}
+
+func TestFileStoreListEmpty(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	entries, err := store.List()
+
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestFileStoreAddGetRemove(t *testing.T) {
+	expect := assert.New(t)
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	require.NoError(t, store.Add(testEntry("1")))
+	require.NoError(t, store.Add(testEntry("2")))
+	require.NoError(t, store.Add(testEntry("3")))
+
+	entries, err := store.List()
+	expect.NoError(err)
+	expect.Equal([]Entry{testEntry("1"), testEntry("2"), testEntry("3")}, entries)
+
+	entry, err := store.Get("2")
+	expect.NoError(err)
+	expect.Equal(testEntry("2"), entry)
+
+	expect.NoError(store.Remove("2"))
+	entries, err = store.List()
+	expect.NoError(err)
+	expect.Equal([]Entry{testEntry("1"), testEntry("3")}, entries)
+
+	_, err = store.Get("2")
+	expect.Equal(ErrNotFound, err)
+	expect.Equal(ErrNotFound, store.Remove("2"))
+}
+
+func TestFileStoreRemoveAll(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	require.NoError(t, store.Add(testEntry("1")))
+	require.NoError(t, store.Add(testEntry("2")))
+	require.NoError(t, store.Add(testEntry("3")))
+
+	assert.NoError(t, store.RemoveAll([]string{"1", "3", "unknown"}))
+
+	entries, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{testEntry("2")}, entries)
+	assert.NoError(t, store.RemoveAll(nil))
+}
+
+func TestMemoryStoreAddGetRemove(t *testing.T) {
+	expect := assert.New(t)
+	store := NewMemoryStore(0)
+
+	entries, err := store.List()
+	expect.NoError(err)
+	expect.Empty(entries)
+
+	require.NoError(t, store.Add(testEntry("1")))
+	require.NoError(t, store.Add(testEntry("2")))
+
+	entry, err := store.Get("2")
+	expect.NoError(err)
+	expect.Equal(testEntry("2"), entry)
+
+	expect.NoError(store.Remove("1"))
+	entries, err = store.List()
+	expect.NoError(err)
+	expect.Equal([]Entry{testEntry("2")}, entries)
+	expect.Equal(ErrNotFound, store.Remove("1"))
+	_, err = store.Get("1")
+	expect.Equal(ErrNotFound, err)
+}
+
+func TestMemoryStoreEvictsTheOldestEntries(t *testing.T) {
+	store := NewMemoryStore(2)
+
+	require.NoError(t, store.Add(testEntry("1")))
+	require.NoError(t, store.Add(testEntry("2")))
+	require.NoError(t, store.Add(testEntry("3")))
+
+	entries, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{testEntry("2"), testEntry("3")}, entries)
+
+	assert.NoError(t, store.RemoveAll([]string{"2", "unknown"}))
+	entries, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{testEntry("3")}, entries)
+}
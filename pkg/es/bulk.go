@@ -0,0 +1,266 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/retry"
+
+	"gopkg.in/olivere/elastic.v2"
+)
+
+var errBulkNotStarted = errors.New("bulk processor is not started, Elasticsearch client has not been set")
+
+// BulkConfig controls when buffered operations are flushed to the _bulk API.
+type BulkConfig struct {
+	Workers       int
+	BulkActions   int
+	BulkSize      int
+	FlushInterval time.Duration
+}
+
+type bulkResult struct {
+	item *elastic.BulkResponseItem
+	err  error
+}
+
+type bulkOperation struct {
+	req    elastic.BulkableRequest
+	result chan bulkResult
+}
+
+// BulkService buffers index and delete operations and sends them to Elasticsearch in batches.
+// WriteData and DeleteData block until the batch holding the operation has been committed,
+// so every caller still gets the outcome of its own document. A batch therefore only fills up
+// with the operations of concurrent callers, with a single message worker every batch holds one document.
+type BulkService struct {
+	*ElasticsearchService
+	config BulkConfig
+
+	batcherMu sync.RWMutex
+	batcher   *batcher
+}
+
+func NewBulkService(indexName string, config BulkConfig, retrier *retry.Retrier) *BulkService {
+	return &BulkService{
+		ElasticsearchService: &ElasticsearchService{IndexName: indexName, retrier: retrier},
+		config:               config,
+	}
+}
+
+func (s *BulkService) SetClient(client Client) {
+	s.batcherMu.Lock()
+	defer s.batcherMu.Unlock()
+
+	// committing the operations buffered against the previous client unblocks their callers
+	s.closeBatcher()
+	s.ElasticsearchService.SetClient(client)
+	if client == nil {
+		return
+	}
+	s.batcher = startBatcher(client, s.config)
+}
+
+func (s *BulkService) WriteData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	req := elastic.NewBulkIndexRequest().
+		Index(s.IndexName).
+		Type(conceptType).
+		Id(uuid).
+		Doc(payload)
+	if version > 0 {
+		req = req.Version(version).VersionType(externalVersionType)
+	}
+	item, err := s.addWithRetry(ctx, writeOperation, metrics.OperationWrite, req)
+	if err != nil {
+		return nil, staleVersionError(err)
+	}
+	return &elastic.IndexResult{
+		Index:   item.Index,
+		Type:    item.Type,
+		Id:      item.Id,
+		Version: item.Version,
+		Created: item.Status == http.StatusCreated,
+	}, nil
+}
+
+func (s *BulkService) DeleteData(ctx context.Context, conceptType string, uuid string, version int64) (*elastic.DeleteResult, error) {
+	req := elastic.NewBulkDeleteRequest().
+		Index(s.IndexName).
+		Type(conceptType).
+		Id(uuid)
+	if version > 0 {
+		req = req.Version(version).VersionType(externalVersionType)
+	}
+	item, err := s.addWithRetry(ctx, deleteOperation, metrics.OperationDelete, req)
+	if err != nil {
+		return nil, staleVersionError(err)
+	}
+	return &elastic.DeleteResult{
+		Found:   item.Found,
+		Index:   item.Index,
+		Type:    item.Type,
+		Id:      item.Id,
+		Version: int64(item.Version),
+	}, nil
+}
+
+// Close commits all buffered operations and stops the workers.
+func (s *BulkService) Close() error {
+	s.batcherMu.Lock()
+	defer s.batcherMu.Unlock()
+	s.closeBatcher()
+	return nil
+}
+
+func (s *BulkService) closeBatcher() {
+	if s.batcher == nil {
+		return
+	}
+	s.batcher.close()
+	s.batcher = nil
+}
+
+// addWithRetry retries the operations that were rejected or whose batch failed, e.g. because the bulk queue of
+// the cluster was full. A failed batch is not sent again, each of its operations is retried on its own.
+func (s *BulkService) addWithRetry(ctx context.Context, operation string, metricsOperation string, req elastic.BulkableRequest) (*elastic.BulkResponseItem, error) {
+	var item *elastic.BulkResponseItem
+	err := s.retrier.Do(ctx, operation, func() (err error) {
+		defer metrics.ObserveElasticsearch(metricsOperation, time.Now())
+		item, err = s.add(ctx, req)
+		return err
+	}, IsRetryable)
+	return item, err
+}
+
+// add stops waiting for the batcher to take the operation or for its commit once ctx is done, an operation taken over
+// stays in the batch though. An operation handed over while the client is replaced goes to the batcher of the new client.
+func (s *BulkService) add(ctx context.Context, req elastic.BulkableRequest) (*elastic.BulkResponseItem, error) {
+	op := bulkOperation{req: req, result: make(chan bulkResult, 1)}
+	for {
+		s.batcherMu.RLock()
+		b := s.batcher
+		s.batcherMu.RUnlock()
+		if b == nil {
+			return nil, errBulkNotStarted
+		}
+
+		select {
+		case b.operations <- op:
+			select {
+			case r := <-op.result:
+				return r.item, r.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		case <-b.closing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// batcher commits the operations it receives in batches, one per worker. Every batch is sent with a bulk request
+// of its own, so that nothing is left queued when the commit fails.
+type batcher struct {
+	client     Client
+	config     BulkConfig
+	operations chan bulkOperation
+	// closing stops the workers, operations is never closed so that a late sender cannot panic
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+func startBatcher(client Client, config BulkConfig) *batcher {
+	b := &batcher{client: client, config: config, operations: make(chan bulkOperation), closing: make(chan struct{})}
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	b.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go b.work()
+	}
+	return b
+}
+
+// close commits the buffered operations and waits for the workers to stop.
+func (b *batcher) close() {
+	close(b.closing)
+	b.wg.Wait()
+}
+
+func (b *batcher) work() {
+	defer b.wg.Done()
+
+	var flush <-chan time.Time
+	if b.config.FlushInterval > 0 {
+		ticker := time.NewTicker(b.config.FlushInterval)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+
+	bulk := b.client.Bulk()
+	var batch []bulkOperation
+	commit := func() {
+		if len(batch) > 0 {
+			b.commit(bulk, batch)
+		}
+		bulk = b.client.Bulk()
+		batch = nil
+	}
+	for {
+		select {
+		case <-b.closing:
+			commit()
+			return
+		case op := <-b.operations:
+			bulk.Add(op.req)
+			batch = append(batch, op)
+			if b.commitRequired(bulk) {
+				commit()
+			}
+		case <-flush:
+			commit()
+		}
+	}
+}
+
+func (b *batcher) commitRequired(bulk *elastic.BulkService) bool {
+	if b.config.BulkActions >= 0 && bulk.NumberOfActions() >= b.config.BulkActions {
+		return true
+	}
+	return b.config.BulkSize >= 0 && bulk.EstimatedSizeInBytes() >= int64(b.config.BulkSize)
+}
+
+func (b *batcher) commit(bulk *elastic.BulkService, batch []bulkOperation) {
+	response, err := bulk.Do()
+	for i, op := range batch {
+		if err != nil {
+			op.result <- bulkResult{err: err}
+			continue
+		}
+		item, itemErr := responseItem(response, i)
+		op.result <- bulkResult{item: item, err: itemErr}
+	}
+}
+
+// responseItem returns the outcome of the i-th operation of a bulk commit.
+// Elasticsearch answers the items in the same order the operations were sent.
+func responseItem(response *elastic.BulkResponse, i int) (*elastic.BulkResponseItem, error) {
+	if response == nil || i >= len(response.Items) {
+		return nil, errors.New("bulk response is missing the result of the operation")
+	}
+	for action, item := range response.Items[i] {
+		// a delete of a missing document is reported with 404 and is not a failure
+		notFoundDelete := action == "delete" && item.Status == http.StatusNotFound
+		if item.Error != "" || (item.Status >= 300 && !notFoundDelete) {
+			return nil, &elastic.Error{Status: item.Status, Message: item.Error}
+		}
+		return item, nil
+	}
+	return nil, errors.New("bulk response item is empty")
+}
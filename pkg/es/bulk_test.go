@@ -0,0 +1,271 @@
+package es
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v2"
+)
+
+const (
+	failingUUID  = "f0000000-0000-0000-0000-000000000000"
+	notFoundUUID = "f1000000-0000-0000-0000-000000000000"
+)
+
+type bulkStandIn struct {
+	commits int32
+	actions int32
+	// failedCommits are answered with 503 before the others are processed
+	failedCommits int32
+	// lastActions is the number of operations of the last commit
+	lastActions int32
+}
+
+// start runs a minimal Elasticsearch _bulk endpoint. Index operations on failingUUID are rejected,
+// the ones on notFoundUUID are answered with 404 and deletes are answered as if the document did not exist.
+func (b *bulkStandIn) start(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		if atomic.AddInt32(&b.commits, 1) <= atomic.LoadInt32(&b.failedCommits) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var actions int32
+
+		var items []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &action))
+			atomic.AddInt32(&b.actions, 1)
+			actions++
+			for name, meta := range action {
+				item := map[string]interface{}{"_index": meta["_index"], "_type": meta["_type"], "_id": meta["_id"], "_version": 1}
+				switch {
+				case name == "delete":
+					item["status"] = http.StatusNotFound
+					item["found"] = false
+				case meta["_id"] == failingUUID:
+					item["status"] = http.StatusBadRequest
+					item["error"] = "MapperParsingException[failed to parse]"
+					scanner.Scan()
+				case meta["_id"] == notFoundUUID:
+					item["status"] = http.StatusNotFound
+					scanner.Scan()
+				default:
+					item["status"] = http.StatusCreated
+					scanner.Scan()
+				}
+				items = append(items, map[string]interface{}{name: item})
+			}
+		}
+		atomic.StoreInt32(&b.lastActions, actions)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "items": items})
+	}))
+}
+
+func newTestClient(t testing.TB, url string) Client {
+	client, err := elastic.NewClient(elastic.SetURL(url), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	require.NoError(t, err)
+	return client
+}
+
+func TestBulkServiceBatchesWrites(t *testing.T) {
+	standIn := &bulkStandIn{}
+	server := standIn.start(t)
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 3, BulkSize: -1, FlushInterval: time.Minute}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+	defer service.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(uuid string) {
+			defer wg.Done()
+			res, err := service.WriteData(context.Background(), "FTCom", uuid, map[string]string{"uid": uuid}, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, uuid, res.Id)
+			assert.Equal(t, "FTCom", res.Type)
+			assert.True(t, res.Created)
+		}(fmt.Sprintf("a0000000-0000-0000-0000-00000000000%d", i))
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&standIn.commits))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&standIn.actions))
+}
+
+func TestBulkServiceReportsItemFailures(t *testing.T) {
+	standIn := &bulkStandIn{}
+	server := standIn.start(t)
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 2, BulkSize: -1, FlushInterval: time.Minute}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+	defer service.Close()
+
+	var wg sync.WaitGroup
+	var failErr, okErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, failErr = service.WriteData(context.Background(), "FTCom", failingUUID, map[string]string{}, 0)
+	}()
+	go func() {
+		defer wg.Done()
+		_, okErr = service.WriteData(context.Background(), "FTCom", "b0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+	}()
+	wg.Wait()
+
+	assert.NoError(t, okErr)
+	require.Error(t, failErr)
+	esErr, ok := failErr.(*elastic.Error)
+	require.True(t, ok, "expected an elastic.Error")
+	assert.Equal(t, http.StatusBadRequest, esErr.Status)
+	assert.Contains(t, esErr.Message, "MapperParsingException")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&standIn.commits))
+}
+
+func TestBulkServiceFlushesOnInterval(t *testing.T) {
+	standIn := &bulkStandIn{}
+	server := standIn.start(t)
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 100, BulkSize: -1, FlushInterval: 50 * time.Millisecond}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+	defer service.Close()
+
+	res, err := service.DeleteData(context.Background(), "FTCom", "c0000000-0000-0000-0000-000000000000", 0)
+
+	assert.NoError(t, err)
+	assert.False(t, res.Found)
+	assert.Equal(t, "c0000000-0000-0000-0000-000000000000", res.Id)
+}
+
+func TestBulkServiceCloseCommitsPendingOperations(t *testing.T) {
+	standIn := &bulkStandIn{}
+	server := standIn.start(t)
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 100, BulkSize: -1, FlushInterval: time.Minute}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+
+	done := make(chan error)
+	go func() {
+		_, err := service.WriteData(context.Background(), "FTCom", "d0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, service.Close())
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&standIn.commits))
+}
+
+func TestBulkServiceWithoutClient(t *testing.T) {
+	service := NewBulkService("ft", BulkConfig{}, nil)
+
+	_, err := service.WriteData(context.Background(), "FTCom", "e0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+
+	assert.Equal(t, errBulkNotStarted, err)
+}
+
+func TestBulkServiceStopsWaitingWhenContextIsDone(t *testing.T) {
+	standIn := &bulkStandIn{}
+	server := standIn.start(t)
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 100, BulkSize: -1, FlushInterval: time.Minute}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := service.WriteData(ctx, "FTCom", "d0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// the operation stays in the batch and is still committed
+	assert.NoError(t, service.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&standIn.commits))
+}
+
+func TestBulkServiceStopsWaitingForAStalledBatcher(t *testing.T) {
+	standIn := &bulkStandIn{}
+	stalled := standIn.start(t)
+	defer stalled.Close()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		stalled.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 1, BulkSize: -1, FlushInterval: time.Minute}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+
+	// the only worker waits for the commit of the first write and takes no other operation meanwhile
+	first := make(chan error)
+	go func() {
+		_, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+		first <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := service.WriteData(ctx, "FTCom", "b0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	assert.NoError(t, <-first)
+	assert.NoError(t, service.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&standIn.actions), "the operation that was not taken over was committed")
+}
+
+func TestBulkServiceDoesNotResendFailedCommits(t *testing.T) {
+	standIn := &bulkStandIn{failedCommits: 1}
+	server := standIn.start(t)
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 1, BulkSize: -1, FlushInterval: time.Minute}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+	defer service.Close()
+
+	_, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+	require.Error(t, err)
+
+	res, err := service.WriteData(context.Background(), "FTCom", "b0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "b0000000-0000-0000-0000-000000000000", res.Id)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&standIn.commits))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&standIn.lastActions), "the operation of the failed commit was sent again")
+}
+
+func TestBulkServiceReportsIndexNotFound(t *testing.T) {
+	standIn := &bulkStandIn{}
+	server := standIn.start(t)
+	defer server.Close()
+
+	service := NewBulkService("ft", BulkConfig{Workers: 1, BulkActions: 1, BulkSize: -1, FlushInterval: time.Minute}, nil)
+	service.SetClient(newTestClient(t, server.URL))
+	defer service.Close()
+
+	_, err := service.WriteData(context.Background(), "FTCom", notFoundUUID, map[string]string{}, 0)
+
+	require.Error(t, err)
+	esErr, ok := err.(*elastic.Error)
+	require.True(t, ok, "expected an elastic.Error")
+	assert.Equal(t, http.StatusNotFound, esErr.Status)
+}
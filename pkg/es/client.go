@@ -2,6 +2,7 @@ package es
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/Financial-Times/go-logger/v2"
 	awsauth "github.com/smartystreets/go-aws-auth"
@@ -14,6 +15,8 @@ type Client interface {
 	Get() *elastic.GetService
 	Delete() *elastic.DeleteService
 	IndexGet() *elastic.IndicesGetService
+	Bulk() *elastic.BulkService
+	PerformRequest(method, path string, params url.Values, body interface{}, ignoreErrors ...int) (*elastic.Response, error)
 }
 
 type AccessConfig struct {
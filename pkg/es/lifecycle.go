@@ -0,0 +1,86 @@
+package es
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
+	"gopkg.in/olivere/elastic.v2"
+)
+
+// VersionedIndexName names an index behind the alias, e.g. ft-20200501120000.
+// Readers and writers only use the alias, so the index can be replaced by a new version with a different mapping.
+func VersionedIndexName(alias string, version string) string {
+	return alias + "-" + version
+}
+
+// ReferenceSchema returns the settings and mappings of the embedded reference schema, translated for typeless clusters when needed.
+func ReferenceSchema(typeless bool) (interface{}, error) {
+	if typeless {
+		reference, err := TypelessReferenceIndex()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"settings": reference.Settings, "mappings": reference.Mappings}, nil
+	}
+	referenceJSON, err := config.ReadEmbeddedResource("referenceSchema.json")
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(referenceJSON), nil
+}
+
+// CreateIndex creates the index with the embedded reference schema.
+func CreateIndex(client Client, index string, typeless bool) error {
+	schema, err := ReferenceSchema(typeless)
+	if err != nil {
+		return err
+	}
+	return performRequest(client, http.MethodPut, "/"+url.PathEscape(index), nil, schema, nil)
+}
+
+// AliasedIndices returns the indices the alias points at, sorted by name.
+func AliasedIndices(client Client, alias string) ([]string, error) {
+	var aliases map[string]interface{}
+	err := performRequest(client, http.MethodGet, "/_alias/"+url.PathEscape(alias), nil, nil, &aliases)
+	if esErr, ok := err.(*elastic.Error); ok && esErr.Status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	indices := make([]string, 0, len(aliases))
+	for index := range aliases {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// SwapAlias points the alias at the index and removes it from the indices it pointed at before.
+// Both happen in a single request, so readers and writers never see the alias missing or pointing at two indices.
+// It returns the indices the alias pointed at before.
+func SwapAlias(client Client, alias string, index string) ([]string, error) {
+	previous, err := AliasedIndices(client, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]map[string]interface{}, 0, len(previous)+1)
+	for _, p := range previous {
+		if p == index {
+			continue
+		}
+		actions = append(actions, map[string]interface{}{"remove": map[string]string{"index": p, "alias": alias}})
+	}
+	actions = append(actions, map[string]interface{}{"add": map[string]string{"index": index, "alias": alias}})
+
+	err = performRequest(client, http.MethodPost, "/_aliases", nil, map[string]interface{}{"actions": actions}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
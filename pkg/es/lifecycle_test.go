@@ -0,0 +1,138 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aliasStandIn answers like a cluster for index creation and alias requests.
+type aliasStandIn struct {
+	mu      sync.Mutex
+	indices map[string]map[string]interface{}
+	aliases map[string][]string
+}
+
+func newAliasStandIn(t *testing.T) (*aliasStandIn, *httptest.Server) {
+	s := &aliasStandIn{indices: make(map[string]map[string]interface{}), aliases: make(map[string][]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == http.MethodPut:
+			index := strings.TrimPrefix(r.URL.Path, "/")
+			if _, found := s.indices[index]; found {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprintf(w, `{"error":{"type":"resource_already_exists_exception","reason":"index [%s] already exists"},"status":400}`, index)
+				return
+			}
+			var schema map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&schema))
+			s.indices[index] = schema
+			_, _ = fmt.Fprintf(w, `{"acknowledged":true,"index":"%s"}`, index)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/_alias/"):
+			alias := strings.TrimPrefix(r.URL.Path, "/_alias/")
+			if len(s.aliases[alias]) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = fmt.Fprintf(w, `{"error":"alias [%s] missing","status":404}`, alias)
+				return
+			}
+			res := make(map[string]interface{})
+			for _, index := range s.aliases[alias] {
+				res[index] = map[string]interface{}{"aliases": map[string]interface{}{alias: map[string]interface{}{}}}
+			}
+			_ = json.NewEncoder(w).Encode(res)
+		case r.Method == http.MethodPost && r.URL.Path == "/_aliases":
+			var req struct {
+				Actions []map[string]map[string]string `json:"actions"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			for _, action := range req.Actions {
+				if remove, ok := action["remove"]; ok {
+					s.aliases[remove["alias"]] = without(s.aliases[remove["alias"]], remove["index"])
+				}
+				if add, ok := action["add"]; ok {
+					s.aliases[add["alias"]] = append(without(s.aliases[add["alias"]], add["index"]), add["index"])
+				}
+			}
+			_, _ = fmt.Fprint(w, `{"acknowledged":true}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return s, server
+}
+
+func without(indices []string, index string) []string {
+	var res []string
+	for _, i := range indices {
+		if i != index {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+func TestVersionedIndexName(t *testing.T) {
+	assert.Equal(t, "ft-20200501120000", VersionedIndexName("ft", "20200501120000"))
+}
+
+func TestCreateIndex(t *testing.T) {
+	standIn, server := newAliasStandIn(t)
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+
+	require.NoError(t, CreateIndex(client, "ft-v1", false))
+	assert.Contains(t, standIn.indices["ft-v1"]["mappings"], "FTCom")
+
+	require.NoError(t, CreateIndex(client, "ft-v2", true))
+	assert.Contains(t, standIn.indices["ft-v2"]["mappings"], "properties")
+
+	err := CreateIndex(client, "ft-v1", false)
+	assert.EqualError(t, err, "elastic: Error 400 (Bad Request): resource_already_exists_exception[index [ft-v1] already exists]")
+}
+
+func TestSwapAlias(t *testing.T) {
+	standIn, server := newAliasStandIn(t)
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+
+	indices, err := AliasedIndices(client, "ft")
+	require.NoError(t, err)
+	assert.Empty(t, indices)
+
+	previous, err := SwapAlias(client, "ft", "ft-v1")
+	require.NoError(t, err)
+	assert.Empty(t, previous)
+
+	standIn.aliases["ft"] = append(standIn.aliases["ft"], "ft-v0")
+	previous, err = SwapAlias(client, "ft", "ft-v2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ft-v0", "ft-v1"}, previous)
+
+	indices, err = AliasedIndices(client, "ft")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ft-v2"}, indices)
+}
+
+func TestSwapAliasToCurrentIndex(t *testing.T) {
+	standIn, server := newAliasStandIn(t)
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+	standIn.aliases["ft"] = []string{"ft-v1"}
+
+	previous, err := SwapAlias(client, "ft", "ft-v1")
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ft-v1"}, previous)
+	assert.Equal(t, []string{"ft-v1"}, standIn.aliases["ft"])
+}
@@ -0,0 +1,61 @@
+package es
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"gopkg.in/olivere/elastic.v2"
+)
+
+// lookupFailureField is set on the documents indexed while some of their concordances couldn't be looked up.
+const lookupFailureField = "lookupFailure"
+
+type searchResult struct {
+	Hits struct {
+		Hits []struct {
+			ID string `json:"_id"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func (s *ElasticsearchService) LookupFailures(ctx context.Context, size int) ([]string, error) {
+	client := s.GetClient()
+	if client == nil {
+		return nil, elastic.ErrNoClient
+	}
+	return lookupFailures(ctx, client, s.IndexName, size)
+}
+
+func (s *TypelessService) LookupFailures(ctx context.Context, size int) ([]string, error) {
+	s.mu.RLock()
+	client := s.ElasticClient
+	s.mu.RUnlock()
+	if client == nil {
+		return nil, elastic.ErrNoClient
+	}
+	return lookupFailures(ctx, client, s.IndexName, size)
+}
+
+// lookupFailures searches the UUIDs of up to size documents flagged with lookupFailure, the query is the same for every cluster version.
+func lookupFailures(ctx context.Context, client Client, index string, size int) ([]string, error) {
+	query := map[string]interface{}{
+		"query":   map[string]interface{}{"term": map[string]interface{}{lookupFailureField: true}},
+		"_source": false,
+		"size":    size,
+	}
+	res, err := withContext(ctx, func() (interface{}, error) {
+		result := new(searchResult)
+		return result, performRequest(client, http.MethodPost, "/"+url.PathEscape(index)+"/_search", nil, query, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := res.(*searchResult)
+
+	uuids := make([]string, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		uuids = append(uuids, hit.ID)
+	}
+	return uuids, nil
+}
@@ -0,0 +1,161 @@
+package es
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxSummaryPaths is the number of paths listed per section by SchemaDiff.String, the others are only counted.
+const maxSummaryPaths = 5
+
+// SchemaDiff is the difference between the live index and the reference schema.
+type SchemaDiff struct {
+	Index    string          `json:"index"`
+	Settings Diff            `json:"settings"`
+	Mappings map[string]Diff `json:"mappings"`
+}
+
+// Diff lists the dotted paths that differ. Added paths are only found in the live index,
+// removed ones only in the reference schema.
+type Diff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []Change `json:"changed,omitempty"`
+}
+
+// Change is a path found on both sides with different values.
+type Change struct {
+	Path     string      `json:"path"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual"`
+}
+
+// ignoredSettings are set by the cluster when the index is created.
+var ignoredSettings = []string{"creation_date", "uuid", "version", "created", "provided_name"}
+
+// diffSchema compares the live settings and mappings with the reference ones.
+// The mappings are compared per mapping type, the paths of a type start with its name.
+func diffSchema(index string, referenceSettings, referenceMappings, settings, mappings map[string]interface{}) *SchemaDiff {
+	if indexSettings, ok := settings["index"].(map[string]interface{}); ok {
+		for _, setting := range ignoredSettings {
+			delete(indexSettings, setting)
+		}
+	}
+
+	diff := &SchemaDiff{Index: index, Mappings: make(map[string]Diff)}
+	diffValues("", referenceSettings, settings, &diff.Settings)
+	for _, mappingType := range sortedKeys(referenceMappings, mappings) {
+		var typeDiff Diff
+		diffValues("", map[string]interface{}{mappingType: referenceMappings[mappingType]}, map[string]interface{}{mappingType: mappings[mappingType]}, &typeDiff)
+		if !typeDiff.empty() {
+			diff.Mappings[mappingType] = typeDiff
+		}
+	}
+	return diff
+}
+
+func diffValues(path string, expected interface{}, actual interface{}, diff *Diff) {
+	expectedMap, expectedIsMap := expected.(map[string]interface{})
+	actualMap, actualIsMap := actual.(map[string]interface{})
+	if !expectedIsMap || !actualIsMap {
+		switch {
+		case expected == nil && actual != nil:
+			diff.Added = append(diff.Added, path)
+		case expected != nil && actual == nil:
+			diff.Removed = append(diff.Removed, path)
+		case !reflect.DeepEqual(expected, actual):
+			diff.Changed = append(diff.Changed, Change{Path: path, Expected: expected, Actual: actual})
+		}
+		return
+	}
+
+	for _, key := range sortedKeys(expectedMap, actualMap) {
+		diffValues(joinPath(path, key), expectedMap[key], actualMap[key], diff)
+	}
+}
+
+func sortedKeys(maps ...map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (d Diff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Empty tells whether the live index matches the reference schema.
+func (d *SchemaDiff) Empty() bool {
+	return d.Settings.empty() && len(d.Mappings) == 0
+}
+
+// String summarises the diff for the health check, e.g.
+// "wrong mappings: changed FTCom.properties.byline.type, added FTCom.properties.topic".
+func (d *SchemaDiff) String() string {
+	var sections []string
+	if !d.Settings.empty() {
+		sections = append(sections, "wrong settings: "+d.Settings.summary())
+	}
+	if len(d.Mappings) > 0 {
+		var merged Diff
+		for _, mappingType := range sortedDiffKeys(d.Mappings) {
+			merged.Added = append(merged.Added, d.Mappings[mappingType].Added...)
+			merged.Removed = append(merged.Removed, d.Mappings[mappingType].Removed...)
+			merged.Changed = append(merged.Changed, d.Mappings[mappingType].Changed...)
+		}
+		sections = append(sections, "wrong mappings: "+merged.summary())
+	}
+	return strings.Join(sections, "; ")
+}
+
+func (d Diff) summary() string {
+	paths := make([]string, 0, maxSummaryPaths)
+	total := len(d.Changed) + len(d.Removed) + len(d.Added)
+	add := func(kind string, path string) {
+		if len(paths) < maxSummaryPaths {
+			paths = append(paths, kind+" "+path)
+		}
+	}
+	for _, change := range d.Changed {
+		add("changed", change.Path)
+	}
+	for _, path := range d.Removed {
+		add("removed", path)
+	}
+	for _, path := range d.Added {
+		add("added", path)
+	}
+
+	summary := strings.Join(paths, ", ")
+	if total > len(paths) {
+		summary += fmt.Sprintf(" and %d more", total-len(paths))
+	}
+	return summary
+}
+
+func sortedDiffKeys(diffs map[string]Diff) []string {
+	keys := make([]string, 0, len(diffs))
+	for key := range diffs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package es
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSchema(t *testing.T) {
+	referenceSettings := map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "5", "number_of_replicas": "1"}}
+	referenceMappings := map[string]interface{}{
+		"FTCom": map[string]interface{}{"properties": map[string]interface{}{
+			"byline": map[string]interface{}{"type": "string", "index": "not_analyzed"},
+			"body":   map[string]interface{}{"type": "string"},
+		}},
+		"FTBlogs": map[string]interface{}{"properties": map[string]interface{}{}},
+	}
+	settings := map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "3", "number_of_replicas": "1", "creation_date": "1588235411711", "uuid": "nuZqPWCRRXONe0Cmz9xB2g"}}
+	mappings := map[string]interface{}{
+		"FTCom": map[string]interface{}{"properties": map[string]interface{}{
+			"byline": map[string]interface{}{"type": "string"},
+			"topic":  map[string]interface{}{"type": "string"},
+		}},
+		"FTBlogs": map[string]interface{}{"properties": map[string]interface{}{}},
+		"FTAPI":   map[string]interface{}{},
+	}
+
+	diff := diffSchema("ft-v1", referenceSettings, referenceMappings, settings, mappings)
+
+	assert.Equal(t, "ft-v1", diff.Index)
+	assert.Equal(t, Diff{Changed: []Change{{Path: "index.number_of_shards", Expected: "5", Actual: "3"}}}, diff.Settings)
+	assert.Equal(t, map[string]Diff{
+		"FTAPI": {Added: []string{"FTAPI"}},
+		"FTCom": {
+			Added:   []string{"FTCom.properties.topic"},
+			Removed: []string{"FTCom.properties.body", "FTCom.properties.byline.index"},
+		},
+	}, diff.Mappings)
+	assert.False(t, diff.Empty())
+	assert.Equal(t, "wrong settings: changed index.number_of_shards; "+
+		"wrong mappings: removed FTCom.properties.body, removed FTCom.properties.byline.index, added FTAPI, added FTCom.properties.topic", diff.String())
+	assert.Equal(t, "not ok, "+diff.String(), schemaHealth(diff))
+}
+
+func TestDiffSchemaWithoutDifferences(t *testing.T) {
+	settings := map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "5"}}
+	mappings := map[string]interface{}{"FTCom": map[string]interface{}{"properties": map[string]interface{}{"body": map[string]interface{}{"type": "string"}}}}
+
+	diff := diffSchema("ft-v1", settings, mappings,
+		map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "5", "version": map[string]interface{}{"created": "1050299"}}}, mappings)
+
+	assert.True(t, diff.Empty())
+	assert.Empty(t, diff.String())
+	assert.Equal(t, "ok", schemaHealth(diff))
+}
+
+func TestSchemaDiffSummaryIsShortened(t *testing.T) {
+	diff := &SchemaDiff{Mappings: map[string]Diff{"FTCom": {Added: []string{"a", "b", "c", "d", "e", "f", "g"}}}}
+
+	assert.Equal(t, "wrong mappings: added a, added b, added c, added d, added e and 2 more", diff.String())
+}
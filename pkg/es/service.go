@@ -1,79 +1,127 @@
 package es
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/retry"
 
 	"gopkg.in/olivere/elastic.v2"
 )
 
+const (
+	writeOperation  = "Elasticsearch write"
+	deleteOperation = "Elasticsearch delete"
+
+	// external_gte lets a replay of the very same event (e.g. from the reindexer) through while older events are rejected
+	externalVersionType = "external_gte"
+)
+
+// ErrStaleVersion is returned when the document is already indexed with a newer version than the one being written or deleted.
+var ErrStaleVersion = errors.New("skipped: stale")
+
 var referenceIndex *elasticIndex
 
 type elasticIndex struct {
 	index map[string]*elastic.IndicesGetResponse
 }
 
+// ElasticsearchService writes and deletes concurrently, the lock only guards swapping the client.
 type ElasticsearchService struct {
 	mu            sync.RWMutex
 	ElasticClient Client
 	IndexName     string
+	retrier       *retry.Retrier
 }
 
 type Service interface {
 	HealthStatus
 	SetClient(client Client)
-	WriteData(conceptType string, uuid string, payload interface{}) (*elastic.IndexResult, error)
-	DeleteData(conceptType string, uuid string) (*elastic.DeleteResult, error)
+	// WriteData and DeleteData use version as an external document version when it is greater than zero.
+	// They return the error of ctx once it is done, the request may still be applied by the cluster.
+	WriteData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error)
+	DeleteData(ctx context.Context, conceptType string, uuid string, version int64) (*elastic.DeleteResult, error)
+	// LookupFailures returns the UUIDs of up to size documents indexed without all of their concordances
+	LookupFailures(ctx context.Context, size int) ([]string, error)
 }
 
 type HealthStatus interface {
 	GetClusterHealth() (*elastic.ClusterHealthResponse, error)
 	GetSchemaHealth() (string, error)
+	// GetSchemaDiff compares the index with the reference schema, it fails when they can't be compared
+	GetSchemaDiff() (*SchemaDiff, error)
 }
 
-func NewService(indexName string) Service {
-	return &ElasticsearchService{IndexName: indexName}
+func NewService(indexName string, retrier *retry.Retrier) Service {
+	return &ElasticsearchService{IndexName: indexName, retrier: retrier}
 }
 
 func (s *ElasticsearchService) GetClusterHealth() (*elastic.ClusterHealthResponse, error) {
-	if s.ElasticClient == nil {
+	client := s.GetClient()
+	if client == nil {
+		metrics.SetElasticsearchConnected(false)
 		return nil, errors.New("client could not be created, please check the application parameters/env variables, and restart the service")
 	}
 
-	return s.ElasticClient.ClusterHealth().Do()
+	health, err := client.ClusterHealth().Do()
+	metrics.SetElasticsearchConnected(err == nil)
+	return health, err
 }
 
 func (s *ElasticsearchService) GetSchemaHealth() (string, error) {
+	diff, status, err := s.compareSchema()
+	if err != nil || status != "" {
+		return status, err
+	}
+	return schemaHealth(diff), nil
+}
+
+func (s *ElasticsearchService) GetSchemaDiff() (*SchemaDiff, error) {
+	diff, status, err := s.compareSchema()
+	if err == nil && status != "" {
+		err = errors.New(status)
+	}
+	return diff, err
+}
+
+// compareSchema diffs the live index against the reference schema. The status tells why they couldn't be compared.
+func (s *ElasticsearchService) compareSchema() (*SchemaDiff, string, error) {
 	if referenceIndex == nil {
 		referenceIndex = new(elasticIndex)
 
 		referenceJSON, err := config.ReadEmbeddedResource("referenceSchema.json")
 		if err != nil {
-			return "", err
+			return nil, "", err
 		}
 
 		fullReferenceJSON := []byte(fmt.Sprintf(`{"ft": %s}`, string(referenceJSON)))
 		err = json.Unmarshal(fullReferenceJSON, &referenceIndex.index)
 		if err != nil {
-			return "", err
+			return nil, "", err
 		}
 	}
 	if referenceIndex.index[s.IndexName] == nil || referenceIndex.index[s.IndexName].Settings == nil || referenceIndex.index[s.IndexName].Mappings == nil {
-		return "not ok, wrong referenceIndex", nil
+		return nil, "not ok, wrong referenceIndex", nil
 	}
 
-	if s.ElasticClient == nil {
-		return "not ok, connection to ES couldn't be established", nil
+	client := s.GetClient()
+	if client == nil {
+		return nil, "not ok, connection to ES couldn't be established", nil
 	}
 
-	liveIndex, err := s.ElasticClient.IndexGet().Index(s.IndexName).Do()
+	liveIndex, err := client.IndexGet().Index(s.IndexName).Do()
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
 	indices := make([]string, 0, len(liveIndex))
@@ -81,28 +129,22 @@ func (s *ElasticsearchService) GetSchemaHealth() (string, error) {
 		indices = append(indices, indexName)
 	}
 	if len(indices) < 1 {
-		return fmt.Sprintf("not ok, could not find index or alias %s", s.IndexName), nil
+		return nil, fmt.Sprintf("not ok, could not find index or alias %s", s.IndexName), nil
 	}
 	// we are getting the first index name because we are fetching by index or alias but response is real name
 	realIndexName := indices[0]
 
-	settings, ok := liveIndex[realIndexName].Settings["index"].(map[string]interface{})
-	if ok {
-		delete(settings, "creation_date")
-		delete(settings, "uuid")
-		delete(settings, "version")
-		delete(settings, "created")
-	}
-
-	if !reflect.DeepEqual(liveIndex[realIndexName].Settings, referenceIndex.index[s.IndexName].Settings) {
-		return "not ok, wrong settings", nil
-	}
+	reference := referenceIndex.index[s.IndexName]
+	live := liveIndex[realIndexName]
+	return diffSchema(realIndexName, reference.Settings, reference.Mappings, live.Settings, live.Mappings), "", nil
+}
 
-	if !reflect.DeepEqual(liveIndex[realIndexName].Mappings, referenceIndex.index[s.IndexName].Mappings) {
-		return "not ok, wrong mappings", nil
+// schemaHealth is "ok" when the index matches the reference schema, otherwise it summarises the differences.
+func schemaHealth(diff *SchemaDiff) string {
+	if diff.Empty() {
+		return "ok"
 	}
-
-	return "ok", nil
+	return "not ok, " + diff.String()
 }
 
 func (s *ElasticsearchService) GetClient() Client {
@@ -114,25 +156,130 @@ func (s *ElasticsearchService) SetClient(client Client) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.ElasticClient = client
+	metrics.SetElasticsearchConnected(client != nil)
 }
 
-func (s *ElasticsearchService) WriteData(conceptType string, uuid string, payload interface{}) (*elastic.IndexResult, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.ElasticClient.Index().
-		Index(s.IndexName).
-		Type(conceptType).
-		Id(uuid).
-		BodyJson(payload).
-		Do()
+func (s *ElasticsearchService) WriteData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	var result *elastic.IndexResult
+	err := s.retrier.Do(ctx, writeOperation, func() error {
+		defer metrics.ObserveElasticsearch(metrics.OperationWrite, time.Now())
+		res, err := withContext(ctx, func() (interface{}, error) {
+			return s.writeData(conceptType, uuid, payload, version)
+		})
+		if err != nil {
+			return err
+		}
+		result = res.(*elastic.IndexResult)
+		return nil
+	}, IsRetryable)
+	return result, staleVersionError(err)
 }
 
-func (s *ElasticsearchService) DeleteData(conceptType string, uuid string) (*elastic.DeleteResult, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.ElasticClient.Delete().
+func (s *ElasticsearchService) writeData(conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	client := s.GetClient()
+	if client == nil {
+		return nil, elastic.ErrNoClient
+	}
+	index := client.Index().
 		Index(s.IndexName).
 		Type(conceptType).
 		Id(uuid).
-		Do()
+		BodyJson(payload)
+	if version > 0 {
+		index = index.Version(version).VersionType(externalVersionType)
+	}
+	return index.Do()
+}
+
+func (s *ElasticsearchService) DeleteData(ctx context.Context, conceptType string, uuid string, version int64) (*elastic.DeleteResult, error) {
+	var result *elastic.DeleteResult
+	err := s.retrier.Do(ctx, deleteOperation, func() error {
+		defer metrics.ObserveElasticsearch(metrics.OperationDelete, time.Now())
+		res, err := withContext(ctx, func() (interface{}, error) {
+			return s.deleteData(conceptType, uuid, version)
+		})
+		if err != nil {
+			return err
+		}
+		result = res.(*elastic.DeleteResult)
+		return nil
+	}, IsRetryable)
+	return result, staleVersionError(err)
+}
+
+func (s *ElasticsearchService) deleteData(conceptType string, uuid string, version int64) (*elastic.DeleteResult, error) {
+	client := s.GetClient()
+	if client == nil {
+		return nil, elastic.ErrNoClient
+	}
+	if version <= 0 {
+		return client.Delete().
+			Index(s.IndexName).
+			Type(conceptType).
+			Id(uuid).
+			Do()
+	}
+
+	// the delete service of the client does not support external versions
+	path := fmt.Sprintf("/%s/%s/%s", url.PathEscape(s.IndexName), url.PathEscape(conceptType), url.PathEscape(uuid))
+	params := url.Values{}
+	params.Set("version", strconv.FormatInt(version, 10))
+	params.Set("version_type", externalVersionType)
+	res, err := client.PerformRequest(http.MethodDelete, path, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := new(elastic.DeleteResult)
+	if err = json.Unmarshal(res.Body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// withContext returns the outcome of call, or the error of ctx as soon as it is done: the client can't cancel its requests,
+// so an abandoned call completes in the background and its outcome is dropped.
+func withContext(ctx context.Context, call func() (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := call()
+		done <- outcome{result: result, err: err}
+	}()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// staleVersionError reports a version conflict as ErrStaleVersion, other errors are returned as they are.
+func staleVersionError(err error) error {
+	if esErr, ok := err.(*elastic.Error); ok && esErr.Status == http.StatusConflict {
+		return ErrStaleVersion
+	}
+	return err
+}
+
+// IsRetryable tells whether a failed Elasticsearch call may succeed when attempted again:
+// the cluster was unreachable, overloaded or temporarily unavailable.
+func IsRetryable(err error) bool {
+	if err == elastic.ErrNoClient {
+		return true
+	}
+	if esErr, ok := err.(*elastic.Error); ok {
+		switch esErr.Status {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	_, isNetErr := err.(net.Error)
+	return isNetErr
 }
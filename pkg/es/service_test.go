@@ -0,0 +1,191 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v2"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: &elastic.Error{Status: http.StatusServiceUnavailable}, retryable: true},
+		{err: &elastic.Error{Status: http.StatusTooManyRequests}, retryable: true},
+		{err: &elastic.Error{Status: http.StatusGatewayTimeout}, retryable: true},
+		{err: &elastic.Error{Status: http.StatusBadRequest}, retryable: false},
+		{err: &elastic.Error{Status: http.StatusConflict}, retryable: false},
+		{err: elastic.ErrNoClient, retryable: true},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, retryable: true},
+		{err: errors.New("elastic: unexpected"), retryable: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.retryable, IsRetryable(test.err), "unexpected classification of %v", test.err)
+	}
+}
+
+// versionedStandIn answers index and delete requests like Elasticsearch does for external_gte versions.
+func versionedStandIn(t *testing.T, versions map[string]int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "external_gte", r.URL.Query().Get("version_type"))
+		version, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		id := path.Base(r.URL.Path)
+		current, found := versions[id]
+		if version < current {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = fmt.Fprintf(w, `{"error":"VersionConflictEngineException[[ft][0] [FTCom][%s]: version conflict, current [%d], provided [%d]]","status":409}`, id, current, version)
+			return
+		}
+		versions[id] = version
+		status := http.StatusOK
+		if r.Method != http.MethodDelete && !found {
+			status = http.StatusCreated
+		}
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"_index": "ft", "_type": "FTCom", "_id": id, "_version": version, "found": found, "created": !found})
+	}))
+}
+
+func TestWriteDataSkipsStaleVersion(t *testing.T) {
+	server := versionedStandIn(t, map[string]int64{"a0000000-0000-0000-0000-000000000000": 20})
+	defer server.Close()
+	service := NewService("ft", nil)
+	service.SetClient(newTestClient(t, server.URL))
+
+	_, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 10)
+	assert.Equal(t, ErrStaleVersion, err)
+
+	res, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 30)
+	require.NoError(t, err)
+	assert.Equal(t, 30, res.Version)
+}
+
+func TestDeleteDataSkipsStaleVersion(t *testing.T) {
+	server := versionedStandIn(t, map[string]int64{"a0000000-0000-0000-0000-000000000000": 20})
+	defer server.Close()
+	service := NewService("ft", nil)
+	service.SetClient(newTestClient(t, server.URL))
+
+	_, err := service.DeleteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", 10)
+	assert.Equal(t, ErrStaleVersion, err)
+
+	res, err := service.DeleteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", 20)
+	require.NoError(t, err)
+	assert.True(t, res.Found)
+	assert.Equal(t, int64(20), res.Version)
+}
+
+// slowStandIn indexes every document after latency, like a cluster under load.
+func slowStandIn(latency time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"_index": "ft", "_type": "FTCom", "_id": path.Base(r.URL.Path), "_version": 1, "created": true})
+	}))
+}
+
+func TestWriteDataRunsConcurrently(t *testing.T) {
+	// both requests are only answered once they are in flight together
+	arrived := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		for len(arrived) < 2 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprint(w, `{"_index":"ft","_type":"FTCom","_version":1,"created":true}`)
+	}))
+	defer server.Close()
+	service := NewService("ft", nil)
+	service.SetClient(newTestClient(t, server.URL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, uuid := range []string{"a0000000-0000-0000-0000-000000000000", "b0000000-0000-0000-0000-000000000000"} {
+		wg.Add(1)
+		go func(uuid string) {
+			defer wg.Done()
+			_, err := service.WriteData(ctx, "FTCom", uuid, map[string]string{}, 0)
+			assert.NoError(t, err)
+		}(uuid)
+	}
+	wg.Wait()
+}
+
+func TestWriteDataWithoutClient(t *testing.T) {
+	service := NewService("ft", nil)
+
+	_, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+	assert.Equal(t, elastic.ErrNoClient, err)
+}
+
+// BenchmarkWriteData compares writing one document at a time with writing from parallel goroutines,
+// against a stand-in answering after 2ms.
+func BenchmarkWriteData(b *testing.B) {
+	server := slowStandIn(2 * time.Millisecond)
+	defer server.Close()
+	service := NewService("ft", nil)
+	service.SetClient(newTestClient(b, server.URL))
+	write := func() error {
+		_, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{"title": "benchmark"}, 0)
+		return err
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := write(); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	// the baseline of the parallel writes: the same goroutines, but a write at a time like behind a service wide lock
+	b.Run("parallel mutex", func(b *testing.B) {
+		var mu sync.Mutex
+		b.SetParallelism(4)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.Lock()
+				err := write()
+				mu.Unlock()
+				if err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.SetParallelism(4)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := write(); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,327 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/retry"
+
+	"gopkg.in/olivere/elastic.v2"
+)
+
+const (
+	// CollectionField holds the collection (FTCom, FTBlogs, ...) of documents in typeless indices.
+	CollectionField = "collection"
+
+	typelessType    = "_doc"
+	typelessDocPath = "/%s/" + typelessType + "/%s"
+	resultCreated   = "created"
+	resultDeleted   = "deleted"
+)
+
+// errorStatuses are answered by Elasticsearch 7+ with an error object the client can't decode,
+// they are read by the typeless service itself.
+var errorStatuses = []int{
+	http.StatusBadRequest,
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusConflict,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+var (
+	typelessReferenceOnce sync.Once
+	typelessReference     *elastic.IndicesGetResponse
+	typelessReferenceErr  error
+)
+
+// TypelessService implements Service for Elasticsearch 7+ and OpenSearch clusters, where mapping types are gone.
+// All collections share the index and the collection of a document is stored in its CollectionField.
+// The service only relies on Client.PerformRequest, so the client works against both kinds of clusters.
+type TypelessService struct {
+	mu            sync.RWMutex
+	ElasticClient Client
+	IndexName     string
+	retrier       *retry.Retrier
+}
+
+func NewTypelessService(indexName string, retrier *retry.Retrier) Service {
+	return &TypelessService{IndexName: indexName, retrier: retrier}
+}
+
+func (s *TypelessService) SetClient(client Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ElasticClient = client
+	metrics.SetElasticsearchConnected(client != nil)
+}
+
+func (s *TypelessService) GetClusterHealth() (*elastic.ClusterHealthResponse, error) {
+	health := new(elastic.ClusterHealthResponse)
+	err := s.perform(http.MethodGet, "/_cluster/health", nil, nil, health)
+	metrics.SetElasticsearchConnected(err == nil)
+	if err == elastic.ErrNoClient {
+		return nil, errors.New("client could not be created, please check the application parameters/env variables, and restart the service")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+func (s *TypelessService) GetSchemaHealth() (string, error) {
+	diff, status, err := s.compareSchema()
+	if err != nil || status != "" {
+		return status, err
+	}
+	return schemaHealth(diff), nil
+}
+
+func (s *TypelessService) GetSchemaDiff() (*SchemaDiff, error) {
+	diff, status, err := s.compareSchema()
+	if err == nil && status != "" {
+		err = errors.New(status)
+	}
+	return diff, err
+}
+
+// compareSchema diffs the live index against the typeless reference, its mappings are reported as the _doc type.
+func (s *TypelessService) compareSchema() (*SchemaDiff, string, error) {
+	reference, err := TypelessReferenceIndex()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var liveIndex map[string]*elastic.IndicesGetResponse
+	err = s.perform(http.MethodGet, "/"+url.PathEscape(s.IndexName), nil, nil, &liveIndex)
+	if err == elastic.ErrNoClient {
+		return nil, "not ok, connection to ES couldn't be established", nil
+	}
+	if esErr, ok := err.(*elastic.Error); ok && esErr.Status == http.StatusNotFound {
+		return nil, fmt.Sprintf("not ok, could not find index or alias %s", s.IndexName), nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the index is fetched by index or alias name, the response holds the real name
+	for name, index := range liveIndex {
+		return diffSchema(name, reference.Settings, map[string]interface{}{typelessType: reference.Mappings},
+			index.Settings, map[string]interface{}{typelessType: index.Mappings}), "", nil
+	}
+	return nil, fmt.Sprintf("not ok, could not find index or alias %s", s.IndexName), nil
+}
+
+func (s *TypelessService) WriteData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	doc, err := withCollection(payload, conceptType)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *elastic.IndexResult
+	err = s.retrier.Do(ctx, writeOperation, func() error {
+		defer metrics.ObserveElasticsearch(metrics.OperationWrite, time.Now())
+		res, err := s.performWithContext(ctx, http.MethodPut, s.docPath(uuid), versionParams(version), doc)
+		if err != nil {
+			return err
+		}
+		result = &elastic.IndexResult{Index: res.Index, Type: conceptType, Id: res.ID, Version: res.Version, Created: res.Result == resultCreated}
+		return nil
+	}, IsRetryable)
+	return result, staleVersionError(err)
+}
+
+func (s *TypelessService) DeleteData(ctx context.Context, conceptType string, uuid string, version int64) (*elastic.DeleteResult, error) {
+	var result *elastic.DeleteResult
+	err := s.retrier.Do(ctx, deleteOperation, func() error {
+		defer metrics.ObserveElasticsearch(metrics.OperationDelete, time.Now())
+		res, err := s.performWithContext(ctx, http.MethodDelete, s.docPath(uuid), versionParams(version), nil)
+		if err != nil {
+			return err
+		}
+		result = &elastic.DeleteResult{Found: res.Result == resultDeleted, Index: res.Index, Type: conceptType, Id: res.ID, Version: int64(res.Version)}
+		return nil
+	}, IsRetryable)
+	return result, staleVersionError(err)
+}
+
+// typelessResult is the answer of Elasticsearch 7+ to index and delete requests.
+type typelessResult struct {
+	Index   string `json:"_index"`
+	ID      string `json:"_id"`
+	Version int    `json:"_version"`
+	Result  string `json:"result"`
+}
+
+// typelessError is the error object of Elasticsearch 7+ answers.
+type typelessError struct {
+	Error struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+	Status int `json:"status"`
+}
+
+func (s *TypelessService) docPath(uuid string) string {
+	return fmt.Sprintf(typelessDocPath, url.PathEscape(s.IndexName), url.PathEscape(uuid))
+}
+
+func (s *TypelessService) perform(method string, path string, params url.Values, body interface{}, result interface{}) error {
+	s.mu.RLock()
+	client := s.ElasticClient
+	s.mu.RUnlock()
+	if client == nil {
+		return elastic.ErrNoClient
+	}
+	return performRequest(client, method, path, params, body, result)
+}
+
+// performWithContext sends an index or delete request and returns its result, or the error of ctx as soon as it is done.
+func (s *TypelessService) performWithContext(ctx context.Context, method string, path string, params url.Values, body interface{}) (*typelessResult, error) {
+	res, err := withContext(ctx, func() (interface{}, error) {
+		res := new(typelessResult)
+		return res, s.perform(method, path, params, body, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*typelessResult), nil
+}
+
+// performRequest sends the request and decodes the answer into result. Error answers of Elasticsearch 7+
+// are returned as *elastic.Error, so that they are classified like the ones of the legacy client.
+func performRequest(client Client, method string, path string, params url.Values, body interface{}, result interface{}) error {
+	res, err := client.PerformRequest(method, path, params, body, errorStatuses...)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= http.StatusMultipleChoices && !(method == http.MethodDelete && res.StatusCode == http.StatusNotFound) {
+		var reply typelessError
+		if err = json.Unmarshal(res.Body, &reply); err != nil || reply.Error.Reason == "" {
+			return &elastic.Error{Status: res.StatusCode, Message: string(res.Body)}
+		}
+		return &elastic.Error{Status: res.StatusCode, Message: fmt.Sprintf("%s[%s]", reply.Error.Type, reply.Error.Reason)}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Body, result)
+}
+
+func versionParams(version int64) url.Values {
+	if version <= 0 {
+		return nil
+	}
+	params := url.Values{}
+	params.Set("version", strconv.FormatInt(version, 10))
+	params.Set("version_type", externalVersionType)
+	return params
+}
+
+// withCollection adds the collection to the document, as it can't be its type anymore.
+func withCollection(payload interface{}, collection string) (map[string]json.RawMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	doc[CollectionField], err = json.Marshal(collection)
+	return doc, err
+}
+
+// TypelessReferenceIndex translates the embedded reference schema to Elasticsearch 7+: the mappings of all
+// collections are merged into a single one holding the CollectionField, and the legacy field types are converted.
+func TypelessReferenceIndex() (*elastic.IndicesGetResponse, error) {
+	typelessReferenceOnce.Do(func() {
+		var referenceJSON []byte
+		referenceJSON, typelessReferenceErr = config.ReadEmbeddedResource("referenceSchema.json")
+		if typelessReferenceErr != nil {
+			return
+		}
+		typelessReference, typelessReferenceErr = toTypeless(referenceJSON)
+	})
+	return typelessReference, typelessReferenceErr
+}
+
+func toTypeless(referenceJSON []byte) (*elastic.IndicesGetResponse, error) {
+	var reference struct {
+		Settings map[string]interface{} `json:"settings"`
+		Mappings map[string]struct {
+			Properties map[string]map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(referenceJSON, &reference); err != nil {
+		return nil, err
+	}
+	if reference.Settings == nil || len(reference.Mappings) == 0 {
+		return nil, errors.New("reference schema has no settings or mappings")
+	}
+
+	properties := map[string]interface{}{
+		CollectionField: map[string]interface{}{"type": "keyword"},
+	}
+	for _, mapping := range reference.Mappings {
+		for name, field := range mapping.Properties {
+			properties[name] = typelessField(field)
+		}
+	}
+	return &elastic.IndicesGetResponse{
+		Settings: reference.Settings,
+		Mappings: map[string]interface{}{"properties": properties},
+	}, nil
+}
+
+// typelessField converts a legacy field mapping: not analyzed strings become keywords, the other strings text,
+// date formats get their snake case name and include_in_all is dropped, in object properties too.
+func typelessField(field map[string]interface{}) map[string]interface{} {
+	converted := make(map[string]interface{}, len(field))
+	for key, value := range field {
+		converted[key] = value
+	}
+	delete(converted, "include_in_all")
+
+	if converted["type"] == "string" {
+		if converted["index"] == "not_analyzed" {
+			converted["type"] = "keyword"
+		} else {
+			converted["type"] = "text"
+		}
+		delete(converted, "index")
+	}
+	if converted["format"] == "dateOptionalTime" {
+		converted["format"] = "date_optional_time"
+	}
+	// the sub-fields of strings and the properties of objects are converted alike
+	for _, key := range []string{"fields", "properties"} {
+		fields, ok := converted[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		subFields := make(map[string]interface{}, len(fields))
+		for name, subField := range fields {
+			if f, ok := subField.(map[string]interface{}); ok {
+				subFields[name] = typelessField(f)
+			} else {
+				subFields[name] = subField
+			}
+		}
+		converted[key] = subFields
+	}
+	return converted
+}
@@ -0,0 +1,268 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// typelessStandIn answers like an Elasticsearch 7 cluster holding the typeless index ft-v1 behind the ft alias.
+type typelessStandIn struct {
+	mu       sync.Mutex
+	docs     map[string]map[string]interface{}
+	versions map[string]int64
+	mappings map[string]interface{}
+	settings map[string]interface{}
+}
+
+func newTypelessStandIn(t *testing.T) *typelessStandIn {
+	reference, err := TypelessReferenceIndex()
+	require.NoError(t, err)
+
+	// the cluster answers with copies of the reference and the settings it adds itself
+	var mappings, settings map[string]interface{}
+	copyJSON(t, reference.Mappings, &mappings)
+	copyJSON(t, reference.Settings, &settings)
+	index := settings["index"].(map[string]interface{})
+	index["creation_date"] = "1588235411711"
+	index["uuid"] = "nuZqPWCRRXONe0Cmz9xB2g"
+	index["provided_name"] = "ft-v1"
+	index["version"] = map[string]interface{}{"created": "7040299"}
+
+	return &typelessStandIn{
+		docs:     make(map[string]map[string]interface{}),
+		versions: make(map[string]int64),
+		mappings: mappings,
+		settings: settings,
+	}
+}
+
+func copyJSON(t *testing.T, from interface{}, to interface{}) {
+	body, err := json.Marshal(from)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(body, to))
+}
+
+func (s *typelessStandIn) start(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.URL.Path == "/_cluster/health":
+			_, _ = fmt.Fprint(w, `{"cluster_name":"test","status":"green","number_of_nodes":3}`)
+		case r.URL.Path == "/ft" && r.Method == http.MethodGet:
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"ft-v1": map[string]interface{}{"aliases": map[string]interface{}{"ft": map[string]interface{}{}}, "mappings": s.mappings, "settings": s.settings},
+			})
+		case path.Dir(r.URL.Path) == "/ft/_doc":
+			s.document(t, w, r)
+		case r.URL.Path == "/ft/_search":
+			s.searchLookupFailures(w)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprintf(w, `{"error":{"type":"index_not_found_exception","reason":"no such index [%s]"},"status":404}`, r.URL.Path)
+		}
+	}))
+}
+
+func (s *typelessStandIn) document(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+	if v := r.URL.Query().Get("version"); v != "" {
+		assert.Equal(t, "external_gte", r.URL.Query().Get("version_type"))
+		version, err := strconv.ParseInt(v, 10, 64)
+		require.NoError(t, err)
+		if version < s.versions[id] {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = fmt.Fprintf(w, `{"error":{"type":"version_conflict_engine_exception","reason":"[%s]: version conflict, current version [%d] is higher than the one provided [%d]"},"status":409}`, id, s.versions[id], version)
+			return
+		}
+		s.versions[id] = version
+	}
+
+	_, found := s.docs[id]
+	result := map[string]interface{}{"_index": "ft-v1", "_type": "_doc", "_id": id, "_version": s.versions[id]}
+	switch r.Method {
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &doc))
+		s.docs[id] = doc
+		result["result"] = "updated"
+		if !found {
+			result["result"] = "created"
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodDelete:
+		delete(s.docs, id)
+		result["result"] = "deleted"
+		if !found {
+			result["result"] = "not_found"
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// searchLookupFailures answers the search of LookupFailures, other queries are not supported.
+func (s *typelessStandIn) searchLookupFailures(w http.ResponseWriter) {
+	hits := []map[string]interface{}{}
+	for id, doc := range s.docs {
+		if doc["lookupFailure"] == true {
+			hits = append(hits, map[string]interface{}{"_index": "ft-v1", "_id": id})
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"total": map[string]interface{}{"value": len(hits)}, "hits": hits}})
+}
+
+func newTypelessTestService(t *testing.T) (*TypelessService, *typelessStandIn, func()) {
+	standIn := newTypelessStandIn(t)
+	server := standIn.start(t)
+	service := NewTypelessService("ft", nil).(*TypelessService)
+	service.SetClient(newTestClient(t, server.URL))
+	return service, standIn, server.Close
+}
+
+func TestTypelessWriteDataStoresCollection(t *testing.T) {
+	service, standIn, stop := newTypelessTestService(t)
+	defer stop()
+
+	res, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{"uid": "a0000000-0000-0000-0000-000000000000"}, 10)
+
+	require.NoError(t, err)
+	assert.True(t, res.Created)
+	assert.Equal(t, "FTCom", res.Type)
+	assert.Equal(t, 10, res.Version)
+	assert.Equal(t, map[string]interface{}{"uid": "a0000000-0000-0000-0000-000000000000", CollectionField: "FTCom"}, standIn.docs["a0000000-0000-0000-0000-000000000000"])
+
+	res, err = service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 20)
+	require.NoError(t, err)
+	assert.False(t, res.Created)
+}
+
+func TestTypelessWriteDataSkipsStaleVersion(t *testing.T) {
+	service, _, stop := newTypelessTestService(t)
+	defer stop()
+
+	_, err := service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 20)
+	require.NoError(t, err)
+
+	_, err = service.WriteData(context.Background(), "FTCom", "a0000000-0000-0000-0000-000000000000", map[string]string{}, 10)
+	assert.Equal(t, ErrStaleVersion, err)
+}
+
+func TestTypelessDeleteData(t *testing.T) {
+	service, _, stop := newTypelessTestService(t)
+	defer stop()
+
+	res, err := service.DeleteData(context.Background(), "FTCom", "b0000000-0000-0000-0000-000000000000", 0)
+	require.NoError(t, err)
+	assert.False(t, res.Found)
+
+	_, err = service.WriteData(context.Background(), "FTCom", "b0000000-0000-0000-0000-000000000000", map[string]string{}, 0)
+	require.NoError(t, err)
+	res, err = service.DeleteData(context.Background(), "FTCom", "b0000000-0000-0000-0000-000000000000", 0)
+	require.NoError(t, err)
+	assert.True(t, res.Found)
+	assert.Equal(t, "b0000000-0000-0000-0000-000000000000", res.Id)
+}
+
+func TestTypelessClusterHealth(t *testing.T) {
+	service, _, stop := newTypelessTestService(t)
+	defer stop()
+
+	health, err := service.GetClusterHealth()
+
+	require.NoError(t, err)
+	assert.Equal(t, "green", health.Status)
+	assert.Equal(t, 3, health.NumberOfNodes)
+}
+
+func TestTypelessSchemaHealth(t *testing.T) {
+	service, standIn, stop := newTypelessTestService(t)
+	defer stop()
+
+	status, err := service.GetSchemaHealth()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", status)
+
+	standIn.mu.Lock()
+	standIn.mappings["properties"].(map[string]interface{})["body"] = map[string]interface{}{"type": "keyword"}
+	standIn.mu.Unlock()
+	status, err = service.GetSchemaHealth()
+	require.NoError(t, err)
+	assert.Equal(t, "not ok, wrong mappings: changed _doc.properties.body.type", status)
+
+	diff, err := service.GetSchemaDiff()
+	require.NoError(t, err)
+	assert.Equal(t, "ft-v1", diff.Index)
+	assert.Empty(t, diff.Settings)
+	assert.Equal(t, map[string]Diff{typelessType: {Changed: []Change{{Path: "_doc.properties.body.type", Expected: "text", Actual: "keyword"}}}}, diff.Mappings)
+
+	service.IndexName = "missing"
+	status, err = service.GetSchemaHealth()
+	require.NoError(t, err)
+	assert.Equal(t, "not ok, could not find index or alias missing", status)
+
+	_, err = service.GetSchemaDiff()
+	assert.EqualError(t, err, "not ok, could not find index or alias missing")
+}
+
+func TestTypelessWithoutClient(t *testing.T) {
+	service := NewTypelessService("ft", nil)
+
+	_, err := service.GetClusterHealth()
+	assert.Error(t, err)
+
+	status, err := service.GetSchemaHealth()
+	require.NoError(t, err)
+	assert.Equal(t, "not ok, connection to ES couldn't be established", status)
+}
+
+func TestTypelessReferenceIndex(t *testing.T) {
+	reference, err := TypelessReferenceIndex()
+	require.NoError(t, err)
+
+	properties := reference.Mappings["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "keyword"}, properties[CollectionField])
+	assert.Equal(t, map[string]interface{}{"type": "text"}, properties["articleBrands"])
+	assert.Equal(t, map[string]interface{}{"type": "text", "fields": map[string]interface{}{"raw": map[string]interface{}{"type": "keyword"}}}, properties["byline"])
+	assert.Equal(t, map[string]interface{}{"properties": map[string]interface{}{
+		"en": map[string]interface{}{"type": "text", "analyzer": "english_body"},
+		"es": map[string]interface{}{"type": "text", "analyzer": "spanish_body"},
+		"zh": map[string]interface{}{"type": "text", "analyzer": "chinese_body"},
+	}}, properties["language_body"])
+	// only FTCom maps these fields
+	assert.Contains(t, properties, "editorsTags")
+	for _, field := range properties {
+		assert.NotContains(t, field, "include_in_all")
+	}
+	assert.NotContains(t, reference.Mappings, "_all")
+}
+
+func TestTypelessLookupFailures(t *testing.T) {
+	service, _, stop := newTypelessTestService(t)
+	defer stop()
+
+	_, err := service.WriteData(context.Background(), "FTCom", "c0000000-0000-0000-0000-000000000000", map[string]interface{}{"lookupFailure": true}, 0)
+	require.NoError(t, err)
+	_, err = service.WriteData(context.Background(), "FTCom", "d0000000-0000-0000-0000-000000000000", map[string]interface{}{"lookupFailure": false}, 0)
+	require.NoError(t, err)
+
+	uuids, err := service.LookupFailures(context.Background(), 100)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c0000000-0000-0000-0000-000000000000"}, uuids)
+}
@@ -4,48 +4,51 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/concept"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/source"
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/Financial-Times/go-logger/v2"
-	"github.com/Financial-Times/message-queue-gonsumer/consumer"
 	"github.com/Financial-Times/service-status-go/gtg"
 )
 
 const (
 	pathHealth        = "/__health"
 	pathHealthDetails = "/__health-details"
+	pathSchemaDiff    = "/__schema-diff"
 	panicGuide        = "https://runbooks.in.ft.com/content-rw-elasticsearch"
 )
 
 type Service struct {
-	ESHealthService  es.HealthStatus
-	ConcordanceAPI   *concept.ConcordanceAPIService
-	ConsumerInstance consumer.MessageConsumer
-	HTTPClient       *http.Client
-	Checks           []fthealth.Check
-	AppSystemCode    string
-	log              *logger.UPPLogger
+	ESHealthService es.HealthStatus
+	ConcordanceAPI  *concept.ConcordanceAPIService
+	MessageSource   source.Source
+	HTTPClient      *http.Client
+	Checks          []fthealth.Check
+	AppSystemCode   string
+	log             *logger.UPPLogger
+	// draining is set once the service shuts down, so that no more traffic is routed to it
+	draining int32
 }
 
-func NewHealthService(config *consumer.QueueConfig, esHealthService es.HealthStatus, client *http.Client, concordanceAPI *concept.ConcordanceAPIService, appSystemCode string, log *logger.UPPLogger) *Service {
-	consumerInstance := consumer.NewConsumer(*config, func(m consumer.Message) {}, client)
+func NewHealthService(messageSource source.Source, esHealthService es.HealthStatus, client *http.Client, concordanceAPI *concept.ConcordanceAPIService, appSystemCode string, log *logger.UPPLogger) *Service {
 	service := &Service{
-		ESHealthService:  esHealthService,
-		ConcordanceAPI:   concordanceAPI,
-		ConsumerInstance: consumerInstance,
-		HTTPClient:       client,
-		AppSystemCode:    appSystemCode,
-		log:              log,
+		ESHealthService: esHealthService,
+		ConcordanceAPI:  concordanceAPI,
+		MessageSource:   messageSource,
+		HTTPClient:      client,
+		AppSystemCode:   appSystemCode,
+		log:             log,
 	}
 	service.Checks = []fthealth.Check{
 		service.clusterIsHealthyCheck(),
 		service.connectivityHealthyCheck(),
 		service.schemaHealthyCheck(),
-		service.checkKafkaProxyConnectivity(),
+		service.checkMessageSourceConnectivity(),
 		service.checkConcordanceAPI(),
 	}
 	return service
@@ -60,6 +63,7 @@ func (s *Service) AttachHTTPEndpoints(serveMux *http.ServeMux, appName string, a
 	}
 	serveMux.HandleFunc(pathHealth, fthealth.Handler(hc))
 	serveMux.HandleFunc(pathHealthDetails, s.healthDetails)
+	serveMux.HandleFunc(pathSchemaDiff, s.schemaDiff)
 	serveMux.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(s.gtgCheck))
 	serveMux.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler)
 
@@ -117,7 +121,7 @@ func (s *Service) schemaHealthyCheck() fthealth.Check {
 		Name:             "Check Elasticsearch mapping",
 		PanicGuide:       "https://runbooks.in.ft.com/content-rw-elasticsearch",
 		Severity:         1,
-		TechnicalSummary: "Elasticsearch mapping does not match expected mapping. Please check index against the reference https://github.com/Financial-Times/content-rw-elasticsearch/blob/master/configs/referenceSchema.json",
+		TechnicalSummary: "Elasticsearch mapping does not match expected mapping. Please check index against the reference https://github.com/Financial-Times/content-rw-elasticsearch/blob/master/configs/referenceSchema.json, the differing paths are listed on /__schema-diff",
 		Checker:          s.schemaChecker,
 	}
 }
@@ -133,15 +137,15 @@ func (s *Service) schemaChecker() (string, error) {
 	}
 }
 
-func (s *Service) checkKafkaProxyConnectivity() fthealth.Check {
+func (s *Service) checkMessageSourceConnectivity() fthealth.Check {
 	return fthealth.Check{
 		ID:               s.AppSystemCode,
 		BusinessImpact:   "CombinedPostPublication messages can't be read from the queue. Indexing for search won't work.",
-		Name:             "Check kafka-proxy connectivity.",
+		Name:             "Check message source connectivity.",
 		PanicGuide:       panicGuide,
 		Severity:         1,
-		TechnicalSummary: "Messages couldn't be read from the queue. Check if kafka-proxy is reachable.",
-		Checker:          s.ConsumerInstance.ConnectivityCheck,
+		TechnicalSummary: "Messages couldn't be read from the queue. Check if kafka-proxy, the Kafka brokers or the message file are reachable.",
+		Checker:          s.MessageSource.ConnectivityCheck,
 	}
 }
 
@@ -157,7 +161,15 @@ func (s *Service) checkConcordanceAPI() fthealth.Check {
 	}
 }
 
+// SetDraining reports the service as not good to go until it exits.
+func (s *Service) SetDraining() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
 func (s *Service) gtgCheck() gtg.Status {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		return gtg.Status{GoodToGo: false, Message: "service is shutting down"}
+	}
 	for _, check := range s.Checks {
 		if _, err := check.Checker(); err != nil {
 			return gtg.Status{GoodToGo: false, Message: err.Error()}
@@ -186,3 +198,26 @@ func (s *Service) healthDetails(writer http.ResponseWriter, req *http.Request) {
 		s.log.WithError(err).Error(err.Error())
 	}
 }
+
+// schemaDiff returns the added, removed and changed paths of the index settings and mappings compared to the reference schema
+func (s *Service) schemaDiff(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	diff, err := s.ESHealthService.GetSchemaDiff()
+	if err != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		response, _ := json.Marshal(map[string]string{"message": err.Error()})
+		_, _ = writer.Write(response)
+		return
+	}
+
+	response, err := json.Marshal(diff)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = writer.Write(response)
+	if err != nil {
+		s.log.WithError(err).Error(err.Error())
+	}
+}
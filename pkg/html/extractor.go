@@ -0,0 +1,167 @@
+package html
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const paragraphBreak = "\n\n"
+
+// DefaultDroppedElements are left out of the body text together with everything they contain.
+var DefaultDroppedElements = []string{"script", "pull-quote", "interactive-comp", "ft-related", "experimental"}
+
+// blockElements end the paragraph they are in, so that the text on both sides of them is not glued together.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true, "caption": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// voidElements never have an end tag, so they can't contain a dropped element's content.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Extractor walks the markup of a body with a tokenizer instead of regexes, so nested tags, attributes containing >
+// and CDATA sections are read as they are meant.
+type Extractor struct {
+	dropped map[string]bool
+}
+
+// NewExtractor drops the elements whose name or one of whose classes is listed, together with their content.
+func NewExtractor(dropped ...string) *Extractor {
+	e := &Extractor{dropped: make(map[string]bool, len(dropped))}
+	for _, name := range dropped {
+		e.dropped[strings.ToLower(name)] = true
+	}
+	return e
+}
+
+// Transform returns the text of the body: paragraphs are separated by a blank line, line breaks are kept and any other
+// white space is collapsed. It can be used as one of the transformers of TransformText.
+func (e *Extractor) Transform(input string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	tokenizer.AllowCDATA(true)
+
+	text := &textBuilder{}
+	// dropDepth counts the open elements inside the dropped one being skipped
+	dropDepth := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// the input has been read fully, the tokenizer does not fail on malformed markup
+			return text.String()
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken:
+			if dropDepth > 0 {
+				if !voidElements[token.Data] {
+					dropDepth++
+				}
+				continue
+			}
+			if e.drops(token) {
+				if !voidElements[token.Data] {
+					dropDepth = 1
+				}
+				continue
+			}
+			boundary(text, token.Data)
+		case html.SelfClosingTagToken:
+			if dropDepth == 0 && !e.drops(token) {
+				boundary(text, token.Data)
+			}
+		case html.EndTagToken:
+			if dropDepth > 0 {
+				dropDepth--
+				continue
+			}
+			if token.Data != "br" {
+				boundary(text, token.Data)
+			}
+		case html.TextToken:
+			if dropDepth == 0 {
+				text.write(token.Data)
+			}
+		}
+	}
+}
+
+func (e *Extractor) drops(token html.Token) bool {
+	if e.dropped[token.Data] {
+		return true
+	}
+	for _, attr := range token.Attr {
+		if attr.Key != "class" {
+			continue
+		}
+		for _, class := range strings.Fields(attr.Val) {
+			if e.dropped[strings.ToLower(class)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// boundary breaks the text where the element starts or ends a line or paragraph.
+func boundary(text *textBuilder, element string) {
+	switch {
+	case element == "br":
+		text.lineBreak()
+	case blockElements[element]:
+		text.paragraphBreak()
+	}
+}
+
+// textBuilder collapses white space and keeps the pending break until more text follows,
+// so that breaks are never doubled nor trailing.
+type textBuilder struct {
+	builder strings.Builder
+	pending string
+}
+
+func (b *textBuilder) write(data string) {
+	data = strings.Replace(data, "\u00a0", singleSpace, -1)
+	words := strings.Fields(data)
+	if len(words) == 0 {
+		if data != "" && b.pending == "" && b.builder.Len() > 0 {
+			b.pending = singleSpace
+		}
+		return
+	}
+	if b.builder.Len() > 0 {
+		pending := b.pending
+		if pending == "" && isSpace(data[0]) {
+			pending = singleSpace
+		}
+		b.builder.WriteString(pending)
+	}
+	b.builder.WriteString(strings.Join(words, singleSpace))
+	b.pending = ""
+	if isSpace(data[len(data)-1]) {
+		b.pending = singleSpace
+	}
+}
+
+func (b *textBuilder) lineBreak() {
+	if b.pending != paragraphBreak {
+		b.pending = "\n"
+	}
+}
+
+func (b *textBuilder) paragraphBreak() {
+	b.pending = paragraphBreak
+}
+
+func (b *textBuilder) String() string {
+	return b.builder.String()
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
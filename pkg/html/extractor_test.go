@@ -0,0 +1,53 @@
+package html
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the extractor tests")
+
+func TestExtractorGoldenFiles(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "extractor", "*.html"))
+	require.NoError(t, err)
+	require.NotEmpty(t, inputs)
+
+	extractor := NewExtractor(DefaultDroppedElements...)
+	for _, input := range inputs {
+		t.Run(filepath.Base(input), func(t *testing.T) {
+			body, err := ioutil.ReadFile(input)
+			require.NoError(t, err)
+			actual := extractor.Transform(string(body))
+
+			golden := strings.TrimSuffix(input, ".html") + ".golden"
+			if *update {
+				require.NoError(t, ioutil.WriteFile(golden, []byte(actual), 0644))
+			}
+			expected, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), actual)
+		})
+	}
+}
+
+func TestExtractorKeepsParagraphsApart(t *testing.T) {
+	extractor := NewExtractor()
+	assert.Equal(t, "end.\n\nStart", extractor.Transform("<p>end.</p><p>Start</p>"))
+	assert.Equal(t, "end.Start", TransformText("<p>end.</p><p>Start</p>", TagsRemover))
+}
+
+func TestExtractorDropsElementsByClass(t *testing.T) {
+	extractor := NewExtractor("interactive-comp")
+	assert.Equal(t, "before after", extractor.Transform(`before <div class="wide interactive-comp"><div>nested</div></div> after`))
+}
+
+func TestExtractorInTransformText(t *testing.T) {
+	extractor := NewExtractor(DefaultDroppedElements...)
+	assert.Equal(t, "takes one to", TransformText("<p>takes one to embed1 </p>", extractor.Transform, Embed1Replacer, OuterSpaceTrimmer))
+}
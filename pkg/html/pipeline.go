@@ -0,0 +1,79 @@
+package html
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ExtractTransformer is the name of the tokenizer extractor in pipelines, its step lists the elements it drops.
+const ExtractTransformer = "extract"
+
+// namedTransformers are the transformers pipelines refer to by name.
+var namedTransformers = map[string]textTransformer{
+	"interactiveGraphics": InteractiveGraphicsMarkupTagRemover,
+	"pullQuotes":          PullTagTransformer,
+	"entities":            EntityTransformer,
+	"scripts":             ScriptTagRemover,
+	"tags":                TagsRemover,
+	"trim":                OuterSpaceTrimmer,
+	"embeds":              Embed1Replacer,
+	"squaredCaptions":     SquaredCaptionReplacer,
+	"whitespace":          DuplicateWhiteSpaceRemover,
+}
+
+// Step is a stage of a pipeline: either a named transformer or a regex whose matches are removed.
+// Drop lists the elements left out by the extract transformer.
+type Step struct {
+	Transformer string
+	Remove      string
+	Drop        []string
+}
+
+// Pipeline applies its transformers in order, like TransformText.
+type Pipeline struct {
+	transformers []textTransformer
+}
+
+func NewPipeline(steps []Step) (*Pipeline, error) {
+	p := &Pipeline{transformers: make([]textTransformer, 0, len(steps))}
+	for i, step := range steps {
+		transformer, err := newTransformer(step)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		p.transformers = append(p.transformers, transformer)
+	}
+	return p, nil
+}
+
+func newTransformer(step Step) (textTransformer, error) {
+	switch {
+	case step.Transformer != "" && step.Remove != "":
+		return nil, fmt.Errorf("both transformer %q and remove %q are set", step.Transformer, step.Remove)
+	case step.Transformer == ExtractTransformer:
+		return NewExtractor(step.Drop...).Transform, nil
+	case len(step.Drop) > 0:
+		return nil, fmt.Errorf("drop is only supported by the %s transformer", ExtractTransformer)
+	case step.Transformer != "":
+		transformer, found := namedTransformers[step.Transformer]
+		if !found {
+			return nil, fmt.Errorf("unknown transformer %q", step.Transformer)
+		}
+		return transformer, nil
+	case step.Remove != "":
+		regex, err := regexp.Compile(step.Remove)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remove regex: %w", err)
+		}
+		return func(input string) string {
+			return regex.ReplaceAllString(input, "")
+		}, nil
+	default:
+		return nil, errors.New("neither transformer nor remove is set")
+	}
+}
+
+func (p *Pipeline) Transform(text string) string {
+	return TransformText(text, p.transformers...)
+}
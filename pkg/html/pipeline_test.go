@@ -0,0 +1,41 @@
+package html
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineAppliesStepsInOrder(t *testing.T) {
+	pipeline, err := NewPipeline([]Step{
+		{Remove: `(?s)<ft-embed(\s|>).*?</ft-embed>`},
+		{Transformer: "tags"},
+		{Transformer: "whitespace"},
+		{Transformer: "trim"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "before after", pipeline.Transform(` <p>before <ft-embed id="1"><p>embedded</p></ft-embed>   after</p> `))
+}
+
+func TestPipelineExtractStep(t *testing.T) {
+	pipeline, err := NewPipeline([]Step{{Transformer: ExtractTransformer, Drop: []string{"aside"}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "first\n\nsecond", pipeline.Transform(`<p>first</p><aside>left out</aside><p>second</p>`))
+}
+
+func TestPipelineRejectsInvalidSteps(t *testing.T) {
+	steps := []Step{
+		{},
+		{Transformer: "uppercase"},
+		{Remove: "(unclosed"},
+		{Transformer: "tags", Remove: "<b>"},
+		{Transformer: "tags", Drop: []string{"script"}},
+	}
+	for _, step := range steps {
+		_, err := NewPipeline([]Step{{Transformer: "trim"}, step})
+		assert.Error(t, err, "step %+v", step)
+	}
+}
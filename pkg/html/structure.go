@@ -0,0 +1,172 @@
+package html
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	headingElements = map[string]bool{"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true}
+)
+
+// Structure is what a body holds besides its flat text.
+type Structure struct {
+	PullQuotes    []string
+	ImageCaptions []string
+	// LinkedContentIDs are the UUIDs of the content referenced by <ft-content> and <content>, embedded content excluded
+	LinkedContentIDs []string
+	// ExternalLinks are the URLs of the links leaving ft.com
+	ExternalLinks []string
+	Headings      []string
+}
+
+// capture collects the text of an element until its end tag.
+type capture struct {
+	element string
+	depth   int
+	text    *textBuilder
+	done    func(string)
+}
+
+// ExtractStructure reads the pull quotes, image captions, linked content, external links and headings of a body.
+// They are listed in the order they appear, the texts as they are and the links and UUIDs once.
+func ExtractStructure(body string) Structure {
+	var s Structure
+	linked := map[string]bool{}
+	external := map[string]bool{}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	tokenizer.AllowCDATA(true)
+	var captures []*capture
+	inScript := false
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return s
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if token.Data == "script" {
+				inScript = tokenType == html.StartTagToken
+				continue
+			}
+			if id := linkedContentID(token); id != "" && !linked[id] {
+				linked[id] = true
+				s.LinkedContentIDs = append(s.LinkedContentIDs, id)
+			}
+			if link := externalLink(token); link != "" && !external[link] {
+				external[link] = true
+				s.ExternalLinks = append(s.ExternalLinks, link)
+			}
+			for _, c := range captures {
+				boundary(c.text, token.Data)
+			}
+			if tokenType == html.SelfClosingTagToken || voidElements[token.Data] {
+				continue
+			}
+			for _, c := range captures {
+				if c.element == token.Data {
+					c.depth++
+				}
+			}
+			if done := s.collector(token.Data); done != nil {
+				captures = append(captures, &capture{element: token.Data, depth: 1, text: &textBuilder{}, done: done})
+			}
+		case html.EndTagToken:
+			if token.Data == "script" {
+				inScript = false
+				continue
+			}
+			open := captures[:0]
+			for _, c := range captures {
+				if token.Data != "br" {
+					boundary(c.text, token.Data)
+				}
+				if c.element == token.Data {
+					c.depth--
+				}
+				if c.depth > 0 {
+					open = append(open, c)
+					continue
+				}
+				if text := c.text.String(); text != "" {
+					c.done(text)
+				}
+			}
+			captures = open
+		case html.TextToken:
+			if inScript {
+				continue
+			}
+			for _, c := range captures {
+				c.text.write(token.Data)
+			}
+		}
+	}
+}
+
+// collector returns where the text of the element goes when it is captured.
+func (s *Structure) collector(element string) func(string) {
+	switch {
+	case element == "pull-quote-text":
+		return func(text string) { s.PullQuotes = append(s.PullQuotes, text) }
+	case element == "figcaption":
+		return func(text string) { s.ImageCaptions = append(s.ImageCaptions, text) }
+	case headingElements[element]:
+		return func(text string) { s.Headings = append(s.Headings, text) }
+	}
+	return nil
+}
+
+func linkedContentID(token html.Token) string {
+	if token.Data != "ft-content" && token.Data != "content" {
+		return ""
+	}
+	var id string
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "data-embedded":
+			if attr.Val == "true" {
+				return ""
+			}
+		case "url":
+			if id == "" {
+				id = path.Base(attr.Val)
+			}
+		case "id":
+			id = attr.Val
+		}
+	}
+	if !uuidRegex.MatchString(id) {
+		return ""
+	}
+	return strings.ToLower(id)
+}
+
+func externalLink(token html.Token) string {
+	if token.Data != "a" {
+		return ""
+	}
+	for _, attr := range token.Attr {
+		if attr.Key != "href" {
+			continue
+		}
+		link, err := url.Parse(strings.TrimSpace(attr.Val))
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+			return ""
+		}
+		host := strings.ToLower(link.Hostname())
+		if host == "ft.com" || strings.HasSuffix(host, ".ft.com") {
+			return ""
+		}
+		return link.String()
+	}
+	return ""
+}
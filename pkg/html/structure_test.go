@@ -0,0 +1,35 @@
+package html
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractStructure(t *testing.T) {
+	body := `<body><content data-embedded="true" id="aae9611e-f66c-4fe4-a6c6-2e2bdea69060" type="http://www.ft.com/ontology/content/ImageSet"></content>
+<h2>First <em>heading</em></h2>
+<p>Nam <ft-content url="http://api.ft.com/content/396d9102-9845-4ce2-8783-49b73f8f1302" type="http://www.ft.com/ontology/content/Article">scelerisque</ft-content>
+and <content id="C71EFED9-FE5A-488D-9F47-20C15D177153" type="http://www.ft.com/ontology/content/Article">luctus</content>,
+again <ft-content url="http://api.ft.com/content/396d9102-9845-4ce2-8783-49b73f8f1302" type="http://www.ft.com/ontology/content/Article">scelerisque</ft-content>.</p>
+<pull-quote><pull-quote-text><p>Maecenas ac ipsum.</p><p>Proin felis metus.</p></pull-quote-text><pull-quote-source>Pellentesque habitant</pull-quote-source></pull-quote>
+<figure><img src="https://www.example.com/image.png"/><figcaption>Traffic on the M4 &amp; the M25</figcaption></figure>
+<p><a href="https://www.example.com/report?a=1">report</a>, <a href="https://www.ft.com/content/c71efed9-fe5a-488d-9f47-20c15d177153">ft.com</a>,
+<a href="mailto:desk@example.com">mail</a>, <a href="https://www.example.com/report?a=1">again</a></p>
+<script>var h = "<h3>not a heading</h3>";</script>
+<h3></h3>
+</body>`
+
+	assert.Equal(t, Structure{
+		PullQuotes:       []string{"Maecenas ac ipsum.\n\nProin felis metus."},
+		ImageCaptions:    []string{"Traffic on the M4 & the M25"},
+		LinkedContentIDs: []string{"396d9102-9845-4ce2-8783-49b73f8f1302", "c71efed9-fe5a-488d-9f47-20c15d177153"},
+		ExternalLinks:    []string{"https://www.example.com/report?a=1"},
+		Headings:         []string{"First heading"},
+	}, ExtractStructure(body))
+}
+
+func TestExtractStructureOfFlatBody(t *testing.T) {
+	assert.Equal(t, Structure{}, ExtractStructure("<body><p>Just text.</p></body>"))
+	assert.Equal(t, Structure{}, ExtractStructure(""))
+}
@@ -12,7 +12,9 @@ import (
 	"github.com/Financial-Times/go-logger/v2"
 )
 
-func StartServer(log *logger.UPPLogger, serveMux *http.ServeMux, port string) {
+// StartServer serves until SIGINT or SIGTERM is received. onShutdown, when set, is called as soon as the signal
+// is received, before the server is stopped.
+func StartServer(log *logger.UPPLogger, serveMux *http.ServeMux, port string, onShutdown func()) {
 	server := &http.Server{Addr: ":" + port, Handler: serveMux}
 
 	var wg sync.WaitGroup
@@ -26,6 +28,9 @@ func StartServer(log *logger.UPPLogger, serveMux *http.ServeMux, port string) {
 
 	waitForSignal()
 	log.Info("[Shutdown] Application is shutting down")
+	if onShutdown != nil {
+		onShutdown()
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,135 @@
+package language
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The languages detected. Only English, Spanish and Chinese have analyzers of their own in the reference schema,
+// the others are detected so that they are not mistaken for them.
+const (
+	Unknown    = ""
+	English    = "en"
+	Spanish    = "es"
+	Chinese    = "zh"
+	French     = "fr"
+	Portuguese = "pt"
+	German     = "de"
+)
+
+const (
+	// minStopwords a text must contain to be told apart, shorter texts are of an unknown language
+	minStopwords = 3
+	// minStopwordShare of the words of a text, below it the stopwords are rather borrowed words or names
+	minStopwordShare = 0.1
+	// hanRatio is the share of the letters of a Chinese text written in Han characters
+	hanRatio = 0.3
+	// kanaRatio is the share of the letters of a Japanese text written in kana, it is told apart from Chinese by it
+	kanaRatio = 0.1
+	// sampleSize is the number of bytes at the beginning of a text the language is detected from, enough to tell it
+	// while keeping long bodies cheap
+	sampleSize = 4 * 1024
+)
+
+// analysed are the languages whose body is indexed with an analyzer of their own.
+var analysed = map[string]bool{English: true, Spanish: true, Chinese: true}
+
+// stopwords are frequent words of each language written in the Latin script, picked not to be shared by them.
+var stopwords = map[string]map[string]bool{
+	English: set("the", "and", "of", "to", "is", "that", "for", "it", "with", "was", "on", "are", "be", "by", "this",
+		"have", "from", "at", "which", "has", "but", "not", "were", "their", "they", "its", "been", "will", "would",
+		"said", "who", "than", "or"),
+	Spanish: set("el", "los", "las", "del", "y", "su", "sus", "al", "más", "pero", "ya", "fue", "también", "cuando",
+		"muy", "sin", "según", "hay", "dijo", "esta", "lo", "una", "con", "por"),
+	French: set("le", "les", "des", "du", "et", "est", "une", "dans", "qui", "pas", "sur", "au", "aux", "ce", "il",
+		"avec", "sont", "été", "cette", "mais", "ont", "leur", "ou", "plus"),
+	Portuguese: set("os", "da", "do", "das", "dos", "não", "uma", "em", "ao", "é", "foi", "mais", "mas", "também",
+		"pelo", "pela", "seu", "sua", "já", "tem", "são"),
+	German: set("der", "die", "und", "ist", "nicht", "mit", "sich", "auf", "ein", "eine", "dem", "den", "zu", "von",
+		"für", "auch", "wird", "sind", "hat", "im", "werden", "nach", "bei"),
+}
+
+func set(words ...string) map[string]bool {
+	s := make(map[string]bool, len(words))
+	for _, word := range words {
+		s[word] = true
+	}
+	return s
+}
+
+// Detect tells the language of a text offline: Chinese by the share of Han characters, the languages written in the
+// Latin script by their stopwords. Only the beginning of long texts is looked at. It returns Unknown when the text is
+// too short or the languages can't be told apart.
+func Detect(text string) string {
+	text = sample(text)
+	var letters, han, kana int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case !unicode.IsLetter(r):
+			continue
+		}
+		letters++
+	}
+	if letters == 0 {
+		return Unknown
+	}
+	if float64(kana) >= kanaRatio*float64(letters) {
+		return Unknown
+	}
+	if float64(han) >= hanRatio*float64(letters) {
+		return Chinese
+	}
+
+	counts := make(map[string]int, len(stopwords))
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		for language, s := range stopwords {
+			if s[word] {
+				counts[language]++
+			}
+		}
+	}
+	best, bestCount, secondCount := Unknown, 0, 0
+	for language, count := range counts {
+		switch {
+		case count > bestCount:
+			best, bestCount, secondCount = language, count, bestCount
+		case count > secondCount:
+			secondCount = count
+		}
+	}
+	// the language has to stand out, as quotes and names of other languages are common
+	if bestCount < minStopwords || float64(bestCount) < minStopwordShare*float64(len(words)) || bestCount < 2*secondCount {
+		return Unknown
+	}
+	return best
+}
+
+// HasAnalyzer tells whether the body of the language is indexed with an analyzer of its own.
+func HasAnalyzer(language string) bool {
+	return analysed[language]
+}
+
+// sample cuts the text to at most sampleSize bytes, at the last space so that no word is cut, or at the last rune
+// boundary in texts written without spaces.
+func sample(text string) string {
+	if len(text) <= sampleSize {
+		return text
+	}
+	cut := sampleSize
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	text = text[:cut]
+	if space := strings.LastIndexFunc(text, unicode.IsSpace); space > 0 {
+		return text[:space]
+	}
+	return text
+}
@@ -0,0 +1,101 @@
+package language
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		language string
+	}{
+		{
+			name:     "english",
+			text:     "The Bank of England said on Thursday that it would keep rates on hold, as inflation was expected to fall.",
+			language: English,
+		},
+		{
+			name:     "spanish",
+			text:     "El Banco de España dijo el jueves que la inflación bajará este año, según las previsiones publicadas por la entidad.",
+			language: Spanish,
+		},
+		{
+			name:     "english with a spanish quote",
+			text:     "The minister said that the plan, known as «el plan de la economía», was not going to be changed by the government this year.",
+			language: English,
+		},
+		{
+			name:     "french",
+			text:     "Le gouvernement a annoncé que la réforme des retraites sera présentée en conseil des ministres avec les syndicats.",
+			language: French,
+		},
+		{
+			name:     "portuguese",
+			text:     "O banco central do Brasil manteve a taxa de juros, mas não descartou uma alta nos próximos meses, segundo o comunicado.",
+			language: Portuguese,
+		},
+		{
+			name:     "latin placeholder",
+			text:     "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.",
+			language: Unknown,
+		},
+		{
+			name:     "chinese",
+			text:     "中国人民银行周四表示，将继续实施稳健的货币政策，保持流动性合理充裕。Reuters",
+			language: Chinese,
+		},
+		{
+			name:     "japanese",
+			text:     "日本銀行は木曜日、金融政策を据え置くと発表した。",
+			language: Unknown,
+		},
+		{
+			name:     "too short",
+			text:     "Brexit talks",
+			language: Unknown,
+		},
+		{
+			name:     "empty",
+			text:     "",
+			language: Unknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.language, Detect(test.text))
+		})
+	}
+}
+
+func TestDetectLooksAtTheBeginningOfLongTexts(t *testing.T) {
+	english := strings.Repeat("The Bank of England said that it would keep rates on hold. ", sampleSize/50)
+	spanish := strings.Repeat("El Banco de España dijo que la inflación bajará este año, según las previsiones. ", 10*sampleSize/50)
+
+	assert.Equal(t, English, Detect(english+spanish))
+}
+
+func TestSample(t *testing.T) {
+	chinese := strings.Repeat("中国人民银行", sampleSize)
+	sampled := sample(chinese)
+	assert.True(t, utf8.ValidString(sampled))
+	assert.True(t, len(sampled) <= sampleSize && len(sampled) > sampleSize-utf8.UTFMax)
+
+	sampled = sample(strings.Repeat("inflation ", sampleSize))
+	assert.True(t, len(sampled) <= sampleSize)
+	assert.True(t, strings.HasSuffix(sampled, " inflation"), "a word was cut")
+	assert.Equal(t, "short text", sample("short text"))
+}
+
+func TestHasAnalyzer(t *testing.T) {
+	assert.True(t, HasAnalyzer(English))
+	assert.True(t, HasAnalyzer(Spanish))
+	assert.True(t, HasAnalyzer(Chinese))
+	assert.False(t, HasAnalyzer(French))
+	assert.False(t, HasAnalyzer(Unknown))
+}
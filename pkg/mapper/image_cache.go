@@ -0,0 +1,81 @@
+package mapper
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultImageCacheSize = 10000
+	DefaultImageCacheTTL  = 24 * time.Hour
+)
+
+// ImageCache keeps the image of each image set looked up from internal-content-api, the least recently used ones are evicted first.
+// Image sets are shared by many pieces of content, e.g. the ones of columnists and series.
+type ImageCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type imageEntry struct {
+	imageSetUUID string
+	imageUUID    string
+	expires      time.Time
+}
+
+// NewImageCache caches up to size image sets for ttl, a size of 0 disables the cache.
+func NewImageCache(size int, ttl time.Duration) *ImageCache {
+	return &ImageCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *ImageCache) Get(imageSetUUID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, found := c.entries[imageSetUUID]
+	if !found {
+		return "", false
+	}
+	entry := element.Value.(*imageEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(element)
+		return "", false
+	}
+	c.lru.MoveToFront(element)
+	return entry.imageUUID, true
+}
+
+func (c *ImageCache) Put(imageSetUUID string, imageUUID string) {
+	if c.size <= 0 || c.ttl <= 0 || imageSetUUID == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &imageEntry{imageSetUUID: imageSetUUID, imageUUID: imageUUID, expires: c.now().Add(c.ttl)}
+	if element, cached := c.entries[imageSetUUID]; cached {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[imageSetUUID] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *ImageCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*imageEntry).imageSetUUID)
+}
@@ -0,0 +1,38 @@
+package mapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageCacheEvictsLeastRecentlyUsedAndExpired(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewImageCache(2, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	cache.Put("set-1", "image-1")
+	cache.Put("set-2", "image-2")
+	_, _ = cache.Get("set-1")
+	cache.Put("set-3", "image-3")
+
+	imageUUID, found := cache.Get("set-1")
+	assert.True(t, found)
+	assert.Equal(t, "image-1", imageUUID)
+	_, found = cache.Get("set-2")
+	assert.False(t, found)
+
+	now = now.Add(time.Hour)
+	_, found = cache.Get("set-3")
+	assert.False(t, found)
+}
+
+func TestImageCacheDisabled(t *testing.T) {
+	cache := NewImageCache(0, time.Hour)
+
+	cache.Put("set-1", "image-1")
+
+	_, found := cache.Get("set-1")
+	assert.False(t, found)
+}
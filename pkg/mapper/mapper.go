@@ -1,9 +1,11 @@
 package mapper
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,14 +15,14 @@ import (
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/concept"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/html"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/language"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/schema"
 )
 
 const (
-	webURLPrefix     = "https://www.ft.com/content/"
-	apiURLPrefix     = "/content/"
-	imageServiceURL  = "https://www.ft.com/__origami/service/image/v2/images/raw/http%3A%2F%2Fprod-upp-image-read.ft.com%2F[image_uuid]?source=search&fit=scale-down&width=167"
-	imagePlaceholder = "[image_uuid]"
+	webURLPrefix = "https://www.ft.com/content/"
+	apiURLPrefix = "/content/"
 
 	tmeOrganisations = "ON"
 	tmePeople        = "PN"
@@ -31,10 +33,23 @@ const (
 	videoPrefix = "video"
 )
 
+const (
+	DefaultConcordanceTimeout     = 10 * time.Second
+	DefaultInternalContentTimeout = 5 * time.Second
+)
+
+// Timeouts bound the lookups of a single mapping, a timeout of 0 leaves the lookup bound by its context only.
+type Timeouts struct {
+	Concordances    time.Duration
+	InternalContent time.Duration
+}
+
 type Handler struct {
 	ConceptReader  concept.Reader
 	BaseAPIURL     string
 	Config         config.AppConfig
+	Images         *ImageCache
+	Timeouts       Timeouts
 	log            *logger.UPPLogger
 	internalClient *internalcontent.ContentClient
 }
@@ -42,31 +57,51 @@ type Handler struct {
 var errNoAnnotation = errors.New("no annotation to be processed")
 
 func NewMapperHandler(reader concept.Reader, baseAPIURL string, appConfig config.AppConfig, logger *logger.UPPLogger, internalClient *internalcontent.ContentClient) *Handler {
-	return &Handler{
+	h := &Handler{
 		ConceptReader:  reader,
-		BaseAPIURL:     baseAPIURL,
 		Config:         appConfig,
+		Images:         NewImageCache(DefaultImageCacheSize, DefaultImageCacheTTL),
+		Timeouts:       Timeouts{Concordances: DefaultConcordanceTimeout, InternalContent: DefaultInternalContentTimeout},
 		log:            logger,
 		internalClient: internalClient,
 	}
+	h.SetBaseAPIURL(baseAPIURL)
+	return h
 }
 
-func (h *Handler) ToIndexModel(enrichedContent schema.EnrichedContent, contentType string, tid string) schema.IndexModel {
+// SetBaseAPIURL sets the base of the API URLs of the documents, always served over https.
+// It must not be called while content is mapped, ToIndexModel only reads the handler.
+func (h *Handler) SetBaseAPIURL(baseAPIURL string) {
+	if strings.HasPrefix(baseAPIURL, "http://") {
+		baseAPIURL = strings.Replace(baseAPIURL, "http", "https", 1)
+	}
+	h.BaseAPIURL = baseAPIURL
+}
+
+// ToIndexModel maps the event to the indexed document. Lookups that time out or are cancelled with ctx are
+// handled like the ones that failed, the document is flagged with lookupFailure when concordances are missing.
+func (h *Handler) ToIndexModel(ctx context.Context, enrichedContent schema.EnrichedContent, contentType string, tid string) schema.IndexModel {
+	defer metrics.ObserveMapper(time.Now())
 	model := schema.IndexModel{}
 
-	if strings.HasPrefix(h.BaseAPIURL, "http://") {
-		h.BaseAPIURL = strings.Replace(h.BaseAPIURL, "http", "https", 1)
-	}
-	h.populateContentRelatedFields(&model, enrichedContent, contentType, tid)
+	h.populateContentRelatedFields(ctx, &model, enrichedContent, contentType, tid)
+	h.populateEditorsTags(&model, enrichedContent)
 
-	annotations, concepts, err := h.prepareAnnotationsWithConcepts(&enrichedContent, tid)
+	annotations, concepts, err := h.prepareAnnotationsWithConcepts(ctx, &enrichedContent, tid)
 	log := h.log.WithTransactionID(tid).WithUUID(enrichedContent.UUID)
-	if err != nil {
-		if err == errNoAnnotation {
-			log.Warn(err.Error())
-		} else {
-			log.WithError(err).Error(err)
-		}
+	var partial *concept.PartialError
+	switch {
+	case err == nil:
+	case errors.As(err, &partial):
+		// the annotations whose concordances were looked up are still indexed, the document is flagged to be re-enriched
+		log.WithError(err).Warn("Concordances could only be partially looked up")
+		model.LookupFailure = true
+	case err == errNoAnnotation:
+		log.Warn(err.Error())
+		return model
+	default:
+		log.WithError(err).Error(err)
+		model.LookupFailure = true
 		return model
 	}
 
@@ -84,13 +119,60 @@ func (h *Handler) ToIndexModel(enrichedContent schema.EnrichedContent, contentTy
 			log.Warnf("TME id missing for concept with id %s, using only canonical id", canonicalID)
 		}
 
-		h.populateAnnotationRelatedFields(annotation, &model, annIDs, canonicalID)
+		h.populateAnnotationRelatedFields(annotation, &model, annIDs, canonicalID, concepts)
 	}
 	return model
 }
 
-func (h *Handler) populateAnnotationRelatedFields(annotation schema.Thing, model *schema.IndexModel, annIDs []string, canonicalID string) {
+// populateEditorsTags indexes the display tags, they are not looked up in the concordances.
+func (h *Handler) populateEditorsTags(model *schema.IndexModel, enrichedContent schema.EnrichedContent) {
+	hasDisplayTag := h.Config.Predicates.Get("hasDisplayTag")
+	for _, a := range enrichedContent.Metadata {
+		if a.Thing.Predicate == hasDisplayTag && a.Thing.PrefLabel != "" {
+			model.EditorsTags = appendIfNotExists(model.EditorsTags, a.Thing.PrefLabel)
+		}
+	}
+}
+
+// populateAutoFields indexes the companies and organisations of the annotations not added by editors, like the implicit ones.
+func (h *Handler) populateAutoFields(annotation schema.Thing, model *schema.IndexModel, concepts concept.Model) {
+	conceptTypes := h.Config.ConceptTypes
+	for _, taxonomy := range annotation.Types {
+		switch taxonomy {
+		case conceptTypes.Get("organisation"):
+			model.OrganisationNamesAuto = appendIfNotExists(model.OrganisationNamesAuto, annotation.PrefLabel)
+		case conceptTypes.Get("company"):
+			model.CompanyNamesAuto = appendIfNotExists(model.CompanyNamesAuto, annotation.PrefLabel)
+			model.CompanyTickerCodeAuto = prepareElasticField(model.CompanyTickerCodeAuto, h.tickerCodes(concepts))
+		}
+	}
+}
+
+// populateEditorialFields indexes the companies of the annotations added by editors.
+func (h *Handler) populateEditorialFields(annotation schema.Thing, model *schema.IndexModel, concepts concept.Model) {
+	for _, taxonomy := range annotation.Types {
+		if taxonomy == h.Config.ConceptTypes.Get("company") {
+			model.CompanyNamesEditorial = appendIfNotExists(model.CompanyNamesEditorial, annotation.PrefLabel)
+			model.CompanyTickerCodeEditorial = prepareElasticField(model.CompanyTickerCodeEditorial, h.tickerCodes(concepts))
+		}
+	}
+}
+
+func (h *Handler) tickerCodes(concepts concept.Model) []string {
+	var codes []string
+	for _, authority := range h.Config.FieldRules.TickerAuthorities {
+		codes = append(codes, concepts.Identifiers[authority]...)
+	}
+	return codes
+}
+
+func (h *Handler) populateAnnotationRelatedFields(annotation schema.Thing, model *schema.IndexModel, annIDs []string, canonicalID string, concepts concept.Model) {
 	h.handleSectionMapping(annotation, model, annIDs)
+	if contains(h.Config.FieldRules.EditorialPredicates, annotation.Predicate) {
+		h.populateEditorialFields(annotation, model, concepts)
+	} else {
+		h.populateAutoFields(annotation, model, concepts)
+	}
 
 	about := h.Config.Predicates.Get("about")
 	hasAuthor := h.Config.Predicates.Get("hasAuthor")
@@ -128,6 +210,9 @@ func (h *Handler) populateAnnotationRelatedFields(annotation schema.Thing, model
 		case conceptTypes.Get("brand"):
 			model.CmrBrands = appendIfNotExists(model.CmrBrands, annotation.PrefLabel)
 			model.CmrBrandsIds = prepareElasticField(model.CmrBrandsIds, annIDs)
+			if contains(h.Config.FieldRules.ArticleBrandPredicates, annotation.Predicate) {
+				model.ArticleBrands = appendIfNotExists(model.ArticleBrands, annotation.PrefLabel)
+			}
 		case conceptTypes.Get("topic"):
 			model.CmrTopics = appendIfNotExists(model.CmrTopics, annotation.PrefLabel)
 			model.CmrTopicsIds = prepareElasticField(model.CmrTopicsIds, annIDs)
@@ -143,16 +228,23 @@ func (h *Handler) populateAnnotationRelatedFields(annotation schema.Thing, model
 		case conceptTypes.Get("genre"):
 			model.CmrGenres = appendIfNotExists(model.CmrGenres, annotation.PrefLabel)
 			model.CmrGenreIds = prepareElasticField(model.CmrGenreIds, annIDs)
+			model.ArticleTypes = appendIfNotExists(model.ArticleTypes, annotation.PrefLabel)
+		case conceptTypes.Get("specialReport"):
+			model.CmrSpecialreports = appendIfNotExists(model.CmrSpecialreports, annotation.PrefLabel)
+			model.CmrSpecialreportsIds = prepareElasticField(model.CmrSpecialreportsIds, annIDs)
+		case conceptTypes.Get("subject"):
+			model.CmrSubjects = appendIfNotExists(model.CmrSubjects, annotation.PrefLabel)
+			model.CmrSubjectsIds = prepareElasticField(model.CmrSubjectsIds, annIDs)
 		}
 	}
 }
 
-func (h *Handler) prepareAnnotationsWithConcepts(enrichedContent *schema.EnrichedContent, tid string) ([]schema.Thing, map[string]concept.Model, error) {
+func (h *Handler) prepareAnnotationsWithConcepts(ctx context.Context, enrichedContent *schema.EnrichedContent, tid string) ([]schema.Thing, map[string]concept.Model, error) {
 	var ids []string
 	var anns []schema.Thing
 	for _, a := range enrichedContent.Metadata {
 		if a.Thing.Predicate == h.Config.Predicates.Get("mentions") || a.Thing.Predicate == h.Config.Predicates.Get("hasDisplayTag") {
-			// ignore these annotations
+			// ignore these annotations, display tags are indexed as editors tags without concordances
 			continue
 		}
 		ids = append(ids, a.Thing.ID)
@@ -163,11 +255,13 @@ func (h *Handler) prepareAnnotationsWithConcepts(enrichedContent *schema.Enriche
 		return nil, nil, errNoAnnotation
 	}
 
-	concepts, err := h.ConceptReader.GetConcepts(tid, ids)
+	ctx, cancel := withTimeout(ctx, h.Timeouts.Concordances)
+	defer cancel()
+	concepts, err := h.ConceptReader.GetConcepts(ctx, tid, ids)
 	return anns, concepts, err
 }
 
-func (h *Handler) populateContentRelatedFields(model *schema.IndexModel, enrichedContent schema.EnrichedContent, contentType string, tid string) {
+func (h *Handler) populateContentRelatedFields(ctx context.Context, model *schema.IndexModel, enrichedContent schema.EnrichedContent, contentType string, tid string) {
 	model.IndexDate = new(string)
 	*model.IndexDate = time.Now().UTC().Format("2006-01-02T15:04:05.999Z")
 	model.ContentType = new(string)
@@ -178,19 +272,21 @@ func (h *Handler) populateContentRelatedFields(model *schema.IndexModel, enriche
 	*model.Category = h.Config.ESContentTypeMetadataMap.Get(contentType).Category
 	model.Format = new(string)
 	*model.Format = h.Config.ESContentTypeMetadataMap.Get(contentType).Format
+	if mediaType := h.Config.ESContentTypeMetadataMap.Get(contentType).MediaType; mediaType != "" {
+		model.CmrMediatype = &mediaType
+	}
+	if providerName := h.providerName(enrichedContent); providerName != "" {
+		model.ProviderName = &providerName
+	}
+	if enrichedContent.LastModified != "" {
+		model.LastMetadataPublish = &(enrichedContent.LastModified)
+	}
 	model.UID = &(enrichedContent.Content.UUID)
+	pipelines := h.Config.TextPipelines.Get(contentType)
 	model.LeadHeadline = new(string)
-	*model.LeadHeadline = html.TransformText(enrichedContent.Content.Title,
-		html.EntityTransformer,
-		html.TagsRemover,
-		html.OuterSpaceTrimmer,
-		html.DuplicateWhiteSpaceRemover)
+	*model.LeadHeadline = pipelines.Headline.Transform(enrichedContent.Content.Title)
 	model.Byline = new(string)
-	*model.Byline = html.TransformText(enrichedContent.Content.Byline,
-		html.EntityTransformer,
-		html.TagsRemover,
-		html.OuterSpaceTrimmer,
-		html.DuplicateWhiteSpaceRemover)
+	*model.Byline = pipelines.Byline.Transform(enrichedContent.Content.Byline)
 	if enrichedContent.Content.PublishedDate != "" {
 		model.LastPublish = &(enrichedContent.Content.PublishedDate)
 	}
@@ -199,19 +295,27 @@ func (h *Handler) populateContentRelatedFields(model *schema.IndexModel, enriche
 	}
 	model.Body = new(string)
 	if enrichedContent.Content.Body != "" {
-		*model.Body = html.TransformText(enrichedContent.Content.Body,
-			html.InteractiveGraphicsMarkupTagRemover,
-			html.PullTagTransformer,
-			html.EntityTransformer,
-			html.ScriptTagRemover,
-			html.TagsRemover,
-			html.OuterSpaceTrimmer,
-			html.Embed1Replacer,
-			html.SquaredCaptionReplacer,
-			html.DuplicateWhiteSpaceRemover)
+		*model.Body = pipelines.Body.Transform(enrichedContent.Content.Body)
 	} else {
 		*model.Body = enrichedContent.Content.Description
 	}
+	if enrichedContent.Content.Body != "" {
+		structure := html.ExtractStructure(enrichedContent.Content.Body)
+		model.PullQuotes = structure.PullQuotes
+		model.ImageCaptions = structure.ImageCaptions
+		model.LinkedContentIDs = structure.LinkedContentIDs
+		model.ExternalLinks = structure.ExternalLinks
+		model.Headings = structure.Headings
+
+		// content without a body is not detected, the description is too short to tell its language
+		if bodyLanguage := language.Detect(*model.Body); bodyLanguage != language.Unknown {
+			model.Language = &bodyLanguage
+			// the body is indexed again with the analyzer of its language, when the reference schema declares one
+			if language.HasAnalyzer(bodyLanguage) {
+				model.LanguageBody = map[string]string{bodyLanguage: *model.Body}
+			}
+		}
+	}
 
 	model.Scoop = enrichedContent.Content.Scoop
 	model.CanBeSyndicated = enrichedContent.Content.CanBeSyndicated
@@ -220,22 +324,11 @@ func (h *Handler) populateContentRelatedFields(model *schema.IndexModel, enriche
 	model.ShortDescription = new(string)
 	*model.ShortDescription = enrichedContent.Content.Standfirst
 
-	if contentType != config.BlogType && enrichedContent.Content.MainImage != "" {
+	mainImage := enrichedContent.Content.MainImage
+	if contentType != config.BlogType && (mainImage.UUID != "" || len(mainImage.ImageUUIDs) > 0) {
 		model.ThumbnailURL = new(string)
-
-		log := h.log.WithTransactionID(tid).WithUUID(enrichedContent.UUID)
-
-		ic, err := h.internalClient.GetContent(enrichedContent.UUID, true)
-		if err != nil || len(ic.MainImage.Members) == 0 || ic.MainImage.Members[0].APIURL == "" {
-			log.WithError(err).Warnf("Couldn't get image UUID from %s", internalcontent.URLInternalContent)
-		} else {
-			uris := strings.Split(ic.MainImage.Members[0].APIURL, "/")
-			if len(uris) > 0 {
-				imageUUID := uris[len(uris)-1]
-				*model.ThumbnailURL = strings.Replace(imageServiceURL, imagePlaceholder, imageUUID, -1)
-			} else {
-				log.WithError(err).Warnf("Couldn't get image UUID from %s", internalcontent.URLInternalContent)
-			}
+		if imageUUID, found := h.imageUUID(ctx, enrichedContent, tid); found {
+			*model.ThumbnailURL = strings.Replace(h.Config.ImageServiceURL, config.ImagePlaceholder, imageUUID, -1)
 		}
 	}
 
@@ -262,6 +355,83 @@ func (h *Handler) populateContentRelatedFields(model *schema.IndexModel, enriche
 	model.PublishReference = tid
 }
 
+// providerName is the name of the system the content was published from, told by the authority of its identifiers.
+// The content metadata are compared in the order of their names, so that the same one wins if several share an authority.
+func (h *Handler) providerName(enrichedContent schema.EnrichedContent) string {
+	names := make([]string, 0, len(h.Config.ContentMetadataMap))
+	for name := range h.Config.ContentMetadataMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, identifier := range enrichedContent.Content.Identifiers {
+		for _, name := range names {
+			metadata := h.Config.ContentMetadataMap[name]
+			if metadata.Authority != "" && identifier.Authority == metadata.Authority {
+				return metadata.ProviderName
+			}
+		}
+	}
+	return ""
+}
+
+// imageUUID resolves the first image of the main image set. Expanded events carry it, otherwise it is looked up
+// from internal-content-api, once per image set.
+func (h *Handler) imageUUID(ctx context.Context, enrichedContent schema.EnrichedContent, tid string) (string, bool) {
+	mainImage := enrichedContent.Content.MainImage
+	if len(mainImage.ImageUUIDs) > 0 {
+		return mainImage.ImageUUIDs[0], true
+	}
+	if imageUUID, found := h.Images.Get(mainImage.UUID); found {
+		return imageUUID, true
+	}
+
+	start := time.Now()
+	ic, err := h.getContent(ctx, enrichedContent.UUID)
+	metrics.ObserveInternalContent(start)
+	if err != nil || len(ic.MainImage.Members) == 0 || ic.MainImage.Members[0].APIURL == "" {
+		h.log.WithTransactionID(tid).WithUUID(enrichedContent.UUID).WithError(err).Warnf("Couldn't get image UUID from %s", internalcontent.URLInternalContent)
+		return "", false
+	}
+	uris := strings.Split(ic.MainImage.Members[0].APIURL, "/")
+	imageUUID := uris[len(uris)-1]
+	h.Images.Put(mainImage.UUID, imageUUID)
+	return imageUUID, true
+}
+
+type contentResult struct {
+	content *internalcontent.Content
+	err     error
+}
+
+// getContent gives up on internal-content-api when ctx is done or the timeout elapses, the client can't cancel
+// its requests so an abandoned one completes in the background.
+func (h *Handler) getContent(ctx context.Context, uuid string) (*internalcontent.Content, error) {
+	ctx, cancel := withTimeout(ctx, h.Timeouts.InternalContent)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	done := make(chan contentResult, 1)
+	go func() {
+		content, err := h.internalClient.GetContent(uuid, true)
+		done <- contentResult{content: content, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.content, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// withTimeout bounds ctx with timeout, unless it is 0.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func prepareElasticField(elasticField []string, annIDs []string) []string {
 	for _, id := range annIDs {
 		elasticField = appendIfNotExists(elasticField, id)
@@ -331,3 +501,12 @@ func appendIfNotExists(s []string, e string) []string {
 	}
 	return append(s, e)
 }
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,12 @@
 package mapper
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,6 +20,7 @@ import (
 
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/concept"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/html"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/schema"
 	tst "github.com/Financial-Times/content-rw-elasticsearch/v2/test"
 )
@@ -25,11 +29,19 @@ type concordanceAPIMock struct {
 	mock.Mock
 }
 
-func (m *concordanceAPIMock) GetConcepts(tid string, ids []string) (map[string]concept.Model, error) {
+func (m *concordanceAPIMock) GetConcepts(ctx context.Context, tid string, ids []string) (map[string]concept.Model, error) {
 	args := m.Called(tid, ids)
 	return args.Get(0).(map[string]concept.Model), args.Error(1)
 }
 
+// blockingReader answers once the lookup is cancelled, like a Concordance API that doesn't answer in time.
+type blockingReader struct{}
+
+func (blockingReader) GetConcepts(ctx context.Context, tid string, ids []string) (map[string]concept.Model, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
 var mainImageContent = `{"mainImage": {
         "apiUrl": "https://test.api.ft.com/content/ad038207-bfe6-4805-a04c-864af12efef2",
         "description": "Traffic on the M4 motorway near Datchet, Berkshire, on Monday",
@@ -150,7 +162,7 @@ func TestConvertToESContentModel(t *testing.T) {
 
 		milliseconds := int64(time.Millisecond)
 		startTime := time.Now().UnixNano() / milliseconds
-		esModel := mapperHandler.ToIndexModel(ecModel, test.contentType, test.tid)
+		esModel := mapperHandler.ToIndexModel(context.Background(), ecModel, test.contentType, test.tid)
 
 		endTime := time.Now().UnixNano() / milliseconds
 
@@ -182,3 +194,453 @@ func TestCmrID(t *testing.T) {
 	expect.True(found, "CMR ID is not composed from the expected taxonomy")
 	expect.Equal("NzE0ZThkZGItNDAyMC00MDRjLTlkNzMtY2I5MzRmZDVhOWM2-T04=", cmrID, "Wrong CMR ID")
 }
+
+func TestLookupFailureIsFlagged(t *testing.T) {
+	var concordances concept.ConcordancesResponse
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleConcordanceResponse.json"), &concordances))
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	var expected schema.IndexModel
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleElasticModel.json"), &expected))
+
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	internalContentAPIClient := internalcontent.NewContentClient(&clientMock{}, "")
+
+	tests := []struct {
+		name          string
+		concepts      map[string]concept.Model
+		err           error
+		lookupFailure bool
+		annotated     bool
+	}{
+		{
+			name:      "all concordances looked up",
+			concepts:  concept.TransformToConceptModel(concordances),
+			annotated: true,
+		},
+		{
+			name:          "partially looked up",
+			concepts:      concept.TransformToConceptModel(concordances),
+			err:           &concept.PartialError{FailedIDs: []string{concept.ThingURIPrefix + "00000000-0000-0000-0000-000000000000"}, Chunks: 2, FailedChunks: 1, Err: errors.New("calling Concordance API returned HTTP status 503")},
+			lookupFailure: true,
+			annotated:     true,
+		},
+		{
+			name:          "not looked up",
+			concepts:      map[string]concept.Model(nil),
+			err:           errors.New("calling Concordance API returned HTTP status 503"),
+			lookupFailure: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			concordanceAPIMock := new(concordanceAPIMock)
+			concordanceAPIMock.On("GetConcepts", "tid_1", mock.AnythingOfType("[]string")).Return(test.concepts, test.err)
+			mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalContentAPIClient)
+
+			model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+
+			assert.Equal(t, test.lookupFailure, model.LookupFailure)
+			if test.annotated {
+				assert.Equal(t, expected.CmrBrands, model.CmrBrands)
+			} else {
+				assert.Empty(t, model.CmrBrands)
+			}
+		})
+	}
+}
+
+func TestLookupTimeouts(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	content.Content.MainImage = schema.MainImage{UUID: "ad038207-bfe6-4805-a04c-864af12efef2"}
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	clientAPIMock := &clientMock{
+		sendRequestF: func(req *api.Request) (*api.Response, error) {
+			time.Sleep(time.Second)
+			return &api.Response{StatusCode: http.StatusOK, Body: mainImageContent}, nil
+		},
+	}
+	mapperHandler := NewMapperHandler(blockingReader{}, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(clientAPIMock, ""))
+	mapperHandler.Timeouts = Timeouts{Concordances: 20 * time.Millisecond, InternalContent: 20 * time.Millisecond}
+
+	start := time.Now()
+	model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+
+	assert.True(t, time.Since(start) < time.Second, "the mapping waited for the lookups")
+	assert.True(t, model.LookupFailure)
+	assert.Empty(t, model.CmrBrands)
+	require.NotNil(t, model.ThumbnailURL)
+	assert.Empty(t, *model.ThumbnailURL)
+}
+
+func TestThumbnailResolution(t *testing.T) {
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	appConfig.ImageServiceURL = "https://images.example.com/[image_uuid]?width=167"
+	requests := 0
+	clientAPIMock := &clientMock{
+		sendRequestF: func(req *api.Request) (*api.Response, error) {
+			requests++
+			return &api.Response{StatusCode: http.StatusOK, Body: mainImageContent}, nil
+		},
+	}
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.Anything, mock.Anything).Return(map[string]concept.Model{}, nil)
+	mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(clientAPIMock, ""))
+
+	tests := []struct {
+		name      string
+		mainImage string
+		requests  int
+	}{
+		{
+			name:      "image set looked up from internal-content-api",
+			mainImage: `"ad038207-bfe6-4805-a04c-864af12efef2"`,
+			requests:  1,
+		},
+		{
+			name:      "image set looked up before",
+			mainImage: `"ad038207-bfe6-4805-a04c-864af12efef2"`,
+			requests:  1,
+		},
+		{
+			name:      "expanded image set",
+			mainImage: `{"id":"http://www.ft.com/thing/0f4a7c4e-0000-0000-0000-000000000000","members":[{"apiUrl":"https://api.ft.com/content/5546cbc4-d4f7-47f9-3f3e-941fb0799c4f"}]}`,
+			requests:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var content schema.EnrichedContent
+			require.NoError(t, json.Unmarshal([]byte(`{"uuid":"aae9611e-f66c-4fe4-a6c6-2e2bdea69060","content":{"uuid":"aae9611e-f66c-4fe4-a6c6-2e2bdea69060","mainImage":`+test.mainImage+`}}`), &content))
+
+			model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+
+			require.NotNil(t, model.ThumbnailURL)
+			assert.Equal(t, "https://images.example.com/5546cbc4-d4f7-47f9-3f3e-941fb0799c4f?width=167", *model.ThumbnailURL)
+			assert.Equal(t, test.requests, requests)
+		})
+	}
+}
+
+func TestBodyPipeline(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	content.Content.Body = `<body><p>First paragraph.</p><pull-quote><pull-quote-text>Quoted</pull-quote-text></pull-quote><p>Second [caption id="1"]paragraph</p></body>`
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.Anything, mock.Anything).Return(map[string]concept.Model{}, nil)
+	mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+	model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+	require.NotNil(t, model.Body)
+	assert.Equal(t, "First paragraph.Second paragraph", *model.Body)
+
+	// the steps of the extractedBody pipeline of app.yml
+	extractedBody, err := html.NewPipeline([]html.Step{
+		{Transformer: html.ExtractTransformer, Drop: html.DefaultDroppedElements},
+		{Transformer: "embeds"},
+		{Transformer: "squaredCaptions"},
+		{Transformer: "trim"},
+	})
+	require.NoError(t, err)
+	pipelines := appConfig.TextPipelines.Get(config.ArticleType)
+	pipelines.Body = extractedBody
+	mapperHandler.Config.TextPipelines = config.TextPipelinesMap{config.DefaultTextPipelines: pipelines}
+	model = mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+	require.NotNil(t, model.Body)
+	assert.Equal(t, "First paragraph.\n\nSecond paragraph", *model.Body)
+}
+
+func TestBodyStructure(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	content.Content.Body = `<body><h2>Heading</h2><p>See <ft-content url="http://api.ft.com/content/396d9102-9845-4ce2-8783-49b73f8f1302" type="http://www.ft.com/ontology/content/Article">this</ft-content> and <a href="https://www.example.com/">that</a>.</p>` +
+		`<pull-quote><pull-quote-text>Quoted</pull-quote-text></pull-quote><figure><figcaption>Caption</figcaption></figure></body>`
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.Anything, mock.Anything).Return(map[string]concept.Model{}, nil)
+	mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+	model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+
+	assert.Equal(t, []string{"Quoted"}, model.PullQuotes)
+	assert.Equal(t, []string{"Caption"}, model.ImageCaptions)
+	assert.Equal(t, []string{"396d9102-9845-4ce2-8783-49b73f8f1302"}, model.LinkedContentIDs)
+	assert.Equal(t, []string{"https://www.example.com/"}, model.ExternalLinks)
+	assert.Equal(t, []string{"Heading"}, model.Headings)
+}
+
+func TestToIndexModelConcurrently(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.Anything, mock.Anything).Return(map[string]concept.Model{}, nil)
+	mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+	// the message workers map content in parallel with the same handler
+	var wg sync.WaitGroup
+	apiURLs := make([]string, 8)
+	for i := range apiURLs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+			if model.ModelAPIURL != nil {
+				apiURLs[i] = *model.ModelAPIURL
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, apiURL := range apiURLs {
+		assert.Equal(t, "https://api.ft.com/content/aae9611e-f66c-4fe4-a6c6-2e2bdea69060", apiURL)
+	}
+}
+
+func TestBodyStructureExcludesEmbeddedContent(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	content.Content.Body = `<body><ft-content data-embedded="true" url="http://api.ft.com/content/5546cbc4-d4f7-47f9-a158-03856a0d3706" type="http://www.ft.com/ontology/content/ImageSet"></ft-content>` +
+		`<p>See <ft-content url="http://api.ft.com/content/396d9102-9845-4ce2-8783-49b73f8f1302" type="http://www.ft.com/ontology/content/Article">this</ft-content>.</p></body>`
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.Anything, mock.Anything).Return(map[string]concept.Model{}, nil)
+	mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+	model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+
+	assert.Equal(t, []string{"396d9102-9845-4ce2-8783-49b73f8f1302"}, model.LinkedContentIDs)
+}
+
+func TestBodyLanguage(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.Anything, mock.Anything).Return(map[string]concept.Model{}, nil)
+	mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+	tests := []struct {
+		name         string
+		body         string
+		description  string
+		language     string
+		languageBody map[string]string
+	}{
+		{
+			name:         "analysed language",
+			body:         "<body><p>El Banco de España dijo el jueves que la inflación bajará este año, según las previsiones.</p></body>",
+			language:     "es",
+			languageBody: map[string]string{"es": "El Banco de España dijo el jueves que la inflación bajará este año, según las previsiones."},
+		},
+		{
+			name:     "language without analyzer",
+			body:     "<body><p>Le gouvernement a annoncé que la réforme des retraites sera présentée en conseil des ministres.</p></body>",
+			language: "fr",
+		},
+		{
+			name: "unknown language",
+			body: "<body><p>Brexit talks</p></body>",
+		},
+		{
+			name:        "no body",
+			description: "The Bank of England said on Thursday that it would keep rates on hold, as inflation was expected to fall.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content.Content.Body = test.body
+			content.Content.Description = test.description
+
+			model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+
+			if test.language == "" {
+				assert.Nil(t, model.Language)
+			} else {
+				require.NotNil(t, model.Language)
+				assert.Equal(t, test.language, *model.Language)
+			}
+			assert.Equal(t, test.languageBody, model.LanguageBody)
+		})
+	}
+}
+
+func TestContentFields(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &content))
+	content.Metadata = append(content.Metadata, schema.Annotation{Thing: schema.Thing{
+		ID:        concept.ThingURIPrefix + "b5f4b7d4-9d5c-4c35-a2b4-0e2f3b8b6a59",
+		PrefLabel: "Big Read",
+		Types:     []string{"http://www.ft.com/ontology/Concept"},
+		Predicate: "http://www.ft.com/ontology/hasDisplayTag",
+	}})
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.Anything, mock.Anything).Return(map[string]concept.Model{}, nil)
+	mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+	model := mapperHandler.ToIndexModel(context.Background(), content, config.VideoType, "tid_1")
+
+	require.NotNil(t, model.LastMetadataPublish)
+	assert.Equal(t, "2018-04-04T12:58:00.347Z", *model.LastMetadataPublish)
+	require.NotNil(t, model.ProviderName)
+	assert.Equal(t, "Methode", *model.ProviderName)
+	require.NotNil(t, model.CmrMediatype)
+	assert.Equal(t, "Video", *model.CmrMediatype)
+	assert.Equal(t, []string{"Big Read"}, model.EditorsTags)
+
+	content.LastModified = ""
+	content.Content.Identifiers = nil
+	model = mapperHandler.ToIndexModel(context.Background(), content, config.VideoType, "tid_1")
+
+	assert.Nil(t, model.LastMetadataPublish)
+	assert.Nil(t, model.ProviderName)
+
+	// an authority merely containing the authority of a system is not that system
+	require.NoError(t, json.Unmarshal([]byte(`[{"authority":"http://api.ft.com/system/cct-legacy","identifierValue":"1"}]`), &content.Content.Identifiers))
+	model = mapperHandler.ToIndexModel(context.Background(), content, config.VideoType, "tid_1")
+	assert.Nil(t, model.ProviderName)
+}
+
+func TestProviderNameIsDeterministic(t *testing.T) {
+	var content schema.EnrichedContent
+	require.NoError(t, json.Unmarshal([]byte(`{"content":{"identifiers":[{"authority":"http://api.ft.com/system/shared","identifierValue":"1"}]}}`), &content))
+	appConfig := config.AppConfig{ContentMetadataMap: config.ContentMetadataMap{
+		"b": {Authority: "http://api.ft.com/system/shared", ProviderName: "B"},
+		"a": {Authority: "http://api.ft.com/system/shared", ProviderName: "A"},
+		"c": {Authority: "http://api.ft.com/system/shared", ProviderName: "C"},
+	}}
+	mapperHandler := NewMapperHandler(new(concordanceAPIMock), "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, "A", mapperHandler.providerName(content))
+	}
+}
+
+func TestAnnotationFields(t *testing.T) {
+	appConfig, err := config.ParseConfig("app.yml")
+	require.NoError(t, err)
+	appConfig.FieldRules.TickerAuthorities = []string{"http://api.ft.com/system/TICKER"}
+
+	conceptID := concept.ThingURIPrefix + "3d1e9d68-93f3-3a35-b0ec-4a5cb3a1d7f1"
+	ticker := concept.Model{TmeIDs: []string{"TME-ID"}, Identifiers: map[string][]string{
+		"http://api.ft.com/system/TICKER":     {"PSON:LSE"},
+		"http://api.ft.com/system/SMARTLOGIC": {"3d1e9d68-93f3-3a35-b0ec-4a5cb3a1d7f1"},
+	}}
+
+	tests := []struct {
+		name      string
+		types     []string
+		predicate string
+		field     func(model schema.IndexModel) []string
+		expected  []string
+	}{
+		{
+			name:      "special reports",
+			types:     []string{"http://www.ft.com/ontology/SpecialReport"},
+			predicate: "http://www.ft.com/ontology/classification/isClassifiedBy",
+			field: func(model schema.IndexModel) []string {
+				return append(model.CmrSpecialreports, model.CmrSpecialreportsIds...)
+			},
+			expected: []string{"Label", "3d1e9d68-93f3-3a35-b0ec-4a5cb3a1d7f1", "TME-ID"},
+		},
+		{
+			name:      "subjects",
+			types:     []string{"http://www.ft.com/ontology/Subject"},
+			predicate: "http://www.ft.com/ontology/classification/isClassifiedBy",
+			field:     func(model schema.IndexModel) []string { return append(model.CmrSubjects, model.CmrSubjectsIds...) },
+			expected:  []string{"Label", "3d1e9d68-93f3-3a35-b0ec-4a5cb3a1d7f1", "TME-ID"},
+		},
+		{
+			name:      "article types",
+			types:     []string{"http://www.ft.com/ontology/Genre"},
+			predicate: "http://www.ft.com/ontology/classification/isClassifiedBy",
+			field:     func(model schema.IndexModel) []string { return model.ArticleTypes },
+			expected:  []string{"Label"},
+		},
+		{
+			name:      "article brands",
+			types:     []string{"http://www.ft.com/ontology/product/Brand"},
+			predicate: "http://www.ft.com/ontology/classification/isPrimarilyClassifiedBy",
+			field:     func(model schema.IndexModel) []string { return model.ArticleBrands },
+			expected:  []string{"Label"},
+		},
+		{
+			name:      "implicit brands are not article brands",
+			types:     []string{"http://www.ft.com/ontology/product/Brand"},
+			predicate: "http://www.ft.com/ontology/implicitlyClassifiedBy",
+			field:     func(model schema.IndexModel) []string { return model.ArticleBrands },
+		},
+		{
+			name:      "editorial company names",
+			types:     []string{"http://www.ft.com/ontology/company/Company"},
+			predicate: "http://www.ft.com/ontology/annotation/about",
+			field:     func(model schema.IndexModel) []string { return model.CompanyNamesEditorial },
+			expected:  []string{"Label"},
+		},
+		{
+			name:      "editorial company ticker codes",
+			types:     []string{"http://www.ft.com/ontology/company/Company"},
+			predicate: "http://www.ft.com/ontology/annotation/majorMentions",
+			field:     func(model schema.IndexModel) []string { return model.CompanyTickerCodeEditorial },
+			expected:  []string{"PSON:LSE"},
+		},
+		{
+			name:      "auto company names",
+			types:     []string{"http://www.ft.com/ontology/company/Company"},
+			predicate: "http://www.ft.com/ontology/implicitlyAbout",
+			field:     func(model schema.IndexModel) []string { return model.CompanyNamesAuto },
+			expected:  []string{"Label"},
+		},
+		{
+			name:      "auto company ticker codes",
+			types:     []string{"http://www.ft.com/ontology/company/Company"},
+			predicate: "http://www.ft.com/ontology/implicitlyAbout",
+			field:     func(model schema.IndexModel) []string { return model.CompanyTickerCodeAuto },
+			expected:  []string{"PSON:LSE"},
+		},
+		{
+			name:      "auto organisation names",
+			types:     []string{"http://www.ft.com/ontology/organisation/Organisation"},
+			predicate: "http://www.ft.com/ontology/implicitlyAbout",
+			field:     func(model schema.IndexModel) []string { return model.OrganisationNamesAuto },
+			expected:  []string{"Label"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := schema.EnrichedContent{
+				UUID:    "aae9611e-f66c-4fe4-a6c6-2e2bdea69060",
+				Content: schema.Content{UUID: "aae9611e-f66c-4fe4-a6c6-2e2bdea69060"},
+				Metadata: schema.Annotations{{Thing: schema.Thing{
+					ID:        conceptID,
+					PrefLabel: "Label",
+					Types:     test.types,
+					Predicate: test.predicate,
+				}}},
+			}
+			concordanceAPIMock := new(concordanceAPIMock)
+			concordanceAPIMock.On("GetConcepts", "tid_1", []string{conceptID}).Return(map[string]concept.Model{conceptID: ticker}, nil)
+			mapperHandler := NewMapperHandler(concordanceAPIMock, "http://api.ft.com", appConfig, logger.NewUPPLogger("test", "PANIC"), internalcontent.NewContentClient(&clientMock{}, ""))
+
+			model := mapperHandler.ToIndexModel(context.Background(), content, config.ArticleType, "tid_1")
+
+			assert.Equal(t, test.expected, test.field(model))
+		})
+	}
+}
@@ -1,18 +1,28 @@
 package message
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/deadletter"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/mapper"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/reenrich"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/schema"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/source"
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/message-queue-gonsumer/consumer"
 	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pborman/uuid"
+	"gopkg.in/olivere/elastic.v2"
 )
 
 const (
@@ -22,51 +32,313 @@ const (
 	contentTypeHeader        = "Content-Type"
 	audioContentTypeHeader   = "ft-upp-audio"
 	articleContentTypeHeader = "ft-upp-article"
+
+	StageUnmarshal   = "unmarshal"
+	StageContentType = "content-type"
+	StageDelete      = "delete"
+	StageWrite       = "write"
+
+	DefaultElasticsearchTimeout = 30 * time.Second
+	DefaultWorkers              = 1
+	// defaultCancelTimeout bounds how long Drain waits for the cancelled messages to be dead-lettered
+	defaultCancelTimeout = 5 * time.Second
+)
+
+var (
+	errUnknownContentType = errors.New("could not infer type of content")
+
+	// ErrNotIndexable is returned when content indexed on demand would be ignored by the message flow.
+	ErrNotIndexable = errors.New("content cannot be indexed")
 )
 
+// IndexResult is the outcome of mapping and indexing a single piece of content on demand.
+type IndexResult struct {
+	ContentType string               `json:"contentType"`
+	Collection  string               `json:"collection"`
+	Model       *schema.IndexModel   `json:"model"`
+	Result      *elastic.IndexResult `json:"result,omitempty"`
+}
+
 type ESClient func(config es.AccessConfig, c *http.Client, log *logger.UPPLogger) (es.Client, error)
 
 type Handler struct {
-	esService       es.Service
-	messageConsumer consumer.MessageConsumer
-	Mapper          *mapper.Handler
-	httpClient      *http.Client
-	esClient        ESClient
-	log             *logger.UPPLogger
+	esService     es.Service
+	messageSource source.Source
+	Mapper        *mapper.Handler
+	httpClient    *http.Client
+	esClient      ESClient
+	deadLetters   deadletter.Store
+	reenrichment  reenrich.Queue
+	log           *logger.UPPLogger
+	// ElasticsearchTimeout bounds every write and delete, retries included
+	ElasticsearchTimeout time.Duration
+	// Workers is the number of messages processed in parallel, the messages of the same content are processed in order
+	Workers int
+	pool    *workerPool
+	// serial runs the messages and the content indexed outside of the queue one at a time without a worker pool
+	serial sync.Mutex
+	// ctx is cancelled by Stop, the messages still in flight are abandoned and dead-lettered
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// inFlight counts the messages handed over by the source until Drain is called, later ones are rejected
+	inFlightMu sync.Mutex
+	inFlight   sync.WaitGroup
+	draining   bool
+	// cancelTimeout bounds the wait for the messages cancelled by Drain
+	cancelTimeout time.Duration
 }
 
-func NewMessageHandler(service es.Service, mapper *mapper.Handler, httpClient *http.Client, queueConfig consumer.QueueConfig, esClient ESClient, logger *logger.UPPLogger) *Handler {
-	indexer := &Handler{esService: service, Mapper: mapper, httpClient: httpClient, esClient: esClient, log: logger}
-	indexer.messageConsumer = consumer.NewConsumer(queueConfig, indexer.handleMessage, httpClient)
-	return indexer
+func NewMessageHandler(service es.Service, mapper *mapper.Handler, httpClient *http.Client, messageSource source.Source, esClient ESClient, deadLetters deadletter.Store, reenrichment reenrich.Queue, logger *logger.UPPLogger) *Handler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Handler{
+		esService:            service,
+		messageSource:        messageSource,
+		Mapper:               mapper,
+		httpClient:           httpClient,
+		esClient:             esClient,
+		deadLetters:          deadLetters,
+		reenrichment:         reenrichment,
+		log:                  logger,
+		ElasticsearchTimeout: DefaultElasticsearchTimeout,
+		Workers:              DefaultWorkers,
+		ctx:                  ctx,
+		cancel:               cancel,
+		cancelTimeout:        defaultCancelTimeout,
+	}
 }
 
 func (h *Handler) Start(baseAPIURL string, accessConfig es.AccessConfig) {
-	h.Mapper.BaseAPIURL = baseAPIURL
+	h.Mapper.SetBaseAPIURL(baseAPIURL)
 	go func() {
 		for {
 			ec, err := h.esClient(accessConfig, h.httpClient, h.log)
 			if err != nil {
 				h.log.Error("Could not connect to Elasticsearch")
-				time.Sleep(time.Minute)
+				select {
+				case <-h.ctx.Done():
+					return
+				case <-time.After(time.Minute):
+				}
 				continue
 			}
 			h.esService.SetClient(ec)
 			h.log.Info("Connected to Elasticsearch")
+			h.startWorkers()
 			// this is a blocking method
-			h.messageConsumer.Start()
+			h.messageSource.Start(h.handleMessage)
 			return
 		}
 	}()
 }
 
+func (h *Handler) startWorkers() {
+	if h.Workers > 1 {
+		h.inFlightMu.Lock()
+		h.pool = newWorkerPool(h.Workers)
+		h.inFlightMu.Unlock()
+		h.log.Infof("Processing messages with %d workers", h.Workers)
+	}
+}
+
+// stopWorkers stops the worker pool, if any, once its workers ran the jobs they were handed.
+func (h *Handler) stopWorkers() {
+	h.inFlightMu.Lock()
+	pool := h.pool
+	h.inFlightMu.Unlock()
+	if pool != nil {
+		pool.stop()
+	}
+}
+
 func (h *Handler) Stop() {
-	if h.messageConsumer != nil {
-		h.messageConsumer.Stop()
+	h.cancel()
+	if h.messageSource != nil {
+		h.messageSource.Stop()
+	}
+	h.stopWorkers()
+}
+
+// Drain stops consuming and waits up to timeout for the messages in flight to be handled, then cancels the ones still
+// in flight, which are dead-lettered. Messages the source still hands over while stopping are rejected, neither processed
+// nor dead-lettered. It tells whether all the messages in flight were handled in time.
+func (h *Handler) Drain(timeout time.Duration) bool {
+	h.inFlightMu.Lock()
+	h.draining = true
+	h.inFlightMu.Unlock()
+
+	if h.messageSource != nil {
+		// some sources only stop once the message they are handing over is handled
+		go h.messageSource.Stop()
+	}
+	handled := make(chan struct{})
+	go func() {
+		h.inFlight.Wait()
+		close(handled)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-handled:
+		h.cancel()
+		h.stopWorkers()
+		h.log.Info("[Shutdown] Messages in flight handled")
+		return true
+	case <-timer.C:
+		h.log.Warnf("[Shutdown] Messages still in flight after %v are cancelled", timeout)
+		h.cancel()
+	}
+	// a message stuck in a call that ignores the cancellation must not block the shutdown, nor its worker
+	cancelTimer := time.NewTimer(h.cancelTimeout)
+	defer cancelTimer.Stop()
+	select {
+	case <-handled:
+		h.stopWorkers()
+	case <-cancelTimer.C:
+		h.log.Errorf("[Shutdown] Cancelled messages still in flight after %v are abandoned", h.cancelTimeout)
+	}
+	return false
+}
+
+// processed tells what happened to a message, failures carry the stage they happened at.
+type processed struct {
+	tid         string
+	uuid        string
+	contentType string
+	outcome     string
+	stage       string
+	// lookupFailure is set when the content was indexed without all of its concordances
+	lookupFailure bool
+}
+
+// handleMessage is handed the messages by the source. With a worker pool it only waits for the worker of the content
+// to take the message over. handled, when set, is called once the message is indexed, ignored or dead-lettered,
+// it is not called for the messages rejected while draining.
+func (h *Handler) handleMessage(msg consumer.Message, handled func()) {
+	metrics.MessageReceived(metrics.SourceQueue, msg.Headers[originHeader])
+	done, accepted := h.startInFlight()
+	if !accepted {
+		h.rejectMessage(msg)
+		return
+	}
+	job := func() {
+		defer done()
+		h.handle(msg)
+		if handled != nil {
+			handled()
+		}
+	}
+	if h.pool == nil {
+		h.serial.Lock()
+		defer h.serial.Unlock()
+		job()
+		return
+	}
+	if !h.pool.dispatch(contentUUID(msg), job) {
+		done()
+		h.rejectMessage(msg)
+	}
+}
+
+func (h *Handler) rejectMessage(msg consumer.Message) {
+	h.log.WithTransactionID(msg.Headers[transactionIDHeader]).WithUUID(contentUUID(msg)).
+		Warn("Rejecting message handed over while draining")
+}
+
+// startInFlight counts the message until the returned func is called. Once the handler is draining the message is not accepted.
+func (h *Handler) startInFlight() (func(), bool) {
+	h.inFlightMu.Lock()
+	defer h.inFlightMu.Unlock()
+	if h.draining {
+		return nil, false
+	}
+	h.inFlight.Add(1)
+	return h.inFlight.Done, true
+}
+
+func (h *Handler) handle(msg consumer.Message) {
+	result, err := h.process(h.ctx, msg, metrics.SourceQueue)
+	if err != nil {
+		h.deadLetter(msg, result.tid, result.stage, err)
+	}
+}
+
+// Replay processes a previously dead-lettered message. Failures are returned instead of being dead-lettered again.
+func (h *Handler) Replay(ctx context.Context, msg consumer.Message) (err error) {
+	metrics.MessageReceived(metrics.SourceReplay, msg.Headers[originHeader])
+	h.inOrder(contentUUID(msg), func() {
+		_, err = h.process(ctx, msg, metrics.SourceReplay)
+	})
+	return err
+}
+
+// Reprocess runs a message of a reindex outside of the queue and returns its outcome (see the metrics outcomes).
+// Failures are returned instead of being dead-lettered.
+func (h *Handler) Reprocess(ctx context.Context, msg consumer.Message) (string, error) {
+	metrics.MessageReceived(metrics.SourceReindex, msg.Headers[originHeader])
+	result, err := h.process(ctx, msg, metrics.SourceReindex)
+	return result.outcome, err
+}
+
+// Reenrich indexes a message queued for re-enrichment again and tells whether its concordances still couldn't all be looked up.
+func (h *Handler) Reenrich(ctx context.Context, msg consumer.Message) (lookupFailure bool, err error) {
+	metrics.MessageReceived(metrics.SourceReenrich, msg.Headers[originHeader])
+	h.inOrder(contentUUID(msg), func() {
+		var result processed
+		result, err = h.processMessage(ctx, msg)
+		metrics.MessageProcessed(metrics.SourceReenrich, result.outcome, result.contentType, msg.Headers[originHeader])
+		lookupFailure = result.lookupFailure
+	})
+	return lookupFailure, err
+}
+
+// inOrder runs fn on the worker of the content, after the messages of the content handed over before it, so that content
+// indexed outside of the queue doesn't race its messages. Without a worker pool fn runs between two messages,
+// once the pool is stopped it runs right away.
+func (h *Handler) inOrder(uuid string, fn func()) {
+	h.inFlightMu.Lock()
+	pool := h.pool
+	h.inFlightMu.Unlock()
+	if pool == nil {
+		h.serial.Lock()
+		defer h.serial.Unlock()
+		fn()
+		return
+	}
+	done := make(chan struct{})
+	if !pool.dispatch(uuid, func() {
+		defer close(done)
+		fn()
+	}) {
+		fn()
+		return
+	}
+	<-done
+}
+
+func (h *Handler) process(ctx context.Context, msg consumer.Message, source string) (processed, error) {
+	result, err := h.processMessage(ctx, msg)
+	metrics.MessageProcessed(source, result.outcome, result.contentType, msg.Headers[originHeader])
+	h.trackLookupFailure(msg, result)
+	return result, err
+}
+
+// trackLookupFailure queues the content indexed without all of its concordances for re-enrichment,
+// and forgets it once a newer event was indexed with all of them or deleted it.
+func (h *Handler) trackLookupFailure(msg consumer.Message, result processed) {
+	if h.reenrichment == nil {
+		return
+	}
+	switch {
+	case result.outcome == metrics.OutcomeIndexed && result.lookupFailure:
+		h.reenrichment.Enqueue(result.uuid, result.tid, msg)
+	case result.outcome == metrics.OutcomeIndexed || result.outcome == metrics.OutcomeDeleted:
+		h.reenrichment.Resolve(result.uuid)
 	}
 }
 
-func (h *Handler) handleMessage(msg consumer.Message) {
+func (h *Handler) processMessage(ctx context.Context, msg consumer.Message) (processed, error) {
 	tid := msg.Headers[transactionIDHeader]
 	log := h.log.WithTransactionID(tid)
 
@@ -78,24 +350,21 @@ func (h *Handler) handleMessage(msg consumer.Message) {
 
 	if strings.Contains(tid, syntheticRequestPrefix) {
 		log.Info("Ignoring synthetic message")
-		return
+		return processed{tid: tid, outcome: metrics.OutcomeIgnored}, nil
 	}
 
 	var combinedPostPublicationEvent schema.EnrichedContent
 	err := json.Unmarshal([]byte(msg.Body), &combinedPostPublicationEvent)
 	if err != nil {
 		log.WithError(err).Error("Cannot unmarshal message body")
-		return
+		return processed{tid: tid, outcome: metrics.OutcomeFailed, stage: StageUnmarshal}, err
 	}
 
-	if combinedPostPublicationEvent.Content.BodyXML != "" && combinedPostPublicationEvent.Content.Body == "" {
-		combinedPostPublicationEvent.Content.Body = combinedPostPublicationEvent.Content.BodyXML
-		combinedPostPublicationEvent.Content.BodyXML = ""
-	}
+	useBodyXML(&combinedPostPublicationEvent)
 
 	if !isAllowedType(combinedPostPublicationEvent.Content.Type) {
 		log.Infof("Ignoring message of type %s", combinedPostPublicationEvent.Content.Type)
-		return
+		return processed{tid: tid, outcome: metrics.OutcomeIgnored}, nil
 	}
 
 	uuid := combinedPostPublicationEvent.UUID
@@ -105,33 +374,163 @@ func (h *Handler) handleMessage(msg consumer.Message) {
 	contentType := h.readContentType(msg, combinedPostPublicationEvent)
 	if contentType == "" && msg.Headers[originHeader] != config.PACOrigin {
 		log.Error("Failed to index content. Could not infer type of content")
-		return
+		return processed{tid: tid, outcome: metrics.OutcomeFailed, stage: StageContentType}, errUnknownContentType
 	}
 
 	conceptType := h.Mapper.Config.ESContentTypeMetadataMap.Get(contentType).Collection
+	version := documentVersion(combinedPostPublicationEvent)
 	if combinedPostPublicationEvent.MarkedDeleted == "true" {
-		_, err = h.esService.DeleteData(conceptType, uuid)
+		_, err = h.deleteData(ctx, conceptType, uuid, version)
+		if err == es.ErrStaleVersion {
+			log.Info("Delete skipped: stale, a newer version is already indexed")
+			return processed{tid: tid, contentType: contentType, outcome: metrics.OutcomeIgnored}, nil
+		}
 		if err != nil {
 			log.WithError(err).Error("Failed to delete indexed content")
-			return
+			return processed{tid: tid, contentType: contentType, outcome: metrics.OutcomeFailed, stage: StageDelete}, err
 		}
 		log.WithMonitoringEvent("ContentDeleteElasticsearch", tid, contentType).Info("Successfully deleted")
-		return
+		return processed{tid: tid, uuid: uuid, contentType: contentType, outcome: metrics.OutcomeDeleted}, nil
 	}
 
 	if combinedPostPublicationEvent.Content.UUID == "" || contentType == "" {
 		log.Info("Ignoring message with no content")
-		return
+		return processed{tid: tid, contentType: contentType, outcome: metrics.OutcomeIgnored}, nil
 	}
 
-	payload := h.Mapper.ToIndexModel(combinedPostPublicationEvent, contentType, tid)
+	payload := h.Mapper.ToIndexModel(ctx, combinedPostPublicationEvent, contentType, tid)
 
-	_, err = h.esService.WriteData(conceptType, uuid, payload)
+	_, err = h.writeData(ctx, conceptType, uuid, payload, version)
+	if err == es.ErrStaleVersion {
+		log.Info("Write skipped: stale, a newer version is already indexed")
+		return processed{tid: tid, contentType: contentType, outcome: metrics.OutcomeIgnored}, nil
+	}
 	if err != nil {
 		log.WithError(err).Error("Failed to index content")
-		return
+		return processed{tid: tid, contentType: contentType, outcome: metrics.OutcomeFailed, stage: StageWrite}, err
 	}
 	log.WithMonitoringEvent("ContentWriteElasticsearch", tid, contentType).Info("Successfully saved")
+	return processed{tid: tid, uuid: uuid, contentType: contentType, outcome: metrics.OutcomeIndexed, lookupFailure: payload.LookupFailure}, nil
+}
+
+// Index maps and writes a single piece of content outside of the message flow, e.g. when support engineers fix a missing document.
+// The headers are the ones the content would have been published with and may be empty.
+func (h *Handler) Index(ctx context.Context, event schema.EnrichedContent, headers map[string]string) (result IndexResult, err error) {
+	h.inOrder(event.UUID, func() {
+		result, err = h.index(ctx, event, headers)
+	})
+	return result, err
+}
+
+func (h *Handler) index(ctx context.Context, event schema.EnrichedContent, headers map[string]string) (IndexResult, error) {
+	tid := headers[transactionIDHeader]
+	if tid == "" {
+		tid = transactionid.NewTransactionID()
+	}
+	log := h.log.WithTransactionID(tid).WithUUID(event.UUID)
+
+	result, err := h.mapContent(ctx, event, headers, tid)
+	if err != nil {
+		log.WithError(err).Warn("Content cannot be indexed on demand")
+		return result, err
+	}
+
+	result.Result, err = h.writeData(ctx, result.Collection, event.UUID, result.Model, documentVersion(event))
+	if err != nil {
+		log.WithError(err).Error("Failed to index content on demand")
+		return result, err
+	}
+	log.WithMonitoringEvent("ContentWriteElasticsearch", tid, result.ContentType).Info("Successfully saved on demand")
+	return result, nil
+}
+
+// Preview returns the model the content would be indexed with, without writing it to Elasticsearch.
+func (h *Handler) Preview(ctx context.Context, event schema.EnrichedContent, headers map[string]string) (IndexResult, error) {
+	tid := headers[transactionIDHeader]
+	if tid == "" {
+		tid = transactionid.NewTransactionID()
+	}
+	return h.mapContent(ctx, event, headers, tid)
+}
+
+// mapContent resolves the content type and collection of the event and maps it to the model that is indexed.
+func (h *Handler) mapContent(ctx context.Context, event schema.EnrichedContent, headers map[string]string, tid string) (IndexResult, error) {
+	useBodyXML(&event)
+	if !isAllowedType(event.Content.Type) {
+		return IndexResult{}, fmt.Errorf("%w: content of type %s is ignored", ErrNotIndexable, event.Content.Type)
+	}
+	if event.Content.UUID == "" {
+		return IndexResult{}, fmt.Errorf("%w: no content", ErrNotIndexable)
+	}
+	contentType := h.readContentType(consumer.Message{Headers: headers}, event)
+	if contentType == "" {
+		return IndexResult{}, fmt.Errorf("%w: %v", ErrNotIndexable, errUnknownContentType)
+	}
+
+	model := h.Mapper.ToIndexModel(ctx, event, contentType, tid)
+	return IndexResult{
+		ContentType: contentType,
+		Collection:  h.Mapper.Config.ESContentTypeMetadataMap.Get(contentType).Collection,
+		Model:       &model,
+	}, nil
+}
+
+func (h *Handler) writeData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	ctx, cancel := h.elasticsearchContext(ctx)
+	defer cancel()
+	return h.esService.WriteData(ctx, conceptType, uuid, payload, version)
+}
+
+func (h *Handler) deleteData(ctx context.Context, conceptType string, uuid string, version int64) (*elastic.DeleteResult, error) {
+	ctx, cancel := h.elasticsearchContext(ctx)
+	defer cancel()
+	return h.esService.DeleteData(ctx, conceptType, uuid, version)
+}
+
+func (h *Handler) elasticsearchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.ElasticsearchTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.ElasticsearchTimeout)
+}
+
+func useBodyXML(event *schema.EnrichedContent) {
+	if event.Content.BodyXML != "" && event.Content.Body == "" {
+		event.Content.Body = event.Content.BodyXML
+		event.Content.BodyXML = ""
+	}
+}
+
+func (h *Handler) deadLetter(msg consumer.Message, tid string, stage string, cause error) {
+	entry := deadletter.Entry{
+		ID:            uuid.NewRandom().String(),
+		TransactionID: tid,
+		Stage:         stage,
+		Error:         cause.Error(),
+		Time:          time.Now().UTC(),
+		Headers:       msg.Headers,
+		Body:          msg.Body,
+	}
+	log := h.log.WithTransactionID(tid)
+	if err := h.deadLetters.Add(entry); err != nil {
+		log.WithError(err).Error("Failed to dead-letter message")
+		return
+	}
+	log.Warnf("Message dead-lettered with id %s at stage %s", entry.ID, stage)
+}
+
+// documentVersion uses the lastModified date of the event in milliseconds as the external version of the document,
+// so that events delivered out of order do not overwrite newer ones. Zero means the event is not versioned.
+func documentVersion(event schema.EnrichedContent) int64 {
+	lastModified := event.LastModified
+	if lastModified == "" {
+		lastModified = event.Content.LastModified
+	}
+	t, err := time.Parse(time.RFC3339Nano, lastModified)
+	if err != nil {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
 }
 
 func (h *Handler) readContentType(msg consumer.Message, event schema.EnrichedContent) string {
@@ -1,15 +1,20 @@
 package message
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/olivere/elastic.v2"
 
 	"github.com/Financial-Times/go-logger/v2"
@@ -19,9 +24,13 @@ import (
 
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/concept"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/config"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/deadletter"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/es"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/mapper"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/reenrich"
 	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/schema"
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/source"
 	tst "github.com/Financial-Times/content-rw-elasticsearch/v2/test"
 )
 
@@ -33,16 +42,46 @@ func (*esServiceMock) GetSchemaHealth() (string, error) {
 	panic("implement me")
 }
 
-func (s *esServiceMock) WriteData(conceptType string, uuid string, payload interface{}) (*elastic.IndexResult, error) {
-	args := s.Called(conceptType, uuid, payload)
+func (*esServiceMock) GetSchemaDiff() (*es.SchemaDiff, error) {
+	panic("implement me")
+}
+
+func (s *esServiceMock) WriteData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	args := s.Called(conceptType, uuid, payload, version)
 	return args.Get(0).(*elastic.IndexResult), args.Error(1)
 }
 
-func (s *esServiceMock) DeleteData(conceptType string, uuid string) (*elastic.DeleteResult, error) {
-	args := s.Called(conceptType, uuid)
+func (s *esServiceMock) DeleteData(ctx context.Context, conceptType string, uuid string, version int64) (*elastic.DeleteResult, error) {
+	args := s.Called(conceptType, uuid, version)
 	return args.Get(0).(*elastic.DeleteResult), args.Error(1)
 }
 
+func (s *esServiceMock) LookupFailures(ctx context.Context, size int) ([]string, error) {
+	args := s.Called(size)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// hangingESService never answers writes, like a cluster that doesn't respond.
+type hangingESService struct {
+	esServiceMock
+}
+
+func (s *hangingESService) WriteData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// stuckESService never answers writes and ignores their cancellation.
+type stuckESService struct {
+	esServiceMock
+	release chan struct{}
+}
+
+func (s *stuckESService) WriteData(ctx context.Context, conceptType string, uuid string, payload interface{}, version int64) (*elastic.IndexResult, error) {
+	<-s.release
+	return nil, ctx.Err()
+}
+
 func (s *esServiceMock) SetClient(client es.Client) {
 
 }
@@ -81,6 +120,11 @@ func (c *elasticClientMock) Delete() *elastic.DeleteService {
 	return args.Get(0).(*elastic.DeleteService)
 }
 
+func (c *elasticClientMock) Bulk() *elastic.BulkService {
+	args := c.Called()
+	return args.Get(0).(*elastic.BulkService)
+}
+
 func (c *elasticClientMock) PerformRequest(method, path string, params url.Values, body interface{}, ignoreErrors ...int) (*elastic.Response, error) {
 	args := c.Called()
 	return args.Get(0).(*elastic.Response), args.Error(1)
@@ -90,6 +134,45 @@ type concordanceAPIMock struct {
 	mock.Mock
 }
 
+type deadLetterStoreMock struct {
+	entries []deadletter.Entry
+}
+
+func (s *deadLetterStoreMock) Add(entry deadletter.Entry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *deadLetterStoreMock) List() ([]deadletter.Entry, error) {
+	return s.entries, nil
+}
+
+func (s *deadLetterStoreMock) Get(id string) (deadletter.Entry, error) {
+	panic("implement me")
+}
+
+func (s *deadLetterStoreMock) Remove(id string) error {
+	panic("implement me")
+}
+
+func (s *deadLetterStoreMock) RemoveAll(ids []string) error {
+	panic("implement me")
+}
+
+// reenrichQueueMock records the documents queued for re-enrichment and the ones resolved.
+type reenrichQueueMock struct {
+	queued   []string
+	resolved []string
+}
+
+func (q *reenrichQueueMock) Enqueue(uuid string, tid string, msg consumer.Message) {
+	q.queued = append(q.queued, uuid)
+}
+
+func (q *reenrichQueueMock) Resolve(uuid string) {
+	q.resolved = append(q.resolved, uuid)
+}
+
 var defaultESClient = func(config es.AccessConfig, c *http.Client, log *logger.UPPLogger) (es.Client, error) {
 	return &elasticClientMock{}, nil
 }
@@ -98,7 +181,7 @@ var errorESClient = func(config es.AccessConfig, c *http.Client, log *logger.UPP
 	return nil, elastic.ErrNoClient
 }
 
-func (m *concordanceAPIMock) GetConcepts(tid string, ids []string) (map[string]concept.Model, error) {
+func (m *concordanceAPIMock) GetConcepts(ctx context.Context, tid string, ids []string) (map[string]concept.Model, error) {
 	args := m.Called(tid, ids)
 	return args.Get(0).(map[string]concept.Model), args.Error(1)
 }
@@ -122,12 +205,18 @@ func mockMessageHandler(esClient ESClient, mocks ...interface{}) (es.AccessConfi
 
 	concordanceAPI := new(concordanceAPIMock)
 	esService := new(esServiceMock)
+	deadLetters := new(deadLetterStoreMock)
+	var reenrichment reenrich.Queue
 	for _, m := range mocks {
 		switch m.(type) {
 		case *concordanceAPIMock:
 			concordanceAPI = m.(*concordanceAPIMock)
 		case *esServiceMock:
 			esService = m.(*esServiceMock)
+		case *deadLetterStoreMock:
+			deadLetters = m.(*deadLetterStoreMock)
+		case *reenrichQueueMock:
+			reenrichment = m.(*reenrichQueueMock)
 		}
 	}
 
@@ -137,9 +226,9 @@ func mockMessageHandler(esClient ESClient, mocks ...interface{}) (es.AccessConfi
 
 	mapperHandler := mockMapperHandler(concordanceAPI, uppLogger, internalContentClient)
 
-	handler := NewMessageHandler(esService, mapperHandler, http.DefaultClient, queueConfig, esClient, uppLogger)
+	handler := NewMessageHandler(esService, mapperHandler, http.DefaultClient, source.NewKafkaProxySource(queueConfig, http.DefaultClient), esClient, deadLetters, reenrichment, uppLogger)
 	if mocks == nil {
-		handler = NewMessageHandler(es.NewService("index"), mapperHandler, http.DefaultClient, queueConfig, esClient, uppLogger)
+		handler = NewMessageHandler(es.NewService("index", nil), mapperHandler, http.DefaultClient, source.NewKafkaProxySource(queueConfig, http.DefaultClient), esClient, deadLetters, reenrichment, uppLogger)
 	}
 	return accessConfig, handler
 }
@@ -193,12 +282,12 @@ func TestHandleWriteMessage(t *testing.T) {
 	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: string(inputJSON)})
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
 
 	expect.Equal(1, len(serviceMock.Calls))
 
@@ -219,12 +308,12 @@ func TestHandleWriteMessageFromBodyXML(t *testing.T) {
 	inputJSON := tst.ReadTestResource("exampleEnrichedContentModelWithBodyXML.json")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: string(inputJSON)})
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
 
 	expect.Equal(1, len(serviceMock.Calls))
 
@@ -243,12 +332,12 @@ func TestHandleWriteMessageBlog(t *testing.T) {
 	input := modifyTestInputAuthority("FT-LABS-WP1234")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTBlogs", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTBlogs", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: input})
+	handler.handleMessage(consumer.Message{Body: input}, nil)
 
 	serviceMock.AssertExpectations(t)
 	concordanceAPIMock.AssertExpectations(t)
@@ -258,12 +347,12 @@ func TestHandleWriteMessageBlogWithHeader(t *testing.T) {
 	input := modifyTestInputAuthority("invalid")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTBlogs", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTBlogs", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Origin-System-Id": "wordpress", "Content-Type": "application/json"}})
+	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Origin-System-Id": "wordpress", "Content-Type": "application/json"}}, nil)
 
 	serviceMock.AssertExpectations(t)
 	concordanceAPIMock.AssertExpectations(t)
@@ -273,12 +362,12 @@ func TestHandleWriteMessageVideo(t *testing.T) {
 	input := modifyTestInputAuthority("NEXT-VIDEO-EDITOR")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTVideos", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTVideos", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Content-Type": "application/json"}})
+	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Content-Type": "application/json"}}, nil)
 
 	serviceMock.AssertExpectations(t)
 	concordanceAPIMock.AssertExpectations(t)
@@ -288,12 +377,12 @@ func TestHandleWriteMessageAudio(t *testing.T) {
 	input := modifyTestInputAuthority("NEXT-VIDEO-EDITOR")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTAudios", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTAudios", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Content-Type": "vnd.ft-upp-audio+json"}})
+	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Content-Type": "vnd.ft-upp-audio+json"}}, nil)
 
 	serviceMock.AssertExpectations(t)
 	concordanceAPIMock.AssertExpectations(t)
@@ -303,12 +392,12 @@ func TestHandleWriteMessageArticleByHeaderType(t *testing.T) {
 	input := modifyTestInputAuthority("invalid")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Content-Type": "application/vnd.ft-upp-article"}})
+	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{"Content-Type": "application/vnd.ft-upp-article"}}, nil)
 
 	serviceMock.AssertExpectations(t)
 	concordanceAPIMock.AssertExpectations(t)
@@ -322,10 +411,10 @@ func TestHandleWriteMessageUnknownType(t *testing.T) {
 	serviceMock := &esServiceMock{}
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock)
-	handler.handleMessage(consumer.Message{Body: input})
+	handler.handleMessage(consumer.Message{Body: input}, nil)
 
-	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything)
-	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, "aae9611e-f66c-4fe4-a6c6-2e2bdea69060")
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything)
 	serviceMock.AssertExpectations(t)
 }
 
@@ -341,10 +430,10 @@ func TestHandleWriteMessageNoUUIDForMetadataPublish(t *testing.T) {
 		Headers: map[string]string{
 			originHeader: methodeOrigin,
 		},
-	})
+	}, nil)
 
-	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, "b17756fe-0f62-4cf1-9deb-ca7a2ff80172", mock.Anything)
-	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, "b17756fe-0f62-4cf1-9deb-ca7a2ff80172")
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, "b17756fe-0f62-4cf1-9deb-ca7a2ff80172", mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, "b17756fe-0f62-4cf1-9deb-ca7a2ff80172", mock.Anything)
 	serviceMock.AssertExpectations(t)
 }
 
@@ -354,22 +443,22 @@ func TestHandleWriteMessageNoType(t *testing.T) {
 	serviceMock := &esServiceMock{}
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock)
-	handler.handleMessage(consumer.Message{Body: input})
+	handler.handleMessage(consumer.Message{Body: input}, nil)
 
-	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything)
-	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestHandleWriteMessageError(t *testing.T) {
 	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, elastic.ErrTimeout)
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, elastic.ErrTimeout)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: string(inputJSON)})
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
 
 	serviceMock.AssertExpectations(t)
 
@@ -381,10 +470,10 @@ func TestHandleDeleteMessage(t *testing.T) {
 	input := strings.Replace(string(inputJSON), `"markedDeleted": "false"`, `"markedDeleted": "true"`, 1)
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060").Return(&elastic.DeleteResult{}, nil)
+	serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.DeleteResult{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock)
-	handler.handleMessage(consumer.Message{Body: input})
+	handler.handleMessage(consumer.Message{Body: input}, nil)
 
 	serviceMock.AssertExpectations(t)
 }
@@ -395,10 +484,10 @@ func TestHandleDeleteMessageError(t *testing.T) {
 
 	serviceMock := &esServiceMock{}
 
-	serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060").Return(&elastic.DeleteResult{}, elastic.ErrTimeout)
+	serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.DeleteResult{}, elastic.ErrTimeout)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock)
-	handler.handleMessage(consumer.Message{Body: input})
+	handler.handleMessage(consumer.Message{Body: input}, nil)
 
 	serviceMock.AssertExpectations(t)
 }
@@ -406,40 +495,40 @@ func TestHandleDeleteMessageError(t *testing.T) {
 func TestHandleMessageJsonError(t *testing.T) {
 	serviceMock := &esServiceMock{}
 	_, handler := mockMessageHandler(defaultESClient, serviceMock)
-	handler.handleMessage(consumer.Message{Body: "malformed json"})
+	handler.handleMessage(consumer.Message{Body: "malformed json"}, nil)
 
-	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything)
-	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestHandleSyntheticMessage(t *testing.T) {
 	serviceMock := &esServiceMock{}
 	_, handler := mockMessageHandler(defaultESClient, serviceMock)
-	handler.handleMessage(consumer.Message{Headers: map[string]string{"X-Request-Id": "SYNTHETIC-REQ-MON_WuLjbRpCgh"}})
+	handler.handleMessage(consumer.Message{Headers: map[string]string{"X-Request-Id": "SYNTHETIC-REQ-MON_WuLjbRpCgh"}}, nil)
 
-	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything)
-	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestHandlePACMessage(t *testing.T) {
 	serviceMock := &esServiceMock{}
 	_, handler := mockMessageHandler(defaultESClient, serviceMock)
-	handler.handleMessage(consumer.Message{Headers: map[string]string{"Origin-System-Id": config.PACOrigin}, Body: "{}"})
+	handler.handleMessage(consumer.Message{Headers: map[string]string{"Origin-System-Id": config.PACOrigin}, Body: "{}"}, nil)
 
-	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything)
-	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	serviceMock.AssertNotCalled(t, "DeleteData", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestHandlePACMessageWithOldSparkContent(t *testing.T) {
 	input := modifyTestInputAuthority("cct")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{originHeader: config.PACOrigin}})
+	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{originHeader: config.PACOrigin}}, nil)
 
 	serviceMock.AssertExpectations(t)
 	concordanceAPIMock.AssertExpectations(t)
@@ -449,17 +538,494 @@ func TestHandlePACMessageWithSparkContent(t *testing.T) {
 	input := modifyTestInputAuthority("spark")
 
 	serviceMock := &esServiceMock{}
-	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
 	concordanceAPIMock := new(concordanceAPIMock)
 	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
 
 	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
-	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{originHeader: config.PACOrigin}})
+	handler.handleMessage(consumer.Message{Body: input, Headers: map[string]string{originHeader: config.PACOrigin}}, nil)
 
 	serviceMock.AssertExpectations(t)
 	concordanceAPIMock.AssertExpectations(t)
 }
 
+func TestHandleMessageDeadLettersWriteError(t *testing.T) {
+	expect := assert.New(t)
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, elastic.ErrTimeout)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock, deadLetters)
+	msg := consumer.Message{Body: string(inputJSON), Headers: map[string]string{transactionIDHeader: "tid_test"}}
+	handler.handleMessage(msg, nil)
+
+	expect.Len(deadLetters.entries, 1)
+	entry := deadLetters.entries[0]
+	expect.NotEmpty(entry.ID)
+	expect.Equal("tid_test", entry.TransactionID)
+	expect.Equal(StageWrite, entry.Stage)
+	expect.Equal(elastic.ErrTimeout.Error(), entry.Error)
+	expect.Equal(msg, entry.Message())
+}
+
+func TestHandleMessageDeadLettersFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		msg           consumer.Message
+		expectedStage string
+	}{
+		{
+			name:          "malformed json",
+			msg:           consumer.Message{Body: "malformed json"},
+			expectedStage: StageUnmarshal,
+		},
+		{
+			name:          "unknown content type",
+			msg:           consumer.Message{Body: modifyTestInputAuthority("invalid")},
+			expectedStage: StageContentType,
+		},
+		{
+			name:          "delete failure",
+			msg:           consumer.Message{Body: strings.Replace(string(tst.ReadTestResource("exampleEnrichedContentModel.json")), `"markedDeleted": "false"`, `"markedDeleted": "true"`, 1)},
+			expectedStage: StageDelete,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serviceMock := &esServiceMock{}
+			serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.DeleteResult{}, elastic.ErrTimeout)
+			deadLetters := new(deadLetterStoreMock)
+
+			_, handler := mockMessageHandler(defaultESClient, serviceMock, deadLetters)
+			handler.handleMessage(test.msg, nil)
+
+			if assert.Len(t, deadLetters.entries, 1) {
+				assert.Equal(t, test.expectedStage, deadLetters.entries[0].Stage)
+			}
+		})
+	}
+}
+
+func TestHandleMessageDeadLettersWriteTimeout(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, concordanceAPIMock, deadLetters)
+	handler.esService = &hangingESService{}
+	handler.ElasticsearchTimeout = 20 * time.Millisecond
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+
+	require.Len(t, deadLetters.entries, 1)
+	assert.Equal(t, StageWrite, deadLetters.entries[0].Stage)
+	assert.Equal(t, context.DeadlineExceeded.Error(), deadLetters.entries[0].Error)
+}
+
+func TestStopCancelsMessagesInFlight(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, concordanceAPIMock, deadLetters)
+	handler.esService = &hangingESService{}
+	handler.messageSource = nil
+	handler.ElasticsearchTimeout = 0
+	done := make(chan struct{})
+	go func() {
+		handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	handler.Stop()
+	<-done
+
+	require.Len(t, deadLetters.entries, 1)
+	assert.Equal(t, context.Canceled.Error(), deadLetters.entries[0].Error)
+}
+
+func TestDrainWaitsForMessagesInFlight(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil).After(50 * time.Millisecond)
+	concordanceAPIMock := new(concordanceAPIMock)
+	started := make(chan struct{})
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil).Run(func(mock.Arguments) {
+		close(started)
+	})
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock, deadLetters)
+	handler.messageSource = nil
+	go handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+	<-started
+
+	assert.True(t, handler.Drain(time.Second))
+	serviceMock.AssertExpectations(t)
+	assert.Empty(t, deadLetters.entries)
+}
+
+func TestDrainCancelsMessagesAfterTimeout(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	concordanceAPIMock := new(concordanceAPIMock)
+	started := make(chan struct{})
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil).Run(func(mock.Arguments) {
+		close(started)
+	})
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, concordanceAPIMock, deadLetters)
+	handler.esService = &hangingESService{}
+	handler.messageSource = nil
+	handler.ElasticsearchTimeout = 0
+	go handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+	<-started
+
+	assert.False(t, handler.Drain(20*time.Millisecond))
+	require.Len(t, deadLetters.entries, 1)
+	assert.Equal(t, context.Canceled.Error(), deadLetters.entries[0].Error)
+}
+
+func TestDrainAbandonsMessagesIgnoringCancellation(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	concordanceAPIMock := new(concordanceAPIMock)
+	started := make(chan struct{})
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil).Run(func(mock.Arguments) {
+		close(started)
+	})
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, concordanceAPIMock, deadLetters)
+	stuck := &stuckESService{release: make(chan struct{})}
+	defer close(stuck.release)
+	handler.esService = stuck
+	handler.messageSource = nil
+	handler.ElasticsearchTimeout = 0
+	handler.cancelTimeout = 20 * time.Millisecond
+	go handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+	<-started
+
+	drained := make(chan bool)
+	go func() {
+		drained <- handler.Drain(20 * time.Millisecond)
+	}()
+	select {
+	case ok := <-drained:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("Drain kept waiting for a message ignoring the cancellation")
+	}
+}
+
+func TestDrainRejectsLaterMessages(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	serviceMock := &esServiceMock{}
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, deadLetters)
+	handler.messageSource = nil
+	assert.True(t, handler.Drain(time.Second))
+
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, func() {
+		assert.Fail(t, "a rejected message was reported as handled")
+	})
+
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assert.Empty(t, deadLetters.entries)
+}
+
+func TestHandleMessageWithWorkers(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil).Times(3)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock, deadLetters)
+	handler.messageSource = nil
+	handler.Workers = 4
+	handler.startWorkers()
+	var handled int32
+	for i := 0; i < 3; i++ {
+		handler.handleMessage(consumer.Message{Body: string(inputJSON)}, func() {
+			atomic.AddInt32(&handled, 1)
+		})
+	}
+
+	assert.True(t, handler.Drain(time.Second))
+	serviceMock.AssertExpectations(t)
+	assert.Empty(t, deadLetters.entries)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&handled))
+	// the workers are stopped once drained
+	assert.False(t, handler.pool.dispatch("", func() {}))
+}
+
+func TestIndexWaitsForMessagesOfTheContentInWorkers(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	release := make(chan time.Time)
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil).WaitUntil(release).Once()
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil).Once()
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock, deadLetters)
+	handler.messageSource = nil
+	handler.Workers = 4
+	handler.startWorkers()
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+
+	var event schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(inputJSON, &event))
+	indexed := make(chan error)
+	go func() {
+		_, err := handler.Index(context.Background(), event, map[string]string{})
+		indexed <- err
+	}()
+	select {
+	case <-indexed:
+		assert.Fail(t, "content was indexed while a message of the content was processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-indexed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "content was not indexed once the message was processed")
+	}
+	assert.True(t, handler.Drain(time.Second))
+	serviceMock.AssertExpectations(t)
+}
+
+func TestHandleIgnoredMessageIsNotDeadLettered(t *testing.T) {
+	serviceMock := &esServiceMock{}
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, deadLetters)
+	handler.handleMessage(consumer.Message{Headers: map[string]string{"X-Request-Id": "SYNTHETIC-REQ-MON_WuLjbRpCgh"}}, nil)
+
+	assert.Empty(t, deadLetters.entries)
+}
+
+func TestReplayReturnsErrorWithoutDeadLettering(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, elastic.ErrTimeout)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock, deadLetters)
+	err := handler.Replay(context.Background(), consumer.Message{Body: string(inputJSON)})
+
+	assert.Equal(t, elastic.ErrTimeout, err)
+	assert.Empty(t, deadLetters.entries)
+}
+
+func TestReprocessReturnsOutcome(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	deleteInput := strings.Replace(string(inputJSON), `"markedDeleted": "false"`, `"markedDeleted": "true"`, 1)
+
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.DeleteResult{}, nil)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
+
+	outcome, err := handler.Reprocess(context.Background(), consumer.Message{Body: string(inputJSON)})
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.OutcomeIndexed, outcome)
+
+	outcome, err = handler.Reprocess(context.Background(), consumer.Message{Body: deleteInput})
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.OutcomeDeleted, outcome)
+
+	outcome, err = handler.Reprocess(context.Background(), consumer.Message{Body: "{"})
+	assert.Error(t, err)
+	assert.Equal(t, metrics.OutcomeFailed, outcome)
+}
+
+func TestHandleMessageQueuesLookupFailuresForReenrichment(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	deleteInput := strings.Replace(string(inputJSON), `"markedDeleted": "false"`, `"markedDeleted": "true"`, 1)
+
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return(&elastic.IndexResult{}, nil)
+	serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return(&elastic.DeleteResult{}, nil)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model(nil), errors.New("calling Concordance API returned HTTP status 503")).Once()
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	queue := new(reenrichQueueMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock, queue)
+
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+	model := serviceMock.Calls[0].Arguments.Get(2).(schema.IndexModel)
+	assert.True(t, model.LookupFailure)
+	assert.Equal(t, []string{"aae9611e-f66c-4fe4-a6c6-2e2bdea69060"}, queue.queued)
+
+	lookupFailure, err := handler.Reenrich(context.Background(), consumer.Message{Body: string(inputJSON)})
+	assert.NoError(t, err)
+	assert.False(t, lookupFailure)
+	assert.Empty(t, queue.resolved, "the re-enrichment queue tracks its own retries")
+
+	handler.handleMessage(consumer.Message{Body: deleteInput}, nil)
+	assert.Equal(t, []string{"aae9611e-f66c-4fe4-a6c6-2e2bdea69060"}, queue.resolved)
+}
+
+func TestHandleWriteMessageUsesLastModifiedAsVersion(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, int64(1522846680347)).Return(&elastic.IndexResult{}, nil)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+
+	serviceMock.AssertExpectations(t)
+}
+
+func TestHandleStaleMessageIsNotDeadLettered(t *testing.T) {
+	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
+	deleteInput := strings.Replace(string(inputJSON), `"markedDeleted": "false"`, `"markedDeleted": "true"`, 1)
+
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, mock.Anything).Return((*elastic.IndexResult)(nil), es.ErrStaleVersion)
+	serviceMock.On("DeleteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything).Return((*elastic.DeleteResult)(nil), es.ErrStaleVersion)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+	deadLetters := new(deadLetterStoreMock)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock, deadLetters)
+	handler.handleMessage(consumer.Message{Body: string(inputJSON)}, nil)
+	handler.handleMessage(consumer.Message{Body: deleteInput}, nil)
+
+	serviceMock.AssertExpectations(t)
+	assert.Empty(t, deadLetters.entries)
+}
+
+func TestDocumentVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    schema.EnrichedContent
+		expected int64
+	}{
+		{
+			name:     "event lastModified",
+			event:    schema.EnrichedContent{LastModified: "2018-04-04T12:58:00.347Z"},
+			expected: 1522846680347,
+		},
+		{
+			name:     "content lastModified",
+			event:    schema.EnrichedContent{Content: schema.Content{LastModified: "2018-04-04T12:58:00.347Z"}},
+			expected: 1522846680347,
+		},
+		{
+			name:     "missing lastModified",
+			event:    schema.EnrichedContent{},
+			expected: 0,
+		},
+		{
+			name:     "invalid lastModified",
+			event:    schema.EnrichedContent{LastModified: "yesterday"},
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, documentVersion(test.event))
+		})
+	}
+}
+
+func TestIndexWritesContent(t *testing.T) {
+	expect := assert.New(t)
+	var event schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &event))
+
+	serviceMock := &esServiceMock{}
+	serviceMock.On("WriteData", "FTCom", "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", mock.Anything, int64(1522846680347)).Return(&elastic.IndexResult{Created: true}, nil)
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", "tid_test", mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
+	result, err := handler.Index(context.Background(), event, map[string]string{transactionIDHeader: "tid_test"})
+
+	expect.NoError(err)
+	expect.Equal(config.ArticleType, result.ContentType)
+	expect.Equal("FTCom", result.Collection)
+	expect.Equal("aae9611e-f66c-4fe4-a6c6-2e2bdea69060", *result.Model.UID)
+	expect.True(result.Result.Created)
+	serviceMock.AssertExpectations(t)
+}
+
+func TestPreviewDoesNotWrite(t *testing.T) {
+	expect := assert.New(t)
+	var event schema.EnrichedContent
+	require.NoError(t, json.Unmarshal(tst.ReadTestResource("exampleEnrichedContentModel.json"), &event))
+
+	serviceMock := &esServiceMock{}
+	concordanceAPIMock := new(concordanceAPIMock)
+	concordanceAPIMock.On("GetConcepts", mock.AnythingOfType("string"), mock.AnythingOfType("[]string")).Return(map[string]concept.Model{}, nil)
+
+	_, handler := mockMessageHandler(defaultESClient, serviceMock, concordanceAPIMock)
+	result, err := handler.Preview(context.Background(), event, map[string]string{contentTypeHeader: "application/vnd.ft-upp-audio+json"})
+
+	expect.NoError(err)
+	expect.Equal(config.AudioType, result.ContentType)
+	expect.Equal("FTAudios", result.Collection)
+	expect.Equal(config.AudioType, *result.Model.ContentType)
+	expect.Nil(result.Result)
+	serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestIndexRejectsContentIgnoredByMessageFlow(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "unknown content type",
+			input: modifyTestInputAuthority("invalid"),
+		},
+		{
+			name:  "ignored type",
+			input: strings.Replace(string(tst.ReadTestResource("exampleEnrichedContentModel.json")), `"type": "Article"`, `"type": "Content"`, 1),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var event schema.EnrichedContent
+			require.NoError(t, json.Unmarshal([]byte(test.input), &event))
+			serviceMock := &esServiceMock{}
+
+			_, handler := mockMessageHandler(defaultESClient, serviceMock)
+			_, err := handler.Index(context.Background(), event, map[string]string{})
+
+			assert.True(t, errors.Is(err, ErrNotIndexable))
+			serviceMock.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
 func modifyTestInputAuthority(replacement string) string {
 	inputJSON := tst.ReadTestResource("exampleEnrichedContentModel.json")
 	input := strings.Replace(string(inputJSON), "FTCOM-METHODE", replacement, 1)
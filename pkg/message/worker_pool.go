@@ -0,0 +1,76 @@
+package message
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+)
+
+// workerPool runs the jobs of different keys in parallel and the jobs of the same key in the order they were dispatched,
+// as every key is always assigned to the same worker.
+type workerPool struct {
+	// mu keeps the queues from being closed while a job is being dispatched
+	mu      sync.RWMutex
+	stopped bool
+	queues  []chan func()
+	workers sync.WaitGroup
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{queues: make([]chan func(), workers)}
+	for i := range p.queues {
+		// a job waits while the previous one of its worker runs, so that the source is not read further ahead
+		queue := make(chan func())
+		p.queues[i] = queue
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range queue {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// dispatch blocks until the worker of the key takes the job over. It tells whether the job was taken over,
+// a stopped pool doesn't run any more jobs.
+func (p *workerPool) dispatch(key string, job func()) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return false
+	}
+	p.queues[p.worker(key)] <- job
+	return true
+}
+
+// stop closes the queues once the jobs being dispatched are taken over, and waits for the workers to run them.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+	p.workers.Wait()
+}
+
+func (p *workerPool) worker(key string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(p.queues)))
+}
+
+// contentUUID reads the UUID of the content of the message, the messages whose body can't be read share the empty UUID.
+func contentUUID(msg consumer.Message) string {
+	var event struct {
+		UUID string `json:"uuid"`
+	}
+	_ = json.Unmarshal([]byte(msg.Body), &event)
+	return event.UUID
+}
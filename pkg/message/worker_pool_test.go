@@ -0,0 +1,95 @@
+package message
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolKeepsOrderPerKey(t *testing.T) {
+	pool := newWorkerPool(4)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	processed := map[string][]int{}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i%5)
+		i := i
+		wg.Add(1)
+		pool.dispatch(key, func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i%3) * time.Millisecond)
+			mu.Lock()
+			processed[key] = append(processed[key], i)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	assert.Len(t, processed, 5)
+	for key, order := range processed {
+		for i := 1; i < len(order); i++ {
+			assert.Less(t, order[i-1], order[i], key)
+		}
+	}
+}
+
+func TestWorkerPoolRunsKeysInParallel(t *testing.T) {
+	pool := newWorkerPool(4)
+	first := "key-0"
+	second := ""
+	for i := 1; second == ""; i++ {
+		if key := fmt.Sprintf("key-%d", i); pool.worker(key) != pool.worker(first) {
+			second = key
+		}
+	}
+
+	// the first job only finishes once the second one runs
+	release := make(chan struct{})
+	done := make(chan struct{})
+	pool.dispatch(first, func() {
+		<-release
+		close(done)
+	})
+	pool.dispatch(second, func() {
+		close(release)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "jobs of different keys were not run in parallel")
+	}
+}
+
+func TestWorkerPoolStopWaitsForWorkers(t *testing.T) {
+	pool := newWorkerPool(2)
+	var ran []string
+	var mu sync.Mutex
+	for _, key := range []string{"key-0", "key-1"} {
+		key := key
+		assert.True(t, pool.dispatch(key, func() {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			ran = append(ran, key)
+			mu.Unlock()
+		}))
+	}
+
+	pool.stop()
+
+	assert.ElementsMatch(t, []string{"key-0", "key-1"}, ran)
+	assert.False(t, pool.dispatch("key-0", func() {
+		assert.Fail(t, "a stopped pool ran a job")
+	}))
+	// stopping again is harmless
+	pool.stop()
+}
+
+func TestContentUUID(t *testing.T) {
+	assert.Equal(t, "aae9611e-f66c-4fe4-a6c6-2e2bdea69060", contentUUID(consumer.Message{Body: `{"uuid":"aae9611e-f66c-4fe4-a6c6-2e2bdea69060","payload":{}}`}))
+	assert.Empty(t, contentUUID(consumer.Message{Body: "not json"}))
+}
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace   = "content_rw_elasticsearch"
+	pathMetrics = "/metrics"
+	unknown     = "unknown"
+	// otherOrigin labels the origins missing from knownOrigins
+	otherOrigin = "other"
+)
+
+// knownOrigins are the system codes of the origins of the indexed content, the origin label is bound to them so that
+// arbitrary Origin-System-Id headers can't blow up the number of series.
+var knownOrigins = map[string]bool{
+	"methode-web-pub":   true,
+	"wordpress":         true,
+	"next-video-editor": true,
+	"cct":               true,
+	"spark":             true,
+	"pac":               true,
+}
+
+// Message outcomes besides being received.
+const (
+	OutcomeIgnored = "ignored"
+	OutcomeIndexed = "indexed"
+	OutcomeDeleted = "deleted"
+	OutcomeFailed  = "failed"
+)
+
+// Sources of the processed messages.
+const (
+	SourceQueue    = "queue"
+	SourceReplay   = "replay"
+	SourceReindex  = "reindex"
+	SourceReenrich = "reenrich"
+)
+
+// Elasticsearch operations timed by ObserveElasticsearch.
+const (
+	OperationWrite  = "write"
+	OperationDelete = "delete"
+)
+
+var (
+	messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received from the queue, replayed, reindexed or re-enriched, by source, counted before they are processed.",
+	}, []string{"source", "origin"})
+	messageOutcomes = map[string]*prometheus.CounterVec{
+		OutcomeIgnored: newMessageCounter("messages_ignored_total", "Messages that were not indexed on purpose, e.g. synthetic or of an ignored type."),
+		OutcomeIndexed: newMessageCounter("messages_indexed_total", "Messages whose content was written to Elasticsearch."),
+		OutcomeDeleted: newMessageCounter("messages_deleted_total", "Messages whose content was deleted from Elasticsearch."),
+		OutcomeFailed:  newMessageCounter("messages_failed_total", "Messages that failed to be processed."),
+	}
+
+	mapperDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mapper_duration_seconds",
+		Help:      "Time spent mapping an event to the indexed model, including the lookups it needs.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	concordanceDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "concordance_request_duration_seconds",
+		Help:      "Latency of the requests to the Concordance API.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	concordanceCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "concordance_cache_lookups_total",
+		Help:      "Concept lookups in the concordance cache, by result (hit or miss).",
+	}, []string{"result"})
+	internalContentDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "internal_content_request_duration_seconds",
+		Help:      "Latency of the requests to internal-content-api.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	elasticsearchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "elasticsearch_request_duration_seconds",
+		Help:      "Latency of the writes and deletes sent to Elasticsearch.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	elasticsearchConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "elasticsearch_connected",
+		Help:      "Whether the Elasticsearch client is connected (1) or not (0).",
+	})
+	lookupFailuresQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "lookup_failures_queued",
+		Help:      "Documents indexed without all of their concordances whose events are queued for re-enrichment.",
+	})
+	deadLettersEvicted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_evicted_total",
+		Help:      "Dead-lettered messages evicted from the memory store once it was full, they can't be replayed anymore.",
+	})
+
+	retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Calls to Elasticsearch and the Concordance API that failed with a transient error and were retried.",
+	}, []string{"operation"})
+	retriesExhausted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_exhausted_total",
+		Help:      "Calls to Elasticsearch and the Concordance API that kept failing after the last attempt.",
+	}, []string{"operation"})
+)
+
+func init() {
+	prometheus.MustRegister(messagesReceived, mapperDuration, concordanceDuration, concordanceCacheLookups, internalContentDuration, elasticsearchDuration, elasticsearchConnected, lookupFailuresQueued, deadLettersEvicted, retries, retriesExhausted)
+	for _, counter := range messageOutcomes {
+		prometheus.MustRegister(counter)
+	}
+}
+
+func newMessageCounter(name string, help string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, []string{"source", "content_type", "origin"})
+}
+
+// AttachHTTPEndpoints exposes the metrics of the default registry for Prometheus to scrape.
+func AttachHTTPEndpoints(serveMux *http.ServeMux) *http.ServeMux {
+	serveMux.Handle(pathMetrics, promhttp.Handler())
+	return serveMux
+}
+
+// MessageReceived counts a message before it is processed. The source tells whether the message was read from the queue
+// or is processed again, the origin is the value of the Origin-System-Id header.
+func MessageReceived(source string, origin string) {
+	messagesReceived.WithLabelValues(source, originLabel(origin)).Inc()
+}
+
+// MessageProcessed counts the outcome of a message, see MessageReceived for the source and origin.
+func MessageProcessed(source string, outcome string, contentType string, origin string) {
+	if counter, found := messageOutcomes[outcome]; found {
+		counter.WithLabelValues(source, labelValue(contentType), originLabel(origin)).Inc()
+	}
+}
+
+// ObserveMapper records the time since start as the duration of a mapping.
+func ObserveMapper(start time.Time) {
+	mapperDuration.Observe(time.Since(start).Seconds())
+}
+
+// ObserveConcordance records the time since start as the latency of a Concordance API request.
+func ObserveConcordance(start time.Time) {
+	concordanceDuration.Observe(time.Since(start).Seconds())
+}
+
+// ConcordanceCacheLookup counts a concept found in the concordance cache or missing from it.
+func ConcordanceCacheLookup(hit bool) {
+	if hit {
+		concordanceCacheLookups.WithLabelValues("hit").Inc()
+		return
+	}
+	concordanceCacheLookups.WithLabelValues("miss").Inc()
+}
+
+// ObserveInternalContent records the time since start as the latency of an internal-content-api request.
+func ObserveInternalContent(start time.Time) {
+	internalContentDuration.Observe(time.Since(start).Seconds())
+}
+
+// ObserveElasticsearch records the time since start as the latency of an Elasticsearch operation.
+func ObserveElasticsearch(operation string, start time.Time) {
+	elasticsearchDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// SetElasticsearchConnected updates the connection state of the Elasticsearch client.
+func SetElasticsearchConnected(connected bool) {
+	if connected {
+		elasticsearchConnected.Set(1)
+		return
+	}
+	elasticsearchConnected.Set(0)
+}
+
+// SetLookupFailuresQueued updates the number of documents queued for re-enrichment.
+func SetLookupFailuresQueued(queued int) {
+	lookupFailuresQueued.Set(float64(queued))
+}
+
+// DeadLettersEvicted counts the dead-lettered messages evicted from the memory store.
+func DeadLettersEvicted(evicted int) {
+	deadLettersEvicted.Add(float64(evicted))
+}
+
+// RetryObserver counts the retries by operation, it is notified by the retriers.
+type RetryObserver struct{}
+
+func (RetryObserver) Retried(operation string, attempt int, err error, wait time.Duration) {
+	retries.WithLabelValues(operation).Inc()
+}
+
+func (RetryObserver) Exhausted(operation string, attempts int, err error) {
+	retriesExhausted.WithLabelValues(operation).Inc()
+}
+
+// originLabel keeps the system code of the known origins like http://cmdb.ft.com/systems/methode-web-pub,
+// the others are labelled as other.
+func originLabel(origin string) string {
+	if origin == "" {
+		return unknown
+	}
+	code := origin[strings.LastIndex(origin, "/")+1:]
+	if !knownOrigins[code] {
+		return otherOrigin
+	}
+	return code
+}
+
+func labelValue(value string) string {
+	if value == "" {
+		return unknown
+	}
+	return value
+}
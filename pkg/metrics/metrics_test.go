@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageReceived(t *testing.T) {
+	received := messagesReceived.WithLabelValues(SourceQueue, "methode-web-pub")
+	replayed := messagesReceived.WithLabelValues(SourceReplay, unknown)
+	other := messagesReceived.WithLabelValues(SourceQueue, otherOrigin)
+	receivedBefore, replayedBefore, otherBefore := testutil.ToFloat64(received), testutil.ToFloat64(replayed), testutil.ToFloat64(other)
+
+	MessageReceived(SourceQueue, "http://cmdb.ft.com/systems/methode-web-pub")
+	MessageReceived(SourceReplay, "")
+	MessageReceived(SourceQueue, "http://cmdb.ft.com/systems/made-up")
+	MessageReceived(SourceQueue, "made-up")
+
+	assert.Equal(t, receivedBefore+1, testutil.ToFloat64(received))
+	assert.Equal(t, replayedBefore+1, testutil.ToFloat64(replayed))
+	assert.Equal(t, otherBefore+2, testutil.ToFloat64(other))
+}
+
+func TestMessageProcessed(t *testing.T) {
+	indexed := messageOutcomes[OutcomeIndexed].WithLabelValues(SourceQueue, "article", "methode-web-pub")
+	failed := messageOutcomes[OutcomeFailed].WithLabelValues(SourceReplay, unknown, unknown)
+	indexedBefore, failedBefore := testutil.ToFloat64(indexed), testutil.ToFloat64(failed)
+
+	MessageProcessed(SourceQueue, OutcomeIndexed, "article", "http://cmdb.ft.com/systems/methode-web-pub")
+	MessageProcessed(SourceReplay, OutcomeFailed, "", "")
+
+	assert.Equal(t, indexedBefore+1, testutil.ToFloat64(indexed))
+	assert.Equal(t, failedBefore+1, testutil.ToFloat64(failed))
+}
+
+func TestElasticsearchConnected(t *testing.T) {
+	SetElasticsearchConnected(true)
+	assert.Equal(t, float64(1), testutil.ToFloat64(elasticsearchConnected))
+
+	SetElasticsearchConnected(false)
+	assert.Equal(t, float64(0), testutil.ToFloat64(elasticsearchConnected))
+}
+
+func TestRetryObserver(t *testing.T) {
+	retried := retries.WithLabelValues("Elasticsearch write")
+	exhausted := retriesExhausted.WithLabelValues("Elasticsearch write")
+	retriedBefore, exhaustedBefore := testutil.ToFloat64(retried), testutil.ToFloat64(exhausted)
+
+	observer := RetryObserver{}
+	observer.Retried("Elasticsearch write", 1, errors.New("timeout"), time.Second)
+	observer.Retried("Elasticsearch write", 2, errors.New("timeout"), time.Second)
+	observer.Exhausted("Elasticsearch write", 3, errors.New("timeout"))
+
+	assert.Equal(t, retriedBefore+2, testutil.ToFloat64(retried))
+	assert.Equal(t, exhaustedBefore+1, testutil.ToFloat64(exhausted))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	ObserveElasticsearch(OperationWrite, time.Now())
+	server := httptest.NewServer(AttachHTTPEndpoints(http.NewServeMux()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + pathMetrics)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `content_rw_elasticsearch_elasticsearch_request_duration_seconds_count{operation="write"}`)
+	assert.Contains(t, string(body), "content_rw_elasticsearch_elasticsearch_connected")
+}
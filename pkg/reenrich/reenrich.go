@@ -0,0 +1,284 @@
+package reenrich
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+)
+
+// Queue keeps the events of the documents indexed without all of their concordances until they are re-enriched.
+type Queue interface {
+	Enqueue(uuid string, tid string, msg consumer.Message)
+	// Resolve forgets the document, e.g. when a newer event was indexed with all of its concordances or deleted it
+	Resolve(uuid string)
+}
+
+// Processor indexes an event again and tells whether its concordances still couldn't all be looked up.
+type Processor interface {
+	Reenrich(ctx context.Context, msg consumer.Message) (lookupFailure bool, err error)
+}
+
+// Finder searches the documents flagged with lookupFailure, es.Service implements it.
+type Finder interface {
+	LookupFailures(ctx context.Context, size int) ([]string, error)
+}
+
+// Fetcher reads the current event of a document, for the flagged documents whose events are not kept.
+type Fetcher func(uuid string) (consumer.Message, error)
+
+// HealthChecker tells whether the Concordance API is available.
+type HealthChecker interface {
+	HealthCheck() (string, error)
+}
+
+const transactionIDHeader = "X-Request-Id"
+
+type Config struct {
+	// RetryInterval is how often the queued events are retried, as long as the Concordance API is healthy
+	RetryInterval time.Duration
+	// SweepInterval is how often Elasticsearch is searched for flagged documents, 0 disables the sweeper
+	SweepInterval time.Duration
+	// SweepSize bounds the number of flagged documents a sweep looks at
+	SweepSize int
+	// MaxQueued bounds the number of documents whose events are kept, 0 keeps them all
+	MaxQueued int
+}
+
+// Reenricher indexes the documents flagged with lookupFailure again once the Concordance API recovers.
+// The queued events are retried whenever the Concordance API is healthy, and a sweeper periodically
+// retries the documents Elasticsearch still holds flagged, fetching the events that are not kept.
+type Reenricher struct {
+	store          Store
+	finder         Finder
+	fetch          Fetcher
+	concordanceAPI HealthChecker
+	config         Config
+	log            *logger.UPPLogger
+
+	mu sync.Mutex
+	// queued holds the time the kept event of each document was queued at
+	queued map[string]time.Time
+	// ctx is cancelled by Stop, interrupting the retries in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewReenricher(store Store, finder Finder, fetch Fetcher, concordanceAPI HealthChecker, config Config, log *logger.UPPLogger) (*Reenricher, error) {
+	entries, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	queued := make(map[string]time.Time, len(entries))
+	for _, entry := range entries {
+		queued[entry.UUID] = entry.Queued
+	}
+	metrics.SetLookupFailuresQueued(len(queued))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reenricher{
+		store:          store,
+		finder:         finder,
+		fetch:          fetch,
+		concordanceAPI: concordanceAPI,
+		config:         config,
+		log:            log,
+		queued:         queued,
+		ctx:            ctx,
+		cancel:         cancel,
+	}, nil
+}
+
+func (r *Reenricher) Enqueue(uuid string, tid string, msg consumer.Message) {
+	entry := Entry{UUID: uuid, TransactionID: tid, Queued: time.Now().UTC(), Headers: msg.Headers, Body: msg.Body}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log := r.log.WithTransactionID(tid).WithUUID(uuid)
+	if _, found := r.queued[uuid]; !found && r.config.MaxQueued > 0 && len(r.queued) >= r.config.MaxQueued {
+		// the document stays flagged in Elasticsearch, the sweeper re-enriches it from its current event
+		log.Warnf("Re-enrichment queue is full with %d documents, content is left to the sweeper", len(r.queued))
+		return
+	}
+	if err := r.store.Put(entry); err != nil {
+		log.WithError(err).Error("Failed to queue content for re-enrichment")
+		return
+	}
+	r.queued[uuid] = entry.Queued
+	metrics.SetLookupFailuresQueued(len(r.queued))
+	log.Info("Content queued for re-enrichment")
+}
+
+func (r *Reenricher) Resolve(uuid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.queued[uuid]; !found {
+		return
+	}
+	r.remove(uuid)
+}
+
+// remove forgets the document, it must be called with the lock held.
+func (r *Reenricher) remove(uuid string) {
+	if err := r.store.Remove(uuid); err != nil {
+		r.log.WithUUID(uuid).WithError(err).Error("Failed to remove content from the re-enrichment queue")
+		return
+	}
+	delete(r.queued, uuid)
+	metrics.SetLookupFailuresQueued(len(r.queued))
+}
+
+// Start retries the queued events in the background until Stop is called.
+func (r *Reenricher) Start(processor Processor) {
+	go func() {
+		retryTicker := time.NewTicker(r.config.RetryInterval)
+		defer retryTicker.Stop()
+		var sweeps <-chan time.Time
+		if r.config.SweepInterval > 0 {
+			sweepTicker := time.NewTicker(r.config.SweepInterval)
+			defer sweepTicker.Stop()
+			sweeps = sweepTicker.C
+		}
+
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-retryTicker.C:
+				r.retryQueued(processor)
+			case <-sweeps:
+				r.sweep(processor)
+			}
+		}
+	}()
+}
+
+func (r *Reenricher) Stop() {
+	r.cancel()
+}
+
+// retryQueued retries every queued event, unless the Concordance API is still unavailable.
+func (r *Reenricher) retryQueued(processor Processor) {
+	r.mu.Lock()
+	pending := len(r.queued)
+	r.mu.Unlock()
+	if pending == 0 {
+		return
+	}
+	if _, err := r.concordanceAPI.HealthCheck(); err != nil {
+		r.log.WithError(err).Infof("Re-enrichment of %d documents postponed, the Concordance API is unavailable", pending)
+		return
+	}
+
+	entries, err := r.store.List()
+	if err != nil {
+		r.log.WithError(err).Error("Failed to read the re-enrichment queue")
+		return
+	}
+	r.retry(processor, entries)
+}
+
+// sweep retries the documents Elasticsearch holds flagged. The events of the ones that are not kept, e.g. indexed by another
+// instance, are fetched and queued when they still can't be re-enriched.
+func (r *Reenricher) sweep(processor Processor) {
+	uuids, err := r.finder.LookupFailures(r.ctx, r.config.SweepSize)
+	if err != nil {
+		r.log.WithError(err).Error("Failed to search the documents flagged with lookupFailure")
+		return
+	}
+	entries, err := r.store.List()
+	if err != nil {
+		r.log.WithError(err).Error("Failed to read the re-enrichment queue")
+		return
+	}
+	kept := make(map[string]Entry, len(entries))
+	for _, entry := range entries {
+		kept[entry.UUID] = entry
+	}
+
+	var due []Entry
+	var missing []string
+	for _, uuid := range uuids {
+		if entry, found := kept[uuid]; found {
+			due = append(due, entry)
+			continue
+		}
+		missing = append(missing, uuid)
+	}
+	r.log.Infof("Sweep found %d documents flagged with lookupFailure, %d of them are not queued", len(uuids), len(missing))
+	r.retry(processor, due)
+	for _, uuid := range missing {
+		if r.ctx.Err() != nil {
+			return
+		}
+		r.retryFetched(processor, uuid)
+	}
+}
+
+// retryFetched re-enriches a flagged document from its current event and queues it when it still can't be re-enriched.
+func (r *Reenricher) retryFetched(processor Processor, uuid string) {
+	log := r.log.WithUUID(uuid)
+	if r.fetch == nil {
+		log.Warn("Document flagged with lookupFailure cannot be re-enriched, its event is not kept")
+		return
+	}
+	msg, err := r.fetch(uuid)
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch the event of a document flagged with lookupFailure")
+		return
+	}
+	lookupFailure, err := processor.Reenrich(r.ctx, msg)
+	switch {
+	case err != nil:
+		log.WithError(err).Warn("Re-enrichment failed")
+	case lookupFailure:
+		r.Enqueue(uuid, msg.Headers[transactionIDHeader], msg)
+	default:
+		log.Info("Content re-enriched")
+	}
+}
+
+func (r *Reenricher) retry(processor Processor, entries []Entry) {
+	for _, entry := range entries {
+		if r.ctx.Err() != nil {
+			return
+		}
+		lookupFailure, err := processor.Reenrich(r.ctx, entry.Message())
+		if err != nil && r.ctx.Err() != nil {
+			// stopped meanwhile, the interrupted attempt is not counted
+			return
+		}
+		log := r.log.WithTransactionID(entry.TransactionID).WithUUID(entry.UUID)
+
+		r.mu.Lock()
+		if queued, found := r.queued[entry.UUID]; !found || !queued.Equal(entry.Queued) {
+			// a newer event was indexed meanwhile, it is tracked on its own
+			r.mu.Unlock()
+			continue
+		}
+		switch {
+		case err != nil:
+			log.WithError(err).Warn("Re-enrichment failed")
+			r.retried(entry)
+		case lookupFailure:
+			log.Info("Re-enrichment still could not look up all concordances")
+			r.retried(entry)
+		default:
+			log.Infof("Content re-enriched after %d attempts", entry.Attempts+1)
+			r.remove(entry.UUID)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// retried counts the attempt, it must be called with the lock held.
+func (r *Reenricher) retried(entry Entry) {
+	entry.Attempts++
+	if err := r.store.Put(entry); err != nil {
+		r.log.WithUUID(entry.UUID).WithError(err).Error("Failed to update the re-enrichment queue")
+	}
+}
@@ -0,0 +1,267 @@
+package reenrich
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type processorMock struct {
+	mock.Mock
+}
+
+func (p *processorMock) Reenrich(ctx context.Context, msg consumer.Message) (bool, error) {
+	args := p.Called(msg.Body)
+	return args.Bool(0), args.Error(1)
+}
+
+type finderMock struct {
+	mock.Mock
+}
+
+func (f *finderMock) LookupFailures(ctx context.Context, size int) ([]string, error) {
+	args := f.Called(size)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+type healthCheckerMock struct {
+	err error
+}
+
+func (h *healthCheckerMock) HealthCheck() (string, error) {
+	if h.err != nil {
+		return "", h.err
+	}
+	return "Concordance API is healthy", nil
+}
+
+func newTestFileStore(t *testing.T) (*FileStore, func()) {
+	dir, err := ioutil.TempDir("", "reenrich")
+	require.NoError(t, err)
+	store, err := NewFileStore(filepath.Join(dir, "nested", "lookup-failures.jsonl"))
+	require.NoError(t, err)
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func newTestReenricher(t *testing.T, store Store, finder Finder, concordanceAPI HealthChecker) *Reenricher {
+	reenricher, err := NewReenricher(store, finder, nil, concordanceAPI, Config{SweepSize: 100}, logger.NewUPPLogger("test", "PANIC"))
+	require.NoError(t, err)
+	return reenricher
+}
+
+func uuids(t *testing.T, store Store) []string {
+	entries, err := store.List()
+	require.NoError(t, err)
+	ids := []string{}
+	for _, entry := range entries {
+		ids = append(ids, entry.UUID)
+	}
+	return ids
+}
+
+func TestFileStorePutReplacesEntryOfDocument(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	require.NoError(t, store.Put(Entry{UUID: "1", Body: "first"}))
+	require.NoError(t, store.Put(Entry{UUID: "2", Body: "second"}))
+	require.NoError(t, store.Put(Entry{UUID: "1", Body: "newer", Attempts: 2}))
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{UUID: "1", Body: "newer", Attempts: 2}, {UUID: "2", Body: "second"}}, entries)
+
+	require.NoError(t, store.Remove("1"))
+	require.NoError(t, store.Remove("unknown"))
+	assert.Equal(t, []string{"2"}, uuids(t, store))
+}
+
+func TestFileStoreCompactsOutdatedLines(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	for attempts := 0; attempts < 3*minCompactLines; attempts++ {
+		require.NoError(t, store.Put(Entry{UUID: "1", Attempts: attempts}))
+	}
+	require.NoError(t, store.Put(Entry{UUID: "2"}))
+	require.NoError(t, store.Remove("1"))
+	require.NoError(t, store.Put(Entry{UUID: "1", Body: "requeued"}))
+
+	entries, lines, err := store.read()
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{UUID: "2"}, {UUID: "1", Body: "requeued"}}, entries)
+	assert.True(t, lines <= 2*len(entries)+minCompactLines, "the file holds %d lines", lines)
+}
+
+func TestMemoryStorePutReplacesEntryOfDocument(t *testing.T) {
+	store := NewMemoryStore()
+
+	require.NoError(t, store.Put(Entry{UUID: "1", Body: "first"}))
+	require.NoError(t, store.Put(Entry{UUID: "2", Body: "second"}))
+	require.NoError(t, store.Put(Entry{UUID: "1", Body: "newer", Attempts: 2}))
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{UUID: "1", Body: "newer", Attempts: 2}, {UUID: "2", Body: "second"}}, entries)
+
+	require.NoError(t, store.Remove("1"))
+	require.NoError(t, store.Remove("unknown"))
+	assert.Equal(t, []string{"2"}, uuids(t, store))
+}
+
+func TestReenricherRetriesOnceConcordanceAPIRecovers(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	concordanceAPI := &healthCheckerMock{err: errors.New("service unavailable")}
+	processor := new(processorMock)
+	processor.On("Reenrich", `{"uuid":"1"}`).Return(false, nil).Once()
+	processor.On("Reenrich", `{"uuid":"2"}`).Return(true, nil).Once()
+	reenricher := newTestReenricher(t, store, new(finderMock), concordanceAPI)
+
+	reenricher.Enqueue("1", "tid_1", consumer.Message{Body: `{"uuid":"1"}`})
+	reenricher.Enqueue("2", "tid_2", consumer.Message{Body: `{"uuid":"2"}`})
+
+	reenricher.retryQueued(processor)
+	processor.AssertNotCalled(t, "Reenrich", mock.Anything)
+
+	concordanceAPI.err = nil
+	reenricher.retryQueued(processor)
+
+	processor.AssertExpectations(t)
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "2", entries[0].UUID)
+	assert.Equal(t, 1, entries[0].Attempts)
+}
+
+func TestReenricherResolve(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	reenricher := newTestReenricher(t, store, new(finderMock), &healthCheckerMock{})
+
+	reenricher.Enqueue("1", "tid_1", consumer.Message{Body: `{"uuid":"1"}`})
+	reenricher.Resolve("1")
+	reenricher.Resolve("unknown")
+
+	assert.Empty(t, uuids(t, store))
+}
+
+func TestReenricherLeavesContentBeyondMaxQueuedToTheSweeper(t *testing.T) {
+	store := NewMemoryStore()
+	reenricher, err := NewReenricher(store, new(finderMock), nil, &healthCheckerMock{}, Config{MaxQueued: 2}, logger.NewUPPLogger("test", "PANIC"))
+	require.NoError(t, err)
+
+	reenricher.Enqueue("1", "tid_1", consumer.Message{Body: `{"uuid":"1"}`})
+	reenricher.Enqueue("2", "tid_2", consumer.Message{Body: `{"uuid":"2"}`})
+	reenricher.Enqueue("3", "tid_3", consumer.Message{Body: `{"uuid":"3"}`})
+	// a newer event of a queued document still replaces the kept one
+	reenricher.Enqueue("2", "tid_4", consumer.Message{Body: `{"uuid":"2","newer":true}`})
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "1", entries[0].UUID)
+	assert.Equal(t, `{"uuid":"2","newer":true}`, entries[1].Body)
+}
+
+func TestReenricherLoadsQueueOfPreviousRun(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	require.NoError(t, store.Put(Entry{UUID: "1", Body: `{"uuid":"1"}`}))
+	processor := new(processorMock)
+	processor.On("Reenrich", `{"uuid":"1"}`).Return(false, nil).Once()
+
+	reenricher := newTestReenricher(t, store, new(finderMock), &healthCheckerMock{})
+	reenricher.retryQueued(processor)
+
+	processor.AssertExpectations(t)
+	assert.Empty(t, uuids(t, store))
+}
+
+func TestReenricherSweepRetriesFlaggedDocuments(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	finder := new(finderMock)
+	finder.On("LookupFailures", 100).Return([]string{"1", "3"}, nil)
+	processor := new(processorMock)
+	processor.On("Reenrich", `{"uuid":"1"}`).Return(false, nil).Once()
+	// the sweep does not wait for the Concordance API to be reported healthy
+	reenricher := newTestReenricher(t, store, finder, &healthCheckerMock{err: errors.New("service unavailable")})
+
+	reenricher.Enqueue("1", "tid_1", consumer.Message{Body: `{"uuid":"1"}`})
+	reenricher.Enqueue("2", "tid_2", consumer.Message{Body: `{"uuid":"2"}`})
+	reenricher.sweep(processor)
+
+	processor.AssertExpectations(t)
+	assert.Equal(t, []string{"2"}, uuids(t, store))
+}
+
+func TestReenricherSweepFetchesEventsThatAreNotKept(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	finder := new(finderMock)
+	finder.On("LookupFailures", 100).Return([]string{"1", "2", "3"}, nil)
+	processor := new(processorMock)
+	processor.On("Reenrich", `{"uuid":"1"}`).Return(false, nil).Once()
+	processor.On("Reenrich", `{"uuid":"2"}`).Return(true, nil).Once()
+	reenricher := newTestReenricher(t, store, finder, &healthCheckerMock{})
+	reenricher.fetch = func(uuid string) (consumer.Message, error) {
+		if uuid == "3" {
+			return consumer.Message{}, errors.New("status 404")
+		}
+		return consumer.Message{Body: `{"uuid":"` + uuid + `"}`}, nil
+	}
+
+	reenricher.sweep(processor)
+
+	processor.AssertExpectations(t)
+	assert.Equal(t, []string{"2"}, uuids(t, store))
+}
+
+func TestReenricherKeepsNewerEventQueuedDuringRetry(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	reenricher := newTestReenricher(t, store, new(finderMock), &healthCheckerMock{})
+	reenricher.Enqueue("1", "tid_1", consumer.Message{Body: `{"uuid":"1","version":1}`})
+
+	processor := new(processorMock)
+	processor.On("Reenrich", `{"uuid":"1","version":1}`).Return(false, nil).Run(func(mock.Arguments) {
+		reenricher.Enqueue("1", "tid_2", consumer.Message{Body: `{"uuid":"1","version":2}`})
+	}).Once()
+	reenricher.retryQueued(processor)
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, `{"uuid":"1","version":2}`, entries[0].Body)
+}
+
+func TestReenricherStopInterruptsRetries(t *testing.T) {
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+	reenricher := newTestReenricher(t, store, new(finderMock), &healthCheckerMock{})
+	reenricher.Enqueue("1", "tid_1", consumer.Message{Body: `{"uuid":"1"}`})
+	reenricher.Enqueue("2", "tid_2", consumer.Message{Body: `{"uuid":"2"}`})
+
+	processor := new(processorMock)
+	processor.On("Reenrich", `{"uuid":"1"}`).Return(false, context.Canceled).Run(func(mock.Arguments) {
+		reenricher.Stop()
+	}).Once()
+	reenricher.retryQueued(processor)
+
+	processor.AssertExpectations(t)
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Zero(t, entries[0].Attempts)
+}
@@ -0,0 +1,234 @@
+package reenrich
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+)
+
+// Entry is the last event of a document that was indexed without all of its concordances.
+type Entry struct {
+	UUID          string            `json:"uuid"`
+	TransactionID string            `json:"transactionId"`
+	Queued        time.Time         `json:"queued"`
+	Attempts      int               `json:"attempts"`
+	Headers       map[string]string `json:"headers"`
+	Body          string            `json:"body"`
+}
+
+// Message rebuilds the original queue message.
+func (e Entry) Message() consumer.Message {
+	return consumer.Message{Headers: e.Headers, Body: e.Body}
+}
+
+// Store keeps one entry per document until it is re-enriched.
+type Store interface {
+	// Put adds the entry or replaces the one of the same document
+	Put(entry Entry) error
+	List() ([]Entry, error)
+	// Remove forgets the entry of the document, if any
+	Remove(uuid string) error
+}
+
+// FileStore keeps the entries in a file, so that they survive restarts. Every change is appended to the file as a JSON
+// line, the last line of a document wins, and the file is compacted once most of its lines are outdated.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	// lines is the number of lines of the file, which is compacted once it reaches compactAt
+	lines     int
+	compactAt int
+}
+
+// fileLine is a line of the file, either the entry of a document or the removal of its entry.
+type fileLine struct {
+	Entry
+	Removed bool `json:"removed,omitempty"`
+}
+
+// minCompactLines keeps small files from being compacted on every change.
+const minCompactLines = 100
+
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{path: path}, nil
+}
+
+func (s *FileStore) Put(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(fileLine{Entry: entry})
+}
+
+func (s *FileStore) List() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, _, err := s.read()
+	return entries, err
+}
+
+func (s *FileStore) Remove(uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(fileLine{Entry: Entry{UUID: uuid}, Removed: true})
+}
+
+func (s *FileStore) append(line fileLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	s.lines++
+	if s.lines < s.compactAt {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrites the file with one line per document.
+func (s *FileStore) compact() error {
+	entries, _, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err = s.write(entries); err != nil {
+		return err
+	}
+	s.lines = len(entries)
+	s.compactAt = 2*len(entries) + minCompactLines
+	return nil
+}
+
+// read folds the lines of the file into the entries of the documents, in the order they were first queued,
+// and returns the number of lines as well.
+func (s *FileStore) read() ([]Entry, int, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return []Entry{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	lines := 0
+	var folded []fileLine
+	// index holds the position of the entries that are not removed
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	// message bodies can be far bigger than the default token size of the scanner
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line fileLine
+		if err = json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, 0, err
+		}
+		lines++
+		i, found := index[line.UUID]
+		switch {
+		case found && line.Removed:
+			folded[i].Removed = true
+			delete(index, line.UUID)
+		case found:
+			folded[i] = line
+		case !line.Removed:
+			index[line.UUID] = len(folded)
+			folded = append(folded, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	entries := []Entry{}
+	for _, line := range folded {
+		if !line.Removed {
+			entries = append(entries, line.Entry)
+		}
+	}
+	return entries, lines, nil
+}
+
+func (s *FileStore) write(entries []Entry) error {
+	var lines []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, append(line, '\n')...)
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated store behind
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, lines, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// MemoryStore keeps the entries until the service stops, it is used when no file is configured.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]Entry{}}
+}
+
+func (s *MemoryStore) Put(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.UUID] = entry
+	return nil
+}
+
+// List returns the entries in the order they were queued.
+func (s *MemoryStore) List() ([]Entry, error) {
+	s.mu.Lock()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Queued.Equal(entries[j].Queued) {
+			return entries[i].Queued.Before(entries[j].Queued)
+		}
+		return entries[i].UUID < entries[j].UUID
+	})
+	return entries, nil
+}
+
+func (s *MemoryStore) Remove(uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, uuid)
+	return nil
+}
@@ -0,0 +1,143 @@
+package reindex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpoint tracks the lines of every input that are done, so that an interrupted reindex resumes where it stopped.
+// Lines are processed concurrently, only the lines up to the first one still in flight count as done.
+// Failed lines count as done for the progress but are recorded apart, so that the next run retries them.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	// Done is the number of leading lines of each input that are done
+	Done map[string]int `json:"done"`
+	// Failed are the lines of each input within Done that failed
+	Failed map[string]map[int]bool `json:"failed,omitempty"`
+	// Completed inputs are skipped altogether
+	Completed map[string]bool `json:"completed"`
+	ahead     map[string]map[int]bool
+}
+
+// loadCheckpoint reads the checkpoint file, a missing file starts from scratch. Without path nothing is saved.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, Done: make(map[string]int), Failed: make(map[string]map[int]bool), Completed: make(map[string]bool), ahead: make(map[string]map[int]bool)}
+	if path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Done == nil {
+		c.Done = make(map[string]int)
+	}
+	if c.Failed == nil {
+		c.Failed = make(map[string]map[int]bool)
+	}
+	if c.Completed == nil {
+		c.Completed = make(map[string]bool)
+	}
+	return c, nil
+}
+
+func (c *checkpoint) completed(input string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Completed[input]
+}
+
+// done tells whether the line of the input was done by a previous run without failing.
+func (c *checkpoint) done(input string, line int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return line <= c.Done[input] && !c.Failed[input][line]
+}
+
+// markDone records a line, lines are numbered from 1. A failed line retried successfully is no longer failed.
+func (c *checkpoint) markDone(input string, line int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Failed[input][line] {
+		delete(c.Failed[input], line)
+		if len(c.Failed[input]) == 0 {
+			delete(c.Failed, input)
+		}
+	}
+	c.advance(input, line)
+}
+
+// markFailed records a line that failed, it is retried by the next run.
+func (c *checkpoint) markFailed(input string, line int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Failed[input] == nil {
+		c.Failed[input] = make(map[int]bool)
+	}
+	c.Failed[input][line] = true
+	c.advance(input, line)
+}
+
+func (c *checkpoint) advance(input string, line int) {
+	if line <= c.Done[input] {
+		return
+	}
+	if c.ahead[input] == nil {
+		c.ahead[input] = make(map[int]bool)
+	}
+	c.ahead[input][line] = true
+	for c.ahead[input][c.Done[input]+1] {
+		delete(c.ahead[input], c.Done[input]+1)
+		c.Done[input]++
+	}
+}
+
+// markCompleted records an input once all its lines are done, unless some of them failed.
+func (c *checkpoint) markCompleted(input string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.Failed[input]) > 0 {
+		return
+	}
+	c.Completed[input] = true
+	delete(c.Done, input)
+	delete(c.ahead, input)
+}
+
+// save replaces the checkpoint file atomically.
+func (c *checkpoint) save() error {
+	if c.path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	data, err := json.Marshal(c)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
@@ -0,0 +1,81 @@
+package reindex
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Stdin is the input path reading the standard input.
+const Stdin = "-"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// inputs lists the files to read: all the files of a directory sorted by name, a single file or stdin.
+func inputs(path string) ([]string, error) {
+	if path == Stdin {
+		return []string{Stdin}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, filepath.Join(path, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// open reads the input, gzip compressed inputs are recognised by their content whatever their name.
+func open(input string, stdin io.Reader) (io.ReadCloser, error) {
+	in := ioutil.NopCloser(stdin)
+	if input != Stdin {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, err
+		}
+		in = f
+	}
+
+	buffered := bufio.NewReader(in)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if string(magic) != string(gzipMagic) {
+		return readCloser{Reader: buffered, close: in.Close}, nil
+	}
+	unzipped, err := gzip.NewReader(buffered)
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	return readCloser{Reader: unzipped, close: func() error {
+		_ = unzipped.Close()
+		return in.Close()
+	}}, nil
+}
@@ -0,0 +1,237 @@
+package reindex
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+)
+
+// maxListedFailures bounds the failures kept for the summary, the others are only counted and logged.
+const maxListedFailures = 1000
+
+// Processor runs an event through the message flow and returns its outcome.
+type Processor interface {
+	Reprocess(ctx context.Context, msg consumer.Message) (string, error)
+}
+
+type Config struct {
+	Workers          int
+	CheckpointFile   string
+	ProgressInterval time.Duration
+}
+
+// Failure is an event that could not be reindexed.
+type Failure struct {
+	Input string `json:"input"`
+	Line  int    `json:"line"`
+	UUID  string `json:"uuid,omitempty"`
+	Error string `json:"error"`
+}
+
+// Summary counts the outcomes of a reindex. Resumed are the events already done by a previous run.
+type Summary struct {
+	Read     int64         `json:"read"`
+	Resumed  int64         `json:"resumed"`
+	Indexed  int64         `json:"indexed"`
+	Deleted  int64         `json:"deleted"`
+	Ignored  int64         `json:"ignored"`
+	Failed   int64         `json:"failed"`
+	Failures []Failure     `json:"failures,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Reindexer rebuilds the index from exported combined post publication events, one JSON event per line.
+type Reindexer struct {
+	processor Processor
+	config    Config
+	stdin     io.Reader
+	log       *logger.UPPLogger
+}
+
+type job struct {
+	input string
+	line  int
+	body  string
+}
+
+func NewReindexer(processor Processor, config Config, log *logger.UPPLogger) *Reindexer {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	return &Reindexer{processor: processor, config: config, stdin: os.Stdin, log: log}
+}
+
+// Run reindexes all the events of the path, a directory, a file or stdin. Plain and gzip compressed files are read.
+// The checkpoint is saved regularly and once done, so that an interrupted run can be resumed and the failed events retried.
+// Cancelling ctx interrupts the run, the events that weren't reindexed are left for the next one.
+func (r *Reindexer) Run(ctx context.Context, path string) (*Summary, error) {
+	start := time.Now()
+	files, err := inputs(path)
+	if err != nil {
+		return nil, err
+	}
+	progress, err := loadCheckpoint(r.config.CheckpointFile)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{}
+	var failuresMu sync.Mutex
+	jobs := make(chan job, r.config.Workers)
+	var workers sync.WaitGroup
+	for i := 0; i < r.config.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				failure, interrupted := r.reindex(ctx, j, summary)
+				if interrupted {
+					continue
+				}
+				if failure != nil {
+					failuresMu.Lock()
+					if len(summary.Failures) < maxListedFailures {
+						summary.Failures = append(summary.Failures, *failure)
+					}
+					failuresMu.Unlock()
+					progress.markFailed(j.input, j.line)
+					continue
+				}
+				progress.markDone(j.input, j.line)
+			}
+		}()
+	}
+
+	stopProgress := r.reportProgress(summary, progress, start)
+	var readErr error
+	for _, input := range files {
+		if progress.completed(input) {
+			r.log.Infof("Skipping %s, it was reindexed by a previous run", input)
+			continue
+		}
+		if readErr = r.read(ctx, input, jobs, progress, summary); readErr != nil {
+			break
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	stopProgress()
+	if readErr == nil {
+		readErr = ctx.Err()
+	}
+
+	// only inputs read to the end are completed, once their last line is done and none of their lines failed
+	if readErr == nil {
+		for _, input := range files {
+			progress.markCompleted(input)
+		}
+	}
+	if err = progress.save(); err != nil {
+		r.log.WithError(err).Error("Could not save reindex checkpoint")
+	}
+	summary.Duration = time.Since(start)
+	return summary, readErr
+}
+
+func (r *Reindexer) read(ctx context.Context, input string, jobs chan<- job, progress *checkpoint, summary *Summary) error {
+	in, err := open(input, r.stdin)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(in)
+	// events can be far bigger than the default token size of the scanner
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if progress.done(input, line) {
+			atomic.AddInt64(&summary.Resumed, 1)
+			continue
+		}
+		if len(scanner.Bytes()) == 0 {
+			progress.markDone(input, line)
+			continue
+		}
+		select {
+		case jobs <- job{input: input, line: line, body: scanner.Text()}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+// reindex processes the event of a line, interrupted tells that the run was interrupted before it was done.
+func (r *Reindexer) reindex(ctx context.Context, j job, summary *Summary) (failure *Failure, interrupted bool) {
+	outcome, err := r.processor.Reprocess(ctx, consumer.Message{Headers: map[string]string{}, Body: j.body})
+	if err != nil && ctx.Err() != nil {
+		return nil, true
+	}
+	atomic.AddInt64(&summary.Read, 1)
+	if err != nil {
+		atomic.AddInt64(&summary.Failed, 1)
+		var event struct {
+			UUID string `json:"uuid"`
+		}
+		_ = json.Unmarshal([]byte(j.body), &event)
+		r.log.WithError(err).WithUUID(event.UUID).Errorf("Failed to reindex line %d of %s", j.line, j.input)
+		return &Failure{Input: j.input, Line: j.line, UUID: event.UUID, Error: err.Error()}, false
+	}
+
+	switch outcome {
+	case metrics.OutcomeIndexed:
+		atomic.AddInt64(&summary.Indexed, 1)
+	case metrics.OutcomeDeleted:
+		atomic.AddInt64(&summary.Deleted, 1)
+	default:
+		atomic.AddInt64(&summary.Ignored, 1)
+	}
+	return nil, false
+}
+
+// reportProgress logs the counts and saves the checkpoint at every interval, until the returned func is called.
+func (r *Reindexer) reportProgress(summary *Summary, progress *checkpoint, start time.Time) func() {
+	if r.config.ProgressInterval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(r.config.ProgressInterval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				read := atomic.LoadInt64(&summary.Read)
+				r.log.Infof("Reindexed %d events (%.1f/s): %d indexed, %d deleted, %d ignored, %d failed",
+					read, float64(read)/time.Since(start).Seconds(), atomic.LoadInt64(&summary.Indexed), atomic.LoadInt64(&summary.Deleted),
+					atomic.LoadInt64(&summary.Ignored), atomic.LoadInt64(&summary.Failed))
+				if err := progress.save(); err != nil {
+					r.log.WithError(err).Error("Could not save reindex checkpoint")
+				}
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
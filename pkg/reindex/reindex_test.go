@@ -0,0 +1,217 @@
+package reindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/content-rw-elasticsearch/v2/pkg/metrics"
+)
+
+// processorMock indexes every event except the ones listed as failing.
+type processorMock struct {
+	mu        sync.Mutex
+	processed []string
+	failing   map[string]bool
+	outcomes  map[string]string
+	// interruptAt cancels the run while the event is processed
+	interruptAt string
+	interrupt   context.CancelFunc
+}
+
+func (p *processorMock) Reprocess(ctx context.Context, msg consumer.Message) (string, error) {
+	var event struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal([]byte(msg.Body), &event); err != nil {
+		return metrics.OutcomeFailed, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed = append(p.processed, event.UUID)
+	if event.UUID == p.interruptAt {
+		p.interrupt()
+		return metrics.OutcomeFailed, ctx.Err()
+	}
+	if p.failing[event.UUID] {
+		return metrics.OutcomeFailed, errors.New("elastic: timeout")
+	}
+	if outcome, found := p.outcomes[event.UUID]; found {
+		return outcome, nil
+	}
+	return metrics.OutcomeIndexed, nil
+}
+
+func events(uuids ...string) string {
+	var lines []string
+	for _, uuid := range uuids {
+		lines = append(lines, `{"uuid":"`+uuid+`"}`)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "reindex")
+	require.NoError(t, err)
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestReindexDirectory(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1.jsonl"), []byte(events("a", "b")+"\n"+"{"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "2.jsonl.gz"), gzipped(t, events("c", "d", "e")), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0700))
+
+	processor := &processorMock{failing: map[string]bool{"d": true}, outcomes: map[string]string{"e": metrics.OutcomeIgnored}}
+	summary, err := NewReindexer(processor, Config{Workers: 3}, logger.NewUPPLogger("test", "PANIC")).Run(context.Background(), dir)
+
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d", "e"}, processor.processed)
+	assert.Equal(t, int64(6), summary.Read)
+	assert.Equal(t, int64(3), summary.Indexed)
+	assert.Equal(t, int64(1), summary.Ignored)
+	assert.Equal(t, int64(2), summary.Failed)
+	assert.ElementsMatch(t, []Failure{
+		{Input: filepath.Join(dir, "1.jsonl"), Line: 4, Error: "unexpected end of JSON input"},
+		{Input: filepath.Join(dir, "2.jsonl.gz"), Line: 2, UUID: "d", Error: "elastic: timeout"},
+	}, summary.Failures)
+}
+
+func TestReindexStdin(t *testing.T) {
+	processor := &processorMock{}
+	reindexer := NewReindexer(processor, Config{}, logger.NewUPPLogger("test", "PANIC"))
+	reindexer.stdin = bytes.NewReader(gzipped(t, events("a", "b")))
+
+	summary, err := reindexer.Run(context.Background(), Stdin)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, processor.processed)
+	assert.Equal(t, int64(2), summary.Indexed)
+}
+
+func TestReindexResumesFromCheckpoint(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	inputDir := filepath.Join(dir, "events")
+	require.NoError(t, os.Mkdir(inputDir, 0700))
+	first := filepath.Join(inputDir, "1.jsonl")
+	second := filepath.Join(inputDir, "2.jsonl")
+	require.NoError(t, ioutil.WriteFile(first, []byte(events("a", "b")), 0600))
+	require.NoError(t, ioutil.WriteFile(second, []byte(events("c", "d", "e")), 0600))
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	// a previous run completed the first file and the first two lines of the second one
+	require.NoError(t, ioutil.WriteFile(checkpointFile, []byte(`{"done":{"`+second+`":2},"completed":{"`+first+`":true}}`), 0600))
+
+	processor := &processorMock{}
+	summary, err := NewReindexer(processor, Config{Workers: 2, CheckpointFile: checkpointFile}, logger.NewUPPLogger("test", "PANIC")).Run(context.Background(), inputDir)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e"}, processor.processed)
+	assert.Equal(t, int64(2), summary.Resumed)
+	assert.Equal(t, int64(1), summary.Indexed)
+
+	saved, err := loadCheckpoint(checkpointFile)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{first: true, second: true}, saved.Completed)
+	assert.Empty(t, saved.Done)
+}
+
+func TestReindexInterruptedIsResumable(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	input := filepath.Join(dir, "1.jsonl")
+	require.NoError(t, ioutil.WriteFile(input, []byte(events("a", "b", "c")), 0600))
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processor := &processorMock{interruptAt: "b", interrupt: cancel}
+	summary, err := NewReindexer(processor, Config{Workers: 1, CheckpointFile: checkpointFile}, logger.NewUPPLogger("test", "PANIC")).Run(ctx, input)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []string{"a", "b"}, processor.processed)
+	assert.Equal(t, int64(1), summary.Indexed)
+	assert.Zero(t, summary.Failed)
+
+	saved, err := loadCheckpoint(checkpointFile)
+	require.NoError(t, err)
+	assert.Equal(t, 1, saved.Done[input])
+	assert.False(t, saved.completed(input))
+}
+
+func TestReindexRetriesFailedEventsOnResume(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	input := filepath.Join(dir, "1.jsonl")
+	require.NoError(t, ioutil.WriteFile(input, []byte(events("a", "b", "c")), 0600))
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	config := Config{Workers: 2, CheckpointFile: checkpointFile}
+
+	processor := &processorMock{failing: map[string]bool{"b": true}}
+	summary, err := NewReindexer(processor, config, logger.NewUPPLogger("test", "PANIC")).Run(context.Background(), input)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Failed)
+	saved, err := loadCheckpoint(checkpointFile)
+	require.NoError(t, err)
+	assert.Equal(t, 3, saved.Done[input])
+	assert.Equal(t, map[int]bool{2: true}, saved.Failed[input])
+	assert.False(t, saved.completed(input))
+
+	processor = &processorMock{}
+	summary, err = NewReindexer(processor, config, logger.NewUPPLogger("test", "PANIC")).Run(context.Background(), input)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, processor.processed)
+	assert.Equal(t, int64(2), summary.Resumed)
+	assert.Equal(t, int64(1), summary.Indexed)
+	saved, err = loadCheckpoint(checkpointFile)
+	require.NoError(t, err)
+	assert.Empty(t, saved.Failed)
+	assert.True(t, saved.completed(input))
+}
+
+func TestCheckpointCountsLeadingLinesOnly(t *testing.T) {
+	c, err := loadCheckpoint("")
+	require.NoError(t, err)
+
+	c.markDone("events.jsonl", 2)
+	c.markDone("events.jsonl", 3)
+	assert.Equal(t, 0, c.Done["events.jsonl"])
+	assert.False(t, c.done("events.jsonl", 2))
+
+	c.markDone("events.jsonl", 1)
+	assert.Equal(t, 3, c.Done["events.jsonl"])
+	assert.True(t, c.done("events.jsonl", 2))
+	assert.False(t, c.done("events.jsonl", 4))
+}
+
+func TestReindexMissingInput(t *testing.T) {
+	_, err := NewReindexer(&processorMock{}, Config{}, logger.NewUPPLogger("test", "PANIC")).Run(context.Background(), "/does/not/exist")
+
+	assert.Error(t, err)
+}
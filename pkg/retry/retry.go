@@ -0,0 +1,133 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+// Policy describes how many times a failing call is attempted and how long to wait in between.
+// The wait grows exponentially from InitialBackoff up to MaxBackoff and Jitter (0..1) randomly
+// shortens it, so that callers failing at the same time do not retry in lockstep.
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+// Observer is notified when a call is retried and when it fails after the last attempt.
+type Observer interface {
+	Retried(operation string, attempt int, err error, wait time.Duration)
+	Exhausted(operation string, attempts int, err error)
+}
+
+type Retrier struct {
+	policy   Policy
+	observer Observer
+	sleep    func(context.Context, time.Duration) error
+}
+
+func NewRetrier(policy Policy, observer Observer) *Retrier {
+	return &Retrier{policy: policy, observer: observer, sleep: sleep}
+}
+
+// Do calls fn until it succeeds, returns an error that isRetryable rejects or the attempts are exhausted.
+// It gives up as soon as ctx is done, returning the last error of fn or the error of ctx while waiting.
+// A nil Retrier calls fn exactly once.
+func (r *Retrier) Do(ctx context.Context, operation string, fn func() error, isRetryable func(error) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r == nil {
+		return fn()
+	}
+
+	maxAttempts := r.policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= maxAttempts {
+			if r.observer != nil && maxAttempts > 1 {
+				r.observer.Exhausted(operation, attempt, err)
+			}
+			return err
+		}
+
+		wait := r.backoff(attempt)
+		if r.observer != nil {
+			r.observer.Retried(operation, attempt, err, wait)
+		}
+		if err := r.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Retrier) backoff(attempt int) time.Duration {
+	multiplier := r.policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	wait := float64(r.policy.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if r.policy.MaxBackoff > 0 && wait > float64(r.policy.MaxBackoff) {
+		wait = float64(r.policy.MaxBackoff)
+	}
+	if r.policy.Jitter > 0 {
+		wait -= wait * r.policy.Jitter * rand.Float64()
+	}
+	return time.Duration(wait)
+}
+
+// Observers notifies each of its observers in turn, e.g. to both log and count the retries.
+type Observers []Observer
+
+func (o Observers) Retried(operation string, attempt int, err error, wait time.Duration) {
+	for _, observer := range o {
+		observer.Retried(operation, attempt, err, wait)
+	}
+}
+
+func (o Observers) Exhausted(operation string, attempts int, err error) {
+	for _, observer := range o {
+		observer.Exhausted(operation, attempts, err)
+	}
+}
+
+// LogObserver logs every retry and every call that kept failing.
+type LogObserver struct {
+	log *logger.UPPLogger
+}
+
+func NewLogObserver(log *logger.UPPLogger) *LogObserver {
+	return &LogObserver{log: log}
+}
+
+func (o *LogObserver) Retried(operation string, attempt int, err error, wait time.Duration) {
+	o.log.WithError(err).Warnf("Attempt %d of %s failed, retrying in %v", attempt, operation, wait)
+}
+
+func (o *LogObserver) Exhausted(operation string, attempts int, err error) {
+	o.log.WithError(err).Errorf("Giving up on %s after %d attempts", operation, attempts)
+}
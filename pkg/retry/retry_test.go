@@ -0,0 +1,143 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+func isTransient(err error) bool {
+	return err == errTransient
+}
+
+type observerMock struct {
+	retried   []int
+	waits     []time.Duration
+	exhausted int
+}
+
+func (o *observerMock) Retried(operation string, attempt int, err error, wait time.Duration) {
+	o.retried = append(o.retried, attempt)
+	o.waits = append(o.waits, wait)
+}
+
+func (o *observerMock) Exhausted(operation string, attempts int, err error) {
+	o.exhausted = attempts
+}
+
+func newTestRetrier(policy Policy, observer Observer) *Retrier {
+	r := NewRetrier(policy, observer)
+	r.sleep = func(context.Context, time.Duration) error { return nil }
+	return r
+}
+
+func failing(failures int, err error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= failures {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func TestRetrierSucceedsAfterTransientFailures(t *testing.T) {
+	observer := &observerMock{}
+	r := newTestRetrier(Policy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, Multiplier: 2}, observer)
+	fn, calls := failing(2, errTransient)
+
+	err := r.Do(context.Background(), "test", fn, isTransient)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, []int{1, 2}, observer.retried)
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, observer.waits)
+	assert.Zero(t, observer.exhausted)
+}
+
+func TestRetrierGivesUpAfterMaxAttempts(t *testing.T) {
+	observer := &observerMock{}
+	r := newTestRetrier(Policy{MaxAttempts: 3}, observer)
+	fn, calls := failing(5, errTransient)
+
+	err := r.Do(context.Background(), "test", fn, isTransient)
+
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, 3, observer.exhausted)
+}
+
+func TestRetrierDoesNotRetryPermanentErrors(t *testing.T) {
+	observer := &observerMock{}
+	r := newTestRetrier(Policy{MaxAttempts: 3}, observer)
+	fn, calls := failing(1, errPermanent)
+
+	err := r.Do(context.Background(), "test", fn, isTransient)
+
+	assert.Equal(t, errPermanent, err)
+	assert.Equal(t, 1, *calls)
+	assert.Empty(t, observer.retried)
+}
+
+func TestNilRetrierCallsOnce(t *testing.T) {
+	var r *Retrier
+	fn, calls := failing(1, errTransient)
+
+	err := r.Do(context.Background(), "test", fn, isTransient)
+
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestRetrierStopsWhenContextIsDone(t *testing.T) {
+	observer := &observerMock{}
+	r := NewRetrier(Policy{MaxAttempts: 3, InitialBackoff: time.Hour}, observer)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fn, calls := failing(5, errTransient)
+
+	err := r.Do(ctx, "test", fn, isTransient)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, *calls)
+	assert.Zero(t, observer.exhausted)
+
+	fn, calls = failing(0, nil)
+	err = r.Do(ctx, "test", fn, isTransient)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Zero(t, *calls)
+}
+
+func TestRetrierBackoffIsCappedAndJittered(t *testing.T) {
+	r := NewRetrier(Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, Multiplier: 2, Jitter: 0.5}, nil)
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		wait := r.backoff(attempt)
+		assert.True(t, wait <= 300*time.Millisecond, "backoff %v exceeds the maximum", wait)
+		assert.True(t, wait >= 50*time.Millisecond, "backoff %v is shorter than the jitter allows", wait)
+	}
+}
+
+func TestObserversNotifiesEveryObserver(t *testing.T) {
+	first, second := &observerMock{}, &observerMock{}
+	r := newTestRetrier(Policy{MaxAttempts: 2}, Observers{first, second})
+	fn, _ := failing(5, errTransient)
+
+	err := r.Do(context.Background(), "test", fn, isTransient)
+
+	assert.Equal(t, errTransient, err)
+	for _, observer := range []*observerMock{first, second} {
+		assert.Equal(t, []int{1}, observer.retried)
+		assert.Equal(t, 2, observer.exhausted)
+	}
+}
@@ -1,5 +1,10 @@
 package schema
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 type IndexModel struct {
 	UID                        *string  `json:"uid"`
 	LastMetadataPublish        *string  `json:"last_metadata_publish"`
@@ -84,7 +89,16 @@ type IndexModel struct {
 	CompanyTickerCodeEditorial []string `json:"companyTickerCodeEditorial"`
 	ArticleTypes               []string `json:"articleTypes"`
 	ArticleBrands              []string `json:"articleBrands"`
+	PullQuotes                 []string `json:"pullQuotes"`
+	ImageCaptions              []string `json:"imageCaptions"`
+	LinkedContentIDs           []string `json:"linkedContentIds"`
+	ExternalLinks              []string `json:"externalLinks"`
+	Headings                   []string `json:"headings"`
 	PublishReference           string   `json:"publishReference"`
+
+	// Language is detected from the body, which is indexed again in the LanguageBody field of that language
+	Language     *string           `json:"language"`
+	LanguageBody map[string]string `json:"language_body"`
 }
 
 type EnrichedContent struct {
@@ -110,7 +124,7 @@ type Content struct {
 	Byline             string       `json:"byline"`
 	Standfirst         string       `json:"standfirst"`
 	Description        string       `json:"description"`
-	MainImage          string       `json:"mainImage"`
+	MainImage          MainImage    `json:"mainImage"`
 	PublishReference   string       `json:"publishReference"`
 	Type               string       `json:"type"`
 	DataSources        []dataSource `json:"dataSource"`
@@ -119,6 +133,64 @@ type Content struct {
 	CanBeDistributed   *string      `json:"canBeDistributed"`
 }
 
+// MainImage is the image set of a piece of content. Events carry the UUID of the image set only,
+// expanded payloads like the ones of internal-content-api carry the images of the set too.
+type MainImage struct {
+	UUID       string
+	ImageUUIDs []string
+}
+
+type expandedImage struct {
+	ID     string `json:"id,omitempty"`
+	APIURL string `json:"apiUrl,omitempty"`
+}
+
+type expandedImageSet struct {
+	expandedImage
+	Members []expandedImage `json:"members"`
+}
+
+func (m *MainImage) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*m = MainImage{UUID: id}
+		return nil
+	}
+
+	var imageSet expandedImageSet
+	if err := json.Unmarshal(data, &imageSet); err != nil {
+		return err
+	}
+	*m = MainImage{UUID: imageSet.uuid()}
+	for _, member := range imageSet.Members {
+		if uuid := member.uuid(); uuid != "" {
+			m.ImageUUIDs = append(m.ImageUUIDs, uuid)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes the UUID of the image set like events do, unless the images of the set are known.
+func (m MainImage) MarshalJSON() ([]byte, error) {
+	if len(m.ImageUUIDs) == 0 {
+		return json.Marshal(m.UUID)
+	}
+	imageSet := expandedImageSet{expandedImage: expandedImage{ID: m.UUID}}
+	for _, uuid := range m.ImageUUIDs {
+		imageSet.Members = append(imageSet.Members, expandedImage{ID: uuid})
+	}
+	return json.Marshal(imageSet)
+}
+
+// uuid is the last segment of the API URL of the image, or of its ID, e.g. http://www.ft.com/thing/{uuid}
+func (i expandedImage) uuid() string {
+	url := i.APIURL
+	if url == "" {
+		url = i.ID
+	}
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
 type dataSource struct {
 	Duration  int32  `json:"duration"`
 	MediaType string `json:"mediaType"`
@@ -148,4 +220,5 @@ type ContentType struct {
 	Collection string
 	Format     string
 	Category   string
+	MediaType  string
 }
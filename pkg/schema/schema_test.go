@@ -0,0 +1,63 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMainImageUnmarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected MainImage
+	}{
+		{
+			name:     "image set UUID of events",
+			json:     `"ad038207-bfe6-4805-a04c-864af12efef2"`,
+			expected: MainImage{UUID: "ad038207-bfe6-4805-a04c-864af12efef2"},
+		},
+		{
+			name:     "image set ID",
+			json:     `{"id":"http://www.ft.com/thing/ad038207-bfe6-4805-a04c-864af12efef2"}`,
+			expected: MainImage{UUID: "ad038207-bfe6-4805-a04c-864af12efef2"},
+		},
+		{
+			name: "expanded image set",
+			json: `{"apiUrl":"https://api.ft.com/content/ad038207-bfe6-4805-a04c-864af12efef2","id":"http://www.ft.com/thing/ad038207-bfe6-4805-a04c-864af12efef2",` +
+				`"members":[{"apiUrl":"https://api.ft.com/content/5546cbc4-d4f7-47f9-3f3e-941fb0799c4f","id":"http://www.ft.com/thing/f93fd066-380e-4f68-be63-c27f1fe2fddc"},{"id":"http://www.ft.com/thing/9baeda0d-cf5f-4e39-9d34-8a1ea53ad852"}]}`,
+			expected: MainImage{UUID: "ad038207-bfe6-4805-a04c-864af12efef2", ImageUUIDs: []string{"5546cbc4-d4f7-47f9-3f3e-941fb0799c4f", "9baeda0d-cf5f-4e39-9d34-8a1ea53ad852"}},
+		},
+		{
+			name: "null",
+			json: `null`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var image MainImage
+			require.NoError(t, json.Unmarshal([]byte(test.json), &image))
+			assert.Equal(t, test.expected, image)
+		})
+	}
+}
+
+func TestMainImageMarshalRoundTrip(t *testing.T) {
+	for _, image := range []MainImage{
+		{UUID: "ad038207-bfe6-4805-a04c-864af12efef2"},
+		{UUID: "ad038207-bfe6-4805-a04c-864af12efef2", ImageUUIDs: []string{"5546cbc4-d4f7-47f9-3f3e-941fb0799c4f"}},
+	} {
+		body, err := json.Marshal(image)
+		require.NoError(t, err)
+		var unmarshalled MainImage
+		require.NoError(t, json.Unmarshal(body, &unmarshalled))
+		assert.Equal(t, image, unmarshalled)
+	}
+
+	body, err := json.Marshal(Content{MainImage: MainImage{UUID: "ad038207-bfe6-4805-a04c-864af12efef2"}})
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"mainImage":"ad038207-bfe6-4805-a04c-864af12efef2"`)
+}
@@ -0,0 +1,121 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+)
+
+// Stdin is the path FileSource reads the standard input from.
+const Stdin = "-"
+
+// FileSource reads messages from a JSON lines file, one {"headers": {...}, "body": ...} object per line.
+// The body is either the message body as a string or the event itself, so dead-letter files and captured traffic
+// can both be replayed.
+type FileSource struct {
+	path     string
+	stdin    io.Reader
+	stop     chan struct{}
+	stopOnce sync.Once
+	log      *logger.UPPLogger
+}
+
+type fileMessage struct {
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+func NewFileSource(path string, log *logger.UPPLogger) *FileSource {
+	return &FileSource{path: path, stdin: os.Stdin, stop: make(chan struct{}), log: log}
+}
+
+// Start returns once all messages are read.
+func (s *FileSource) Start(handle Handle) {
+	in := s.stdin
+	if s.path != Stdin {
+		f, err := os.Open(s.path)
+		if err != nil {
+			s.log.WithError(err).Errorf("Could not open message file %s", s.path)
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var read, skipped int
+	scanner := bufio.NewScanner(in)
+	// message bodies can be far bigger than the default token size of the scanner
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		select {
+		case <-s.stop:
+			s.log.Infof("Stopped reading messages from %s after %d messages", s.name(), read)
+			return
+		default:
+		}
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		msg, err := parseFileMessage(line)
+		if err != nil {
+			skipped++
+			s.log.WithError(err).Errorf("Skipping malformed message on line %d of %s", read+skipped, s.name())
+			continue
+		}
+		read++
+		// nothing is committed for a file
+		handle(msg, func() {})
+	}
+	if err := scanner.Err(); err != nil {
+		s.log.WithError(err).Errorf("Could not read messages from %s", s.name())
+	}
+	s.log.Infof("Read %d messages from %s, skipped %d malformed lines", read, s.name(), skipped)
+}
+
+func (s *FileSource) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *FileSource) ConnectivityCheck() (string, error) {
+	if s.path == Stdin {
+		return "Reading messages from stdin", nil
+	}
+	if _, err := os.Stat(s.path); err != nil {
+		return "Could not read message file", err
+	}
+	return "Reading messages from " + s.path, nil
+}
+
+func (s *FileSource) name() string {
+	if s.path == Stdin {
+		return "stdin"
+	}
+	return s.path
+}
+
+func parseFileMessage(line []byte) (consumer.Message, error) {
+	var m fileMessage
+	if err := json.Unmarshal(line, &m); err != nil {
+		return consumer.Message{}, err
+	}
+	msg := consumer.Message{Headers: m.Headers, Body: string(m.Body)}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	if len(m.Body) > 0 && m.Body[0] == '"' {
+		if err := json.Unmarshal(m.Body, &msg.Body); err != nil {
+			return consumer.Message{}, err
+		}
+	}
+	return msg, nil
+}
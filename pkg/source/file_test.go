@@ -0,0 +1,89 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const messageLines = `{"headers":{"X-Request-Id":"tid_1"},"body":"{\"uuid\":\"a0000000-0000-0000-0000-000000000000\"}"}
+
+{"headers":{"X-Request-Id":"tid_2","Content-Type":"application/vnd.ft-upp-article+json"},"body":{"uuid":"b0000000-0000-0000-0000-000000000000"}}
+not json
+{"id":"2e4f8a","transactionId":"tid_3","stage":"write","error":"timeout","headers":{"X-Request-Id":"tid_3"},"body":"{}"}
+`
+
+func collect(s Source) []consumer.Message {
+	var msgs []consumer.Message
+	s.Start(func(msg consumer.Message, handled func()) {
+		msgs = append(msgs, msg)
+	})
+	return msgs
+}
+
+func TestFileSourceReadsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "messages.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(messageLines), 0600))
+
+	s := NewFileSource(path, logger.NewUPPLogger("test", "PANIC"))
+	status, err := s.ConnectivityCheck()
+	require.NoError(t, err)
+	assert.Equal(t, "Reading messages from "+path, status)
+
+	msgs := collect(s)
+
+	assert.Equal(t, []consumer.Message{
+		{Headers: map[string]string{"X-Request-Id": "tid_1"}, Body: `{"uuid":"a0000000-0000-0000-0000-000000000000"}`},
+		{Headers: map[string]string{"X-Request-Id": "tid_2", "Content-Type": "application/vnd.ft-upp-article+json"}, Body: `{"uuid":"b0000000-0000-0000-0000-000000000000"}`},
+		// dead-letter entries are read as messages
+		{Headers: map[string]string{"X-Request-Id": "tid_3"}, Body: `{}`},
+	}, msgs)
+}
+
+func TestFileSourceReadsStdin(t *testing.T) {
+	s := NewFileSource(Stdin, logger.NewUPPLogger("test", "PANIC"))
+	s.stdin = strings.NewReader(messageLines)
+
+	status, err := s.ConnectivityCheck()
+	require.NoError(t, err)
+	assert.Equal(t, "Reading messages from stdin", status)
+	assert.Len(t, collect(s), 3)
+}
+
+func TestFileSourceStops(t *testing.T) {
+	s := NewFileSource(Stdin, logger.NewUPPLogger("test", "PANIC"))
+	s.stdin = strings.NewReader(messageLines)
+
+	var msgs []consumer.Message
+	s.Start(func(msg consumer.Message, handled func()) {
+		msgs = append(msgs, msg)
+		s.Stop()
+	})
+
+	assert.Len(t, msgs, 1)
+}
+
+func TestFileSourceMissingFile(t *testing.T) {
+	s := NewFileSource("/does/not/exist.jsonl", logger.NewUPPLogger("test", "PANIC"))
+
+	_, err := s.ConnectivityCheck()
+	assert.Error(t, err)
+	assert.Empty(t, collect(s))
+}
+
+func TestValidateKind(t *testing.T) {
+	for _, kind := range []string{KindKafkaProxy, KindKafka, KindFile} {
+		assert.NoError(t, ValidateKind(kind))
+	}
+	assert.EqualError(t, ValidateKind("sqs"), `unknown message source "sqs", expected one of kafka-proxy, kafka, file`)
+}
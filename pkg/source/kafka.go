@@ -0,0 +1,248 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/Shopify/sarama"
+)
+
+const (
+	ftMessageVersionPrefix = "FTMSG/"
+	kafkaRetryInterval     = 10 * time.Second
+)
+
+// KafkaConfig tells which topic the consumer group reads from.
+type KafkaConfig struct {
+	Brokers  []string
+	Group    string
+	Topic    string
+	ClientID string
+}
+
+// KafkaSource reads the messages from the Kafka brokers as a member of a consumer group, without kafka-proxy.
+// Offsets are committed once the messages are processed, so a message is redelivered after a crash, a rebalance
+// or a rejection unless it and all the messages before it in its partition were processed.
+type KafkaSource struct {
+	config       KafkaConfig
+	saramaConfig *sarama.Config
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	mu     sync.RWMutex
+	client sarama.Client
+
+	log *logger.UPPLogger
+}
+
+func NewKafkaSource(config KafkaConfig, log *logger.UPPLogger) *KafkaSource {
+	saramaConfig := sarama.NewConfig()
+	// consumer groups and record headers need Kafka 0.11 at least
+	saramaConfig.Version = sarama.V1_0_0_0
+	if config.ClientID != "" {
+		saramaConfig.ClientID = config.ClientID
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &KafkaSource{config: config, saramaConfig: saramaConfig, ctx: ctx, cancel: cancel, log: log}
+}
+
+func (s *KafkaSource) Start(handle Handle) {
+	group, client := s.connect()
+	if group == nil {
+		return
+	}
+	defer func() {
+		if err := group.Close(); err != nil {
+			s.log.WithError(err).Error("Could not close Kafka consumer group")
+		}
+		if err := client.Close(); err != nil {
+			s.log.WithError(err).Error("Could not close Kafka client")
+		}
+	}()
+
+	handler := groupHandler{handle: handle}
+	for s.ctx.Err() == nil {
+		// Consume returns on every rebalance of the group
+		if err := group.Consume(s.ctx, []string{s.config.Topic}, handler); err != nil {
+			s.log.WithError(err).Error("Failed to consume from Kafka")
+			s.wait()
+		}
+	}
+}
+
+// connect retries until the brokers are reachable or the source is stopped.
+func (s *KafkaSource) connect() (sarama.ConsumerGroup, sarama.Client) {
+	for s.ctx.Err() == nil {
+		client, err := sarama.NewClient(s.config.Brokers, s.saramaConfig)
+		if err != nil {
+			s.log.WithError(err).Errorf("Could not connect to Kafka brokers %v", s.config.Brokers)
+			s.wait()
+			continue
+		}
+		group, err := sarama.NewConsumerGroupFromClient(s.config.Group, client)
+		if err != nil {
+			s.log.WithError(err).Errorf("Could not join Kafka consumer group %s", s.config.Group)
+			_ = client.Close()
+			s.wait()
+			continue
+		}
+
+		s.mu.Lock()
+		s.client = client
+		s.mu.Unlock()
+		s.log.Infof("Joined Kafka consumer group %s on topic %s", s.config.Group, s.config.Topic)
+		return group, client
+	}
+	return nil, nil
+}
+
+func (s *KafkaSource) wait() {
+	select {
+	case <-s.ctx.Done():
+	case <-time.After(kafkaRetryInterval):
+	}
+}
+
+func (s *KafkaSource) Stop() {
+	s.cancel()
+}
+
+func (s *KafkaSource) ConnectivityCheck() (string, error) {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+	if client == nil {
+		return "Could not connect to Kafka", errors.New("not connected to the Kafka brokers yet")
+	}
+	if err := client.RefreshMetadata(s.config.Topic); err != nil {
+		return "Could not read the Kafka topic metadata", err
+	}
+	return "Connected to Kafka", nil
+}
+
+// groupHandler hands the messages of the claimed partitions over one by one and marks them as consumed once processed.
+type groupHandler struct {
+	handle Handle
+}
+
+func (groupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (groupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim returns once the messages of the claim are processed, or without waiting for them when the session ends.
+// The messages processed after the end of the session are not marked, the next owner of the partition reads them again.
+func (h groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	offsets := &offsetTracker{session: session}
+	var processing sync.WaitGroup
+	for record := range claim.Messages() {
+		handled := offsets.track(record)
+		processing.Add(1)
+		h.handle(kafkaMessage(record), func() {
+			defer processing.Done()
+			handled()
+		})
+	}
+
+	processed := make(chan struct{})
+	go func() {
+		processing.Wait()
+		close(processed)
+	}()
+	select {
+	case <-processed:
+	case <-session.Context().Done():
+	}
+	return nil
+}
+
+// offsetTracker marks the messages of a partition in the order they were read, as they may be processed out of order:
+// a message is only marked once all the messages read before it are processed.
+type offsetTracker struct {
+	session sarama.ConsumerGroupSession
+	mu      sync.Mutex
+	pending []*trackedRecord
+}
+
+type trackedRecord struct {
+	record    *sarama.ConsumerMessage
+	processed bool
+}
+
+// track records a message read from the partition, the returned func tells that it is processed.
+func (t *offsetTracker) track(record *sarama.ConsumerMessage) func() {
+	tracked := &trackedRecord{record: record}
+	t.mu.Lock()
+	t.pending = append(t.pending, tracked)
+	t.mu.Unlock()
+	return func() {
+		t.processed(tracked)
+	}
+}
+
+func (t *offsetTracker) processed(tracked *trackedRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked.processed = true
+	var last *sarama.ConsumerMessage
+	for len(t.pending) > 0 && t.pending[0].processed {
+		last = t.pending[0].record
+		t.pending = t.pending[1:]
+	}
+	if last != nil && t.session.Context().Err() == nil {
+		t.session.MarkMessage(last, "")
+	}
+}
+
+// kafkaMessage reads a record in the FT message format, record headers are only used when the format lacks them.
+func kafkaMessage(record *sarama.ConsumerMessage) consumer.Message {
+	msg := parseFTMessage(string(record.Value))
+	for _, header := range record.Headers {
+		if header == nil {
+			continue
+		}
+		if _, found := msg.Headers[string(header.Key)]; !found {
+			msg.Headers[string(header.Key)] = string(header.Value)
+		}
+	}
+	return msg
+}
+
+// parseFTMessage reads the FT message format kafka-proxy decodes for its consumers: a version line, header lines,
+// an empty line and the body. Values without the version line are taken as the body.
+func parseFTMessage(raw string) consumer.Message {
+	msg := consumer.Message{Headers: make(map[string]string)}
+	if !strings.HasPrefix(raw, ftMessageVersionPrefix) {
+		msg.Body = strings.TrimSpace(raw)
+		return msg
+	}
+
+	headerSection, body := raw, ""
+	// the format uses CRLF line endings, UNIX ones are accepted too
+	for _, separator := range []string{"\r\n\r\n", "\n\n"} {
+		if i := strings.Index(raw, separator); i != -1 {
+			headerSection, body = raw[:i], raw[i+len(separator):]
+			break
+		}
+	}
+	// the first line is the version
+	for _, line := range strings.Split(headerSection, "\n")[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		i := strings.Index(line, ":")
+		if i < 1 {
+			continue
+		}
+		msg.Headers[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	msg.Body = strings.TrimSpace(body)
+	return msg
+}
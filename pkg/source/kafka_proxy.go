@@ -0,0 +1,52 @@
+package source
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+)
+
+// KafkaProxySource reads the messages through kafka-proxy. The consumer commits the offsets of a batch once its messages
+// are processed, so a crash redelivers the batches still in the workers.
+type KafkaProxySource struct {
+	mu       sync.RWMutex
+	consumer consumer.MessageConsumer
+	handle   Handle
+}
+
+func NewKafkaProxySource(config consumer.QueueConfig, client *http.Client) *KafkaProxySource {
+	s := &KafkaProxySource{}
+	s.consumer = consumer.NewBatchedConsumer(config, s.dispatch, client)
+	return s
+}
+
+func (s *KafkaProxySource) Start(handle Handle) {
+	s.mu.Lock()
+	s.handle = handle
+	s.mu.Unlock()
+	s.consumer.Start()
+}
+
+func (s *KafkaProxySource) Stop() {
+	s.consumer.Stop()
+}
+
+func (s *KafkaProxySource) ConnectivityCheck() (string, error) {
+	return s.consumer.ConnectivityCheck()
+}
+
+// dispatch is handed to the consumer when it is created, before the handler is known. It hands every message of the
+// batch over and returns once they are all processed, the consumer only commits the batch then. A batch with rejected
+// messages is never committed, kafka-proxy hands it over again once the consumer instance expires.
+func (s *KafkaProxySource) dispatch(msgs []consumer.Message) {
+	s.mu.RLock()
+	handle := s.handle
+	s.mu.RUnlock()
+	var processing sync.WaitGroup
+	for _, msg := range msgs {
+		processing.Add(1)
+		handle(msg, processing.Done)
+	}
+	processing.Wait()
+}
@@ -0,0 +1,45 @@
+package source
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaProxyDispatchWaitsForTheBatch(t *testing.T) {
+	var mu sync.Mutex
+	var pending []func()
+	s := &KafkaProxySource{handle: func(msg consumer.Message, handled func()) {
+		mu.Lock()
+		pending = append(pending, handled)
+		mu.Unlock()
+	}}
+
+	dispatched := make(chan struct{})
+	go func() {
+		s.dispatch([]consumer.Message{{Body: "1"}, {Body: "2"}})
+		close(dispatched)
+	}()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(pending) == 2
+	}, time.Second, time.Millisecond)
+	pending[0]()
+	select {
+	case <-dispatched:
+		assert.Fail(t, "the batch must not be committed before all its messages are processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pending[1]()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the batch is processed")
+	}
+}
@@ -0,0 +1,159 @@
+package source
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+type sessionMock struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *sessionMock) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *sessionMock) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+type claimMock struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *claimMock) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func TestParseFTMessage(t *testing.T) {
+	msg := parseFTMessage("FTMSG/1.0\r\nX-Request-Id: tid_1\r\nOrigin-System-Id: http://cmdb.ft.com/systems/methode-web-pub\r\nContent-Type: application/json\r\n\r\n{\"uuid\":\"a0000000-0000-0000-0000-000000000000\"}\r\n")
+
+	assert.Equal(t, consumer.Message{
+		Headers: map[string]string{
+			"X-Request-Id":     "tid_1",
+			"Origin-System-Id": "http://cmdb.ft.com/systems/methode-web-pub",
+			"Content-Type":     "application/json",
+		},
+		Body: `{"uuid":"a0000000-0000-0000-0000-000000000000"}`,
+	}, msg)
+}
+
+func TestParseFTMessageWithUnixLineEndings(t *testing.T) {
+	msg := parseFTMessage("FTMSG/1.0\nX-Request-Id: tid_1\n\n{\"body\":\"a\\r\\nb\"}")
+
+	assert.Equal(t, map[string]string{"X-Request-Id": "tid_1"}, msg.Headers)
+	assert.Equal(t, `{"body":"a\r\nb"}`, msg.Body)
+}
+
+func TestParseFTMessageWithoutEnvelope(t *testing.T) {
+	msg := parseFTMessage(`{"uuid":"a0000000-0000-0000-0000-000000000000"}`)
+
+	assert.Empty(t, msg.Headers)
+	assert.Equal(t, `{"uuid":"a0000000-0000-0000-0000-000000000000"}`, msg.Body)
+}
+
+func TestGroupHandlerConsumesClaim(t *testing.T) {
+	claim := &claimMock{messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{
+		Offset: 41,
+		Value:  []byte("FTMSG/1.0\r\nX-Request-Id: tid_1\r\n\r\n{}"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("X-Request-Id"), Value: []byte("tid_ignored")},
+			{Key: []byte("Origin-System-Id"), Value: []byte("http://cmdb.ft.com/systems/cct")},
+		},
+	}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 42, Value: []byte("{}")}
+	close(claim.messages)
+	session := &sessionMock{}
+
+	var msgs []consumer.Message
+	err := groupHandler{handle: func(msg consumer.Message, handled func()) {
+		msgs = append(msgs, msg)
+		handled()
+	}}.ConsumeClaim(session, claim)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []consumer.Message{
+		{Headers: map[string]string{"X-Request-Id": "tid_1", "Origin-System-Id": "http://cmdb.ft.com/systems/cct"}, Body: "{}"},
+		{Headers: map[string]string{}, Body: "{}"},
+	}, msgs)
+	assert.Equal(t, []int64{41, 42}, session.marked)
+}
+
+func TestGroupHandlerMarksProcessedMessagesInOrder(t *testing.T) {
+	claim := &claimMock{messages: make(chan *sarama.ConsumerMessage, 3)}
+	for offset := int64(1); offset <= 3; offset++ {
+		claim.messages <- &sarama.ConsumerMessage{Offset: offset, Value: []byte("{}")}
+	}
+	close(claim.messages)
+	session := &sessionMock{}
+
+	// the messages are processed after being handed over, out of order
+	handedOver := make(chan func(), 3)
+	done := make(chan error)
+	go func() {
+		done <- groupHandler{handle: func(msg consumer.Message, handled func()) {
+			handedOver <- handled
+		}}.ConsumeClaim(session, claim)
+	}()
+	first, second, third := <-handedOver, <-handedOver, <-handedOver
+
+	third()
+	assert.Empty(t, session.marked)
+	first()
+	assert.Equal(t, []int64{1}, session.marked)
+	second()
+	assert.Equal(t, []int64{1, 3}, session.marked)
+	assert.NoError(t, <-done)
+}
+
+func TestGroupHandlerDoesNotMarkMessagesAfterRejection(t *testing.T) {
+	claim := &claimMock{messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 1, Value: []byte("rejected")}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 2, Value: []byte("processed")}
+	close(claim.messages)
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &sessionMock{ctx: ctx}
+
+	handedOver := make(chan struct{}, 2)
+	done := make(chan error)
+	go func() {
+		done <- groupHandler{handle: func(msg consumer.Message, handled func()) {
+			if msg.Body == "processed" {
+				handled()
+			}
+			handedOver <- struct{}{}
+		}}.ConsumeClaim(session, claim)
+	}()
+	<-handedOver
+	<-handedOver
+	// the session ends with the shutdown, ConsumeClaim doesn't wait for the rejected message
+	cancel()
+
+	assert.NoError(t, <-done)
+	assert.Empty(t, session.marked)
+}
+
+func TestKafkaSourceNotConnected(t *testing.T) {
+	s := NewKafkaSource(KafkaConfig{Brokers: []string{"localhost:1"}, Group: "group", Topic: "topic"}, logger.NewUPPLogger("test", "PANIC"))
+
+	_, err := s.ConnectivityCheck()
+	assert.Error(t, err)
+
+	// a stopped source doesn't try to connect
+	s.Stop()
+	s.Start(func(msg consumer.Message, handled func()) {
+		t.Fail()
+	})
+}
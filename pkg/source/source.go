@@ -0,0 +1,37 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Financial-Times/message-queue-gonsumer/consumer"
+)
+
+// Kinds of message sources selected on the command line.
+const (
+	KindKafkaProxy = "kafka-proxy"
+	KindKafka      = "kafka"
+	KindFile       = "file"
+)
+
+// Handle takes a message over. handled is called once the message is processed, possibly after Handle returned,
+// and isn't called for a message that was rejected.
+type Handle func(msg consumer.Message, handled func())
+
+// Source delivers the messages to index.
+type Source interface {
+	// Start hands every message to handle until the source is exhausted or stopped, it blocks meanwhile.
+	Start(handle Handle)
+	Stop()
+	// ConnectivityCheck tells whether messages can be read, in the format of the health checks.
+	ConnectivityCheck() (string, error)
+}
+
+// ValidateKind fails for unknown kinds of sources.
+func ValidateKind(kind string) error {
+	switch kind {
+	case KindKafkaProxy, KindKafka, KindFile:
+		return nil
+	}
+	return fmt.Errorf("unknown message source %q, expected one of %s", kind, strings.Join([]string{KindKafkaProxy, KindKafka, KindFile}, ", "))
+}